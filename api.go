@@ -0,0 +1,515 @@
+/**
+ * Invoice REST API over net/http.
+ *
+ *   GET  /invoices                 list, filter with customer, paid, from, to
+ *                                  and page with page and per_page
+ *   POST /invoices                 create
+ *   GET  /invoices/{id}            get one
+ *   PUT  /invoices/{id}            update, honours If-Match
+ *   POST /invoices/{id}/payments   record a payment
+ *
+ * GET responses carry an ETag and answer If-None-Match with 304. The ETag
+ * of an invoice is that of the invoice whatever format it is sent in, so it
+ * can be given to If-Match. The Accept header selects the format, see
+ * downloadFormats. Errors are JSON bodies of
+ * the form {"Status": 404, "Error": "..."}.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+	maxBodySize    = 1 << 20
+)
+
+// invoiceStore is what the API needs from its storage. AuditLog implements
+// it, so every change made through the API is journaled.
+type invoiceStore interface {
+	Invoice(id int) (*Invoice, bool)
+	Invoices() []*Invoice
+	Create(actor string, invoice *Invoice) error
+	Update(actor string, invoice *Invoice, etag string) error
+	Pay(actor string, id int, payment *PaymentRecord) error
+}
+
+// downloadFormat writes invoices in one media type.
+type downloadFormat struct {
+	mediaType string
+	write     func(writer io.Writer, invoices []*Invoice) error
+}
+
+// writeInvoiceFormat returns a writer for an invoice file suffix.
+func writeInvoiceFormat(suffix string) func(io.Writer, []*Invoice) error {
+	return func(writer io.Writer, invoices []*Invoice) error {
+		return writeInvoices(writer, suffix, invoices)
+	}
+}
+
+// downloadFormats are the formats the API can answer with, the first being
+// the default. The invoice file formats are the same as writeInvoices.
+var downloadFormats = []downloadFormat{
+	{"application/json", func(writer io.Writer, invoices []*Invoice) error {
+		return json.NewEncoder(writer).Encode(invoices)
+	}},
+	{"application/x-invoices+json", writeInvoiceFormat(".json")},
+	{"application/gzip", func(writer io.Writer, invoices []*Invoice) error {
+		compressor := gzip.NewWriter(writer)
+		if err := writeInvoices(compressor, ".json", invoices); err != nil {
+			return err
+		}
+		return compressor.Close()
+	}},
+}
+
+// apiError is the body of every error response.
+type apiError struct {
+	Status int
+	Error  string
+}
+
+// invoiceAPI serves the invoices of a store.
+type invoiceAPI struct {
+	store invoiceStore
+}
+
+func newInvoiceAPI(store invoiceStore) http.Handler {
+	return &invoiceAPI{store}
+}
+
+func (api *invoiceAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "invoices" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, "no such resource")
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			api.list(w, r)
+		case http.MethodPost:
+			api.create(w, r)
+		default:
+			methodNotAllowed(w, "GET, HEAD, POST")
+		}
+		return
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		writeError(w, http.StatusNotFound, "invalid invoice Id")
+		return
+	}
+	if len(parts) == 3 {
+		if parts[2] != "payments" {
+			writeError(w, http.StatusNotFound, "no such resource")
+		} else if r.Method != http.MethodPost {
+			methodNotAllowed(w, "POST")
+		} else {
+			api.pay(w, r, id)
+		}
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		api.get(w, r, id)
+	case http.MethodPut:
+		api.update(w, r, id)
+	default:
+		methodNotAllowed(w, "GET, HEAD, PUT")
+	}
+}
+
+// invoiceFilter selects invoices by the list query parameters.
+type invoiceFilter struct {
+	customer *int
+	paid     *bool
+	from, to time.Time
+}
+
+func parseFilter(query url.Values) (invoiceFilter, error) {
+	var filter invoiceFilter
+	if value := query.Get("customer"); value != "" {
+		customer, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid customer %q", value)
+		}
+		filter.customer = &customer
+	}
+	if value := query.Get("paid"); value != "" {
+		paid, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid paid %q", value)
+		}
+		filter.paid = &paid
+	}
+	for name, date := range map[string]*time.Time{"from": &filter.from, "to": &filter.to} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(dateFormat, value)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s date %q, use %s", name, value, dateFormat)
+			}
+			*date = parsed
+		}
+	}
+	return filter, nil
+}
+
+func (f invoiceFilter) match(invoice *Invoice) bool {
+	switch {
+	case f.customer != nil && invoice.CustomerId != *f.customer:
+		return false
+	case f.paid != nil && invoice.Paid != *f.paid:
+		return false
+	case !f.from.IsZero() && invoice.Raised.Before(f.from):
+		return false
+	case !f.to.IsZero() && invoice.Raised.After(f.to):
+		return false
+	}
+	return true
+}
+
+func parsePage(query url.Values) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			return 0, 0, fmt.Errorf("invalid page %q", value)
+		}
+	}
+	if value := query.Get("per_page"); value != "" {
+		if perPage, err = strconv.Atoi(value); err != nil || perPage < 1 || perPage > maxPerPage {
+			return 0, 0, fmt.Errorf("per_page must be between 1 and %d", maxPerPage)
+		}
+	}
+	return page, perPage, nil
+}
+
+func (api *invoiceAPI) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := parseFilter(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	page, perPage, err := parsePage(query)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var matched []*Invoice
+	for _, invoice := range api.store.Invoices() {
+		if filter.match(invoice) {
+			matched = append(matched, invoice)
+		}
+	}
+	total := len(matched)
+	start, end := (page-1)*perPage, page*perPage
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	setPageLinks(w, r, page, perPage, total)
+	writeInvoicesResponse(w, r, matched[start:end], "")
+}
+
+// setPageLinks adds an RFC 5988 Link header with the neighbouring pages.
+func setPageLinks(w http.ResponseWriter, r *http.Request, page, perPage, total int) {
+	link := func(page int, rel string) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("per_page", strconv.Itoa(perPage))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+	}
+	var links []string
+	if page > 1 {
+		links = append(links, link(page-1, "prev"))
+	}
+	if page*perPage < total {
+		links = append(links, link(page+1, "next"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+func (api *invoiceAPI) get(w http.ResponseWriter, r *http.Request, id int) {
+	invoice, ok := api.store.Invoice(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("invoice %d not found", id))
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	// Only the default format gets the invoice on its own, the others are
+	// invoice files holding one invoice.
+	if format, ok := negotiate(r); !ok || format.mediaType != downloadFormats[0].mediaType {
+		etag, err := etagOf(invoice)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeInvoicesResponse(w, r, []*Invoice{invoice}, etag)
+		return
+	}
+	writeJSON(w, r, http.StatusOK, invoice)
+}
+
+func (api *invoiceAPI) create(w http.ResponseWriter, r *http.Request) {
+	var invoice Invoice
+	if !readJSON(w, r, &invoice) {
+		return
+	}
+	if err := invoice.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Payments are only added through the payments resource and dunning
+	// records by the dunning run.
+	invoice.Payments, invoice.Paid, invoice.Dunning = nil, false, nil
+	if err := api.store.Create(actorOf(r), &invoice); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/invoices/%d", invoice.Id))
+	writeJSON(w, r, http.StatusCreated, &invoice)
+}
+
+func (api *invoiceAPI) update(w http.ResponseWriter, r *http.Request, id int) {
+	current, ok := api.store.Invoice(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("invoice %d not found", id))
+		return
+	}
+	var invoice Invoice
+	if !readJSON(w, r, &invoice) {
+		return
+	}
+	if invoice.Id == 0 {
+		invoice.Id = id
+	}
+	if invoice.Id != id {
+		writeError(w, http.StatusBadRequest, "invoice Id does not match the URL")
+		return
+	}
+	if err := invoice.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	match := r.Header.Get("If-Match")
+	if match == "*" {
+		match = ""
+	}
+	for {
+		// Payments and dunning records are kept as they are. Without
+		// If-Match the update is still made against the invoice read here,
+		// so a payment made meanwhile is not lost but read again. Paid
+		// follows the payments when there are any.
+		invoice.Payments, invoice.Dunning = current.Payments, current.Dunning
+		if len(invoice.Payments) > 0 {
+			invoice.Paid = invoice.settled()
+		}
+		etag := match
+		if etag == "" {
+			etag, _ = etagOf(current)
+		}
+		err := api.store.Update(actorOf(r), &invoice, etag)
+		if errors.Is(err, ErrInvoiceChanged) && match == "" {
+			if current, ok = api.store.Invoice(id); ok {
+				continue
+			}
+			err = fmt.Errorf("invoice %d: %w", id, ErrInvoiceNotFound)
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		break
+	}
+	writeJSON(w, r, http.StatusOK, &invoice)
+}
+
+func (api *invoiceAPI) pay(w http.ResponseWriter, r *http.Request, id int) {
+	var payment PaymentRecord
+	if !readJSON(w, r, &payment) {
+		return
+	}
+	if err := api.store.Pay(actorOf(r), id, &payment); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	invoice, _ := api.store.Invoice(id)
+	writeJSON(w, r, http.StatusCreated, invoice)
+}
+
+// actorOf names who made a change, for the audit journal.
+func actorOf(r *http.Request) string {
+	if actor := r.Header.Get("X-Actor"); actor != "" {
+		return actor
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	return "api"
+}
+
+// negotiate picks the first acceptable format of the Accept header.
+func negotiate(r *http.Request) (downloadFormat, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return downloadFormats[0], true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return downloadFormats[0], true
+		}
+		for _, format := range downloadFormats {
+			if format.mediaType == mediaType {
+				return format, true
+			}
+		}
+	}
+	return downloadFormat{}, false
+}
+
+// writeInvoicesResponse writes the invoices in the negotiated format. An
+// empty etag is taken from the body.
+func writeInvoicesResponse(w http.ResponseWriter, r *http.Request, invoices []*Invoice, etag string) {
+	format, ok := negotiate(r)
+	if !ok {
+		types := make([]string, len(downloadFormats))
+		for i, format := range downloadFormats {
+			types[i] = format.mediaType
+		}
+		writeError(w, http.StatusNotAcceptable, "supported types: "+strings.Join(types, ", "))
+		return
+	}
+	if invoices == nil {
+		invoices = []*Invoice{}
+	}
+	var body bytes.Buffer
+	if err := format.write(&body, invoices); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if etag == "" {
+		etag = etagOfBytes(body.Bytes())
+	}
+	w.Header().Set("Vary", "Accept")
+	writeBody(w, r, http.StatusOK, format.mediaType, etag, body.Bytes())
+}
+
+func etagOf(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return etagOfBytes(data), nil
+}
+
+func etagOfBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeBody(w, r, status, "application/json", etagOfBytes(data), data)
+}
+
+// writeBody writes a response with an ETag, or 304 when the client has it.
+func writeBody(w http.ResponseWriter, r *http.Request, status int, contentType, etag string, body []byte) {
+	w.Header().Set("ETag", etag)
+	if status == http.StatusOK && r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiError{status, message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvoiceNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvoiceExists):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrInvoiceChanged):
+		writeError(w, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, ErrInvalidPayment):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// readJSON decodes the request body into v, or answers 400 and returns
+// false.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "address to listen on")
+	journal := flags.String("journal", "invoices.journal", "audit journal holding the invoices")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(*journal)
+	if err != nil {
+		return err
+	}
+	defer auditLog.Close()
+	log.Infof("Serving %d invoices from %s on http://%s/invoices",
+		len(auditLog.Invoices()), *journal, *addr)
+	return http.ListenAndServe(*addr, newInvoiceAPI(auditLog))
+}
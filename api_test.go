@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestAPI serves the API over a journal in a temporary directory, until
+// the returned function is called.
+func newTestAPI(t *testing.T) (*httptest.Server, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := openAuditLog(filepath.Join(dir, "invoices.journal"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(newInvoiceAPI(auditLog))
+	return server, func() {
+		server.Close()
+		auditLog.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// call sends a request and returns the response with its body read.
+func call(t *testing.T, server *httptest.Server, method, path, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	request, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range header {
+		request.Header.Set(name, value)
+	}
+	response, err := server.Client().Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response, string(data)
+}
+
+const testInvoiceJSON = `{"Id":1,"CustomerId":7,"Raised":"2020-01-01","Due":"2020-01-31",` +
+	`"Items":[{"Id":"A","Price":10,"Quantity":2}]}`
+
+func TestAPI(t *testing.T) {
+	server, stop := newTestAPI(t)
+	defer stop()
+
+	response, _ := call(t, server, "POST", "/invoices", testInvoiceJSON, nil)
+	if response.StatusCode != http.StatusCreated || response.Header.Get("Location") != "/invoices/1" {
+		t.Fatalf("create: %s, Location %q", response.Status, response.Header.Get("Location"))
+	}
+	response, _ = call(t, server, "POST", "/invoices", testInvoiceJSON, nil)
+	if response.StatusCode != http.StatusConflict {
+		t.Errorf("create twice: %s", response.Status)
+	}
+
+	response, body := call(t, server, "GET", "/invoices/1", "", nil)
+	if response.StatusCode != http.StatusOK || !strings.Contains(body, `"CustomerId":7`) {
+		t.Fatalf("get: %s %s", response.Status, body)
+	}
+	etag := response.Header.Get("ETag")
+	response, _ = call(t, server, "GET", "/invoices/1", "", map[string]string{"If-None-Match": etag})
+	if response.StatusCode != http.StatusNotModified {
+		t.Errorf("get with If-None-Match: %s", response.Status)
+	}
+
+	changed := strings.Replace(testInvoiceJSON, `"CustomerId":7`, `"CustomerId":8`, 1)
+	response, _ = call(t, server, "PUT", "/invoices/1", changed, map[string]string{"If-Match": etag})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("update with a good If-Match: %s", response.Status)
+	}
+	response, _ = call(t, server, "PUT", "/invoices/1", testInvoiceJSON, map[string]string{"If-Match": etag})
+	if response.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("update with a stale If-Match: %s", response.Status)
+	}
+
+	response, body = call(t, server, "POST", "/invoices/1/payments", `{"Date":"2020-01-10","Amount":20}`, nil)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("pay: %s %s", response.Status, body)
+	}
+	var paid Invoice
+	if err := json.Unmarshal([]byte(body), &paid); err != nil {
+		t.Fatal(err)
+	}
+	if !paid.Paid || len(paid.Payments) != 1 || paid.CustomerId != 8 {
+		t.Errorf("pay left %+v", paid)
+	}
+
+	// An update without If-Match keeps the payments.
+	response, body = call(t, server, "PUT", "/invoices/1", testInvoiceJSON, nil)
+	if response.StatusCode != http.StatusOK || !strings.Contains(body, `"Amount":20`) {
+		t.Errorf("update dropped the payments: %s %s", response.Status, body)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	server, stop := newTestAPI(t)
+	defer stop()
+	call(t, server, "POST", "/invoices", testInvoiceJSON, nil)
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"GET", "/invoices/2", "", http.StatusNotFound},
+		{"GET", "/invoices/x", "", http.StatusNotFound},
+		{"GET", "/customers", "", http.StatusNotFound},
+		{"PUT", "/invoices/2", testInvoiceJSON, http.StatusNotFound},
+		{"POST", "/invoices/2/payments", `{"Date":"2020-01-10","Amount":5}`, http.StatusNotFound},
+		{"POST", "/invoices", "{", http.StatusBadRequest},
+		{"POST", "/invoices", `{"Id":2,"Raised":"2020-01-01","Due":"2020-01-31","Items":[null]}`, http.StatusBadRequest},
+		{"POST", "/invoices", `{"Id":2,"Raised":"2020-01-01","Due":"2020-01-31","Payments":[null]}`, http.StatusBadRequest},
+		{"PUT", "/invoices/1", strings.Replace(testInvoiceJSON, `"Id":1`, `"Id":2`, 1), http.StatusBadRequest},
+		{"POST", "/invoices/1/payments", `{"Date":"2020-01-10","Amount":0}`, http.StatusBadRequest},
+		{"GET", "/invoices?page=0", "", http.StatusBadRequest},
+		{"DELETE", "/invoices/1", "", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		response, body := call(t, server, test.method, test.path, test.body, nil)
+		if response.StatusCode != test.status {
+			t.Errorf("%s %s: got %s, want %d: %s", test.method, test.path, response.Status, test.status, body)
+		}
+	}
+}
+
+func TestAPICreateIgnoresPayments(t *testing.T) {
+	server, stop := newTestAPI(t)
+	defer stop()
+	body := strings.Replace(testInvoiceJSON, `"CustomerId":7`,
+		`"CustomerId":7,"Paid":true,"Payments":[{"Date":"2020-01-02","Amount":20}]`, 1)
+	response, body := call(t, server, "POST", "/invoices", body, nil)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("create: %s %s", response.Status, body)
+	}
+	var created Invoice
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Paid || len(created.Payments) > 0 {
+		t.Errorf("create kept the payments of the client: %+v", created)
+	}
+}
+
+func TestAPIIfMatchRace(t *testing.T) {
+	server, stop := newTestAPI(t)
+	defer stop()
+	call(t, server, "POST", "/invoices", testInvoiceJSON, nil)
+	response, _ := call(t, server, "GET", "/invoices/1", "", nil)
+	etag := response.Header.Get("ETag")
+
+	const writers = 8
+	statuses := make(chan int, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(note string) {
+			defer wg.Done()
+			body := strings.Replace(testInvoiceJSON, `"CustomerId":7`, `"CustomerId":7,"Note":"`+note+`"`, 1)
+			response, _ := call(t, server, "PUT", "/invoices/1", body, map[string]string{"If-Match": etag})
+			statuses <- response.StatusCode
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+	close(statuses)
+	succeeded := 0
+	for status := range statuses {
+		if status == http.StatusOK {
+			succeeded++
+		} else if status != http.StatusPreconditionFailed {
+			t.Errorf("got %d", status)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d updates with the same If-Match succeeded, want 1", succeeded)
+	}
+}
+
+func TestAPIETagOfEveryFormat(t *testing.T) {
+	server, stop := newTestAPI(t)
+	defer stop()
+	call(t, server, "POST", "/invoices", testInvoiceJSON, nil)
+	for i, format := range downloadFormats {
+		response, _ := call(t, server, "GET", "/invoices/1", "", map[string]string{"Accept": format.mediaType})
+		if response.StatusCode != http.StatusOK || response.Header.Get("Vary") != "Accept" {
+			t.Fatalf("%s: %s, Vary %q", format.mediaType, response.Status, response.Header.Get("Vary"))
+		}
+		note := strings.Replace(testInvoiceJSON, `"CustomerId":7`, `"CustomerId":7,"Note":"`+string(rune('a'+i))+`"`, 1)
+		response, _ = call(t, server, "PUT", "/invoices/1", note,
+			map[string]string{"If-Match": response.Header.Get("ETag")})
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s: update with its ETag: %s", format.mediaType, response.Status)
+		}
+	}
+}
+
+func TestAPIUpdateDerivesPaid(t *testing.T) {
+	server, stop := newTestAPI(t)
+	defer stop()
+	call(t, server, "POST", "/invoices", testInvoiceJSON, nil)
+	call(t, server, "POST", "/invoices/1/payments", `{"Date":"2020-01-10","Amount":5}`, nil)
+	tests := []struct {
+		name, body string
+		paid       bool
+	}{
+		{"claims paid", strings.Replace(testInvoiceJSON, `"CustomerId":7`, `"CustomerId":7,"Paid":true`, 1), false},
+		{"price lowered to the payment", strings.Replace(testInvoiceJSON, `"Price":10,"Quantity":2`, `"Price":5,"Quantity":1`, 1), true},
+		{"price raised again", testInvoiceJSON, false},
+	}
+	for _, test := range tests {
+		response, body := call(t, server, "PUT", "/invoices/1", test.body, nil)
+		var invoice Invoice
+		if err := json.Unmarshal([]byte(body), &invoice); err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("%s: %s %s", test.name, response.Status, body)
+		}
+		if invoice.Paid != test.paid {
+			t.Errorf("%s: paid %v, want %v", test.name, invoice.Paid, test.paid)
+		}
+	}
+}
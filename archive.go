@@ -0,0 +1,428 @@
+/**
+ * Archive bundles of invoice files.
+ *
+ * An archive is a .tar.gz holding MANIFEST.json first and then the invoice
+ * files byte for byte, so compressed, encrypted and signed files stay as
+ * they were. The manifest records each file's invoice count, total and
+ * SHA-256, which verify and extract check again. Members are read in
+ * place with paths like "2019.tar.gz#january.json".
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const manifestName = "MANIFEST.json"
+
+// ErrNotInArchive is returned for a member the archive does not hold.
+var ErrNotInArchive = errors.New("no such file in the archive")
+
+// archiveEntry describes one invoice file of an archive.
+type archiveEntry struct {
+	Name     string
+	Size     int64
+	Invoices int
+	Total    float64
+	SHA256   string
+}
+
+// archiveManifest is stored as MANIFEST.json in every archive.
+type archiveManifest struct {
+	Created string // in dateFormat
+	Files   []archiveEntry
+}
+
+func (m archiveManifest) entry(name string) (archiveEntry, bool) {
+	for _, entry := range m.Files {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return archiveEntry{}, false
+}
+
+func isArchive(filename string) bool {
+	return strings.HasSuffix(filename, ".tar.gz") || strings.HasSuffix(filename, ".tgz")
+}
+
+// splitArchivePath splits "archive.tar.gz#member.json" into its parts.
+func splitArchivePath(filename string) (archive, member string, ok bool) {
+	i := strings.LastIndex(filename, "#")
+	if i < 0 || !isArchive(filename[:i]) {
+		return "", "", false
+	}
+	return filename[:i], filename[i+1:], true
+}
+
+// openArchive returns a tar reader over the archive and a closer for it.
+func openArchive(filename string) (*tar.Reader, func(), error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	decompressor, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return tar.NewReader(decompressor), func() { decompressor.Close(); file.Close() }, nil
+}
+
+// openArchiveMember returns a reader positioned at member.
+func openArchiveMember(filename, member string) (io.Reader, func(), error) {
+	archive, closer, err := openArchive(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil, closer, fmt.Errorf("%s: %s: %w", filename, member, ErrNotInArchive)
+		}
+		if err != nil {
+			return nil, closer, fmt.Errorf("%s: %v", filename, err)
+		}
+		if header.Name == member && header.Typeflag == tar.TypeReg {
+			return archive, closer, nil
+		}
+	}
+}
+
+// describeInvoiceFile builds the manifest entry of an invoice file.
+func describeInvoiceFile(name string, data []byte, passphrase []byte) (archiveEntry, error) {
+	sum := sha256.Sum256(data)
+	entry := archiveEntry{Name: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	var reader io.Reader = bytes.NewReader(data)
+	var err error
+	if strings.HasSuffix(name, encryptedSuffix) {
+		if reader, err = newDecryptReader(reader, passphrase); err != nil {
+			return entry, err
+		}
+	}
+	if strings.HasSuffix(strings.TrimSuffix(name, encryptedSuffix), ".gz") {
+		decompressor, err := gzip.NewReader(reader)
+		if err != nil {
+			return entry, err
+		}
+		defer decompressor.Close()
+		reader = decompressor
+	}
+	invoices, err := readInvoices(reader, suffixOf(name))
+	if err != nil {
+		return entry, err
+	}
+	entry.Invoices = len(invoices)
+	for _, invoice := range invoices {
+		entry.Total += invoice.Total()
+	}
+	entry.Total = roundCents(entry.Total)
+	return entry, nil
+}
+
+func writeTarFile(writer *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}
+	if err := writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := writer.Write(data)
+	return err
+}
+
+// createArchive packs the invoice files into archive. Files are stored
+// under their base names, which must be unique. Signature sidecars next to
+// the files are packed too.
+func createArchive(archive string, filenames []string, passphrase []byte) (manifest archiveManifest, err error) {
+	manifest.Created = today().Format(dateFormat)
+	contents := make(map[string][]byte)
+	var names []string
+	add := func(name string, data []byte) error {
+		if _, ok := contents[name]; ok || name == manifestName {
+			return fmt.Errorf("%s is in the archive twice", name)
+		}
+		contents[name] = data
+		names = append(names, name)
+		return nil
+	}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return manifest, err
+		}
+		name := filepath.Base(filename)
+		entry, err := describeInvoiceFile(name, data, passphrase)
+		if err != nil {
+			return manifest, fmt.Errorf("%s: %w", filename, err)
+		}
+		if err = add(name, data); err != nil {
+			return manifest, err
+		}
+		manifest.Files = append(manifest.Files, entry)
+		if signature, err := ioutil.ReadFile(signatureFileOf(filename)); err == nil {
+			if err = add(signatureFileOf(name), signature); err != nil {
+				return manifest, err
+			}
+		}
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	file, err := os.Create(archive)
+	if err != nil {
+		return manifest, err
+	}
+	compressor := gzip.NewWriter(file)
+	writer := tar.NewWriter(compressor)
+	defer func() {
+		for _, close := range []func() error{writer.Close, compressor.Close, file.Close} {
+			if cerr := close(); err == nil {
+				err = cerr
+			}
+		}
+		if err != nil {
+			os.Remove(archive)
+		}
+	}()
+	now := time.Now()
+	if err = writeTarFile(writer, manifestName, manifestData, now); err != nil {
+		return manifest, err
+	}
+	for _, name := range names {
+		if err = writeTarFile(writer, name, contents[name], now); err != nil {
+			return manifest, err
+		}
+	}
+	return manifest, nil
+}
+
+// walkArchive calls visit for every regular file of the archive, the
+// manifest first.
+func walkArchive(filename string, visit func(manifest *archiveManifest, header *tar.Header, data []byte) error) error {
+	archive, closer, err := openArchive(filename)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	var manifest *archiveManifest
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(archive)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", filename, header.Name, err)
+		}
+		if manifest == nil {
+			if header.Name != manifestName {
+				return fmt.Errorf("%s: the archive does not start with %s", filename, manifestName)
+			}
+			manifest = &archiveManifest{}
+			if err = json.Unmarshal(data, manifest); err != nil {
+				return fmt.Errorf("%s: %s: %v", filename, manifestName, err)
+			}
+		}
+		if err = visit(manifest, header, data); err != nil {
+			return err
+		}
+	}
+	if manifest == nil {
+		return fmt.Errorf("%s: empty archive", filename)
+	}
+	return nil
+}
+
+func readManifest(filename string) (archiveManifest, error) {
+	var manifest archiveManifest
+	errStop := errors.New("stop")
+	err := walkArchive(filename, func(m *archiveManifest, _ *tar.Header, _ []byte) error {
+		manifest = *m
+		return errStop
+	})
+	if err == errStop {
+		err = nil
+	}
+	return manifest, err
+}
+
+// verifyArchive checks every file listed in the manifest against its
+// hash, invoice count and total, and that the archive holds no other
+// files than those, their signatures and the manifest, and none twice.
+// It returns the problems found.
+func verifyArchive(filename string, passphrase []byte) ([]string, error) {
+	var problems []string
+	seen := make(map[string]bool)
+	var manifest archiveManifest
+	err := walkArchive(filename, func(m *archiveManifest, header *tar.Header, data []byte) error {
+		manifest = *m
+		if seen[header.Name] {
+			problems = append(problems, fmt.Sprintf("%s: in the archive more than once", header.Name))
+			return nil
+		}
+		seen[header.Name] = true
+		expected, ok := m.entry(header.Name)
+		if !ok {
+			// The manifest comes first, and signatures go with their files.
+			_, signed := m.entry(strings.TrimSuffix(header.Name, signatureSuffix))
+			signed = signed && strings.HasSuffix(header.Name, signatureSuffix)
+			if header.Name != manifestName && !signed {
+				problems = append(problems, fmt.Sprintf("%s: not in the manifest", header.Name))
+			}
+			return nil
+		}
+		actual, err := describeInvoiceFile(header.Name, data, passphrase)
+		switch {
+		case actual.SHA256 != expected.SHA256:
+			problems = append(problems, fmt.Sprintf("%s: SHA-256 does not match", header.Name))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", header.Name, err))
+		case actual.Invoices != expected.Invoices || actual.Total != expected.Total:
+			problems = append(problems, fmt.Sprintf("%s: %d invoices totalling %.2f, the manifest says %d and %.2f",
+				header.Name, actual.Invoices, actual.Total, expected.Invoices, expected.Total))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range manifest.Files {
+		if !seen[entry.Name] {
+			problems = append(problems, fmt.Sprintf("%s: missing from the archive", entry.Name))
+		}
+	}
+	return problems, nil
+}
+
+// extractArchive writes the files of the archive into dir. Existing files
+// are not overwritten. It does not verify the archive, see checkArchive.
+func extractArchive(filename, dir string) (int, error) {
+	extracted := 0
+	err := walkArchive(filename, func(_ *archiveManifest, header *tar.Header, data []byte) error {
+		name := filepath.Clean(header.Name)
+		if filepath.IsAbs(name) || name != filepath.Base(name) {
+			return fmt.Errorf("%s: refusing to extract %q outside %s", filename, header.Name, dir)
+		}
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err = file.Write(data); err != nil {
+			file.Close()
+			return err
+		}
+		extracted++
+		return file.Close()
+	})
+	return extracted, err
+}
+
+func writeManifest(writer io.Writer, archive string, manifest archiveManifest) error {
+	fmt.Fprintf(writer, "%s, created %s\n\n", archive, manifest.Created)
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "File\tInvoices\tTotal\tSHA-256\n")
+	invoices, total := 0, 0.0
+	for _, entry := range manifest.Files {
+		fmt.Fprintf(table, "%s\t%d\t%.2f\t%s\n", entry.Name, entry.Invoices, entry.Total, entry.SHA256)
+		invoices += entry.Invoices
+		total += entry.Total
+	}
+	fmt.Fprintf(table, "%d files\t%d\t%.2f\t\n", len(manifest.Files), invoices, total)
+	return table.Flush()
+}
+
+// checkArchive verifies the archive and logs its problems.
+func checkArchive(filename string, passphrase []byte) error {
+	problems, err := verifyArchive(filename, passphrase)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		log.Errorln(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problems", filename, len(problems))
+	}
+	return nil
+}
+
+// archiveCommand runs "create", "list", "verify" and "extract". Extract
+// verifies the archive first, so encrypted members need the passphrase.
+func archiveCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	flags := flag.NewFlagSet("archive "+args[0], flag.ContinueOnError)
+	passphrase := flags.String("passphrase", "", "passphrase for .enc files")
+	dir := flags.String("dir", ".", "directory to extract into")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	switch {
+	case args[0] == "create" && flags.NArg() >= 2:
+		filenames, err := expandGlobs(flags.Args()[1:])
+		if err != nil {
+			return err
+		}
+		manifest, err := createArchive(flags.Arg(0), filenames, []byte(*passphrase))
+		if err != nil {
+			return err
+		}
+		log.Infof("Archived %d files in %s", len(manifest.Files), flags.Arg(0))
+		return nil
+	case args[0] == "list" && flags.NArg() == 1:
+		manifest, err := readManifest(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		return writeManifest(os.Stdout, flags.Arg(0), manifest)
+	case args[0] == "verify" && flags.NArg() == 1:
+		if err := checkArchive(flags.Arg(0), []byte(*passphrase)); err != nil {
+			return err
+		}
+		log.Infof("%s: all files match the manifest", flags.Arg(0))
+		return nil
+	case args[0] == "extract" && flags.NArg() == 1:
+		if err := checkArchive(flags.Arg(0), []byte(*passphrase)); err != nil {
+			return fmt.Errorf("%v, nothing extracted", err)
+		}
+		n, err := extractArchive(flags.Arg(0), *dir)
+		if err != nil {
+			return err
+		}
+		log.Infof("Extracted %d files to %s", n, *dir)
+		return nil
+	}
+	return errUsage
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// member is a file of a hand-made archive.
+type member struct {
+	name string
+	data []byte
+}
+
+func writeTestArchive(t *testing.T, filename string, members ...member) {
+	t.Helper()
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressor := gzip.NewWriter(file)
+	writer := tar.NewWriter(compressor)
+	for _, m := range members {
+		if err = writeTarFile(writer, m.name, m.data, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, close := range []func() error{writer.Close, compressor.Close, file.Close} {
+		if err = close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "invoices.json")
+	if err = writeInvoiceFile(filename, []*Invoice{testInvoice(1, "")}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := describeInvoiceFile("invoices.json", data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(archiveManifest{Created: "2020-01-01", Files: []archiveEntry{entry}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		members []member
+		want    []string
+	}{
+		{"valid", []member{{manifestName, manifest}, {"invoices.json", data}}, nil},
+		{"signed", []member{{manifestName, manifest}, {"invoices.json", data},
+			{signatureFileOf("invoices.json"), []byte("signature")}}, nil},
+		{"missing", []member{{manifestName, manifest}}, []string{"invoices.json: missing"}},
+		{"changed", []member{{manifestName, manifest}, {"invoices.json", append(data, ' ')}},
+			[]string{"invoices.json: SHA-256"}},
+		{"extra", []member{{manifestName, manifest}, {"invoices.json", data}, {"extra.json", data}},
+			[]string{"extra.json: not in the manifest"}},
+		{"stray signature", []member{{manifestName, manifest}, {"invoices.json", data},
+			{signatureFileOf("other.json"), []byte("signature")}}, []string{"other.json.sig: not in the manifest"}},
+		{"twice", []member{{manifestName, manifest}, {"invoices.json", data}, {"invoices.json", data}},
+			[]string{"invoices.json: in the archive more than once"}},
+		{"second manifest", []member{{manifestName, manifest}, {"invoices.json", data}, {manifestName, manifest}},
+			[]string{manifestName + ": in the archive more than once"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := filepath.Join(dir, "test.tar.gz")
+			writeTestArchive(t, archive, test.members...)
+			problems, err := verifyArchive(archive, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != len(test.want) {
+				t.Fatalf("got problems %q, want %q", problems, test.want)
+			}
+			for i, problem := range problems {
+				if !strings.HasPrefix(problem, test.want[i]) {
+					t.Errorf("got %q, want %q", problem, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractVerifiesFirst(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "invoices.json")
+	if err = writeInvoiceFile(filename, []*Invoice{testInvoice(1, "")}); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "test.tar.gz")
+	if err = archiveCommand([]string{"create", archive, filename}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := readManifest(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		members []member
+		err     string
+	}{
+		{"valid", []member{{manifestName, manifestData}, {"invoices.json", data}}, ""},
+		{"changed", []member{{manifestName, manifestData}, {"invoices.json", append(data, ' ')}}, "nothing extracted"},
+	}
+	for _, test := range tests {
+		into, err := ioutil.TempDir(dir, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestArchive(t, archive, test.members...)
+		err = archiveCommand([]string{"extract", "-dir", into, archive})
+		extracted, _ := ioutil.ReadDir(into)
+		switch {
+		case test.err == "" && (err != nil || len(extracted) != 2):
+			t.Errorf("%s: got %v with %d files extracted, want both", test.name, err, len(extracted))
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err) || len(extracted) != 0):
+			t.Errorf("%s: got %v with %d files extracted, want an error with %q and none",
+				test.name, err, len(extracted), test.err)
+		}
+	}
+}
@@ -0,0 +1,529 @@
+/**
+ * Audit trail for invoice changes.
+ *
+ * Every create, update, payment and void is appended to a journal file as
+ * one JSON event per line. The journal is never rewritten, and replaying it
+ * from the start rebuilds the current invoices. A write which fails is cut
+ * off again, and a last line left incomplete by a crash is dropped when the
+ * journal is opened.
+ *
+ * The API changes invoices through an AuditLog. The commands which rewrite
+ * invoice files, reconcile, dunning -record and the editor, journal how the
+ * file changed before they save it, see saveJournaled. An invoice the
+ * journal does not hold yet is first recorded as created, as it was before
+ * the change.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvoiceExists is returned when creating an invoice whose Id is
+	// taken.
+	ErrInvoiceExists = errors.New("invoice already exists")
+	// ErrInvoiceNotFound is returned for changes to an unknown invoice.
+	ErrInvoiceNotFound = errors.New("invoice does not exist")
+	// ErrInvalidPayment is returned for payments which are not positive.
+	ErrInvalidPayment = errors.New("payment amount must be positive")
+	// ErrInvoiceChanged is returned by a conditional update when the
+	// invoice is no longer the one the caller saw.
+	ErrInvoiceChanged = errors.New("invoice was changed by someone else")
+)
+
+// AuditAction is the kind of change an event records.
+type AuditAction string
+
+const (
+	ActionCreate  AuditAction = "create"
+	ActionUpdate  AuditAction = "update"
+	ActionPayment AuditAction = "payment"
+	ActionVoid    AuditAction = "void"
+)
+
+// AuditEvent is one line of the journal. After holds the invoice as it was
+// left by the event, and is nil for a void.
+type AuditEvent struct {
+	Seq       int
+	Time      time.Time
+	Actor     string
+	Action    AuditAction
+	InvoiceId int
+	Reason    string   `json:",omitempty"`
+	Changes   []Change `json:",omitempty"`
+	After     *Invoice `json:",omitempty"`
+}
+
+// AuditLog keeps the current invoices together with the journal that
+// produced them. It is safe for concurrent use.
+type AuditLog struct {
+	mutex    sync.Mutex
+	file     *os.File
+	encoder  *json.Encoder
+	invoices map[int]*Invoice
+	events   []*AuditEvent
+}
+
+// openAuditLog replays the journal in filename, creating it if needed, and
+// opens it for appending.
+func openAuditLog(filename string) (*AuditLog, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	invoices, events, complete, err := replayJournal(file)
+	if err == nil {
+		err = dropTornLine(file, complete)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return &AuditLog{
+		file:     file,
+		encoder:  json.NewEncoder(file),
+		invoices: invoices,
+		events:   events,
+	}, nil
+}
+
+// replayJournal applies the events read from reader in order, and returns
+// the length of the complete lines. A last line without its newline is
+// left out, since its write did not finish.
+func replayJournal(reader io.Reader) (map[int]*Invoice, []*AuditEvent, int64, error) {
+	invoices := make(map[int]*Invoice)
+	var events []*AuditEvent
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+	var complete int64
+	for line := 1; ; line++ {
+		data, err := buffered.ReadBytes('\n')
+		if err == io.EOF {
+			if len(data) > 0 {
+				log.Warnf("line %d: incomplete event dropped", line)
+			}
+			return invoices, events, complete, nil
+		} else if err != nil {
+			return nil, nil, 0, err
+		}
+		var event AuditEvent
+		if err = json.Unmarshal(data, &event); err != nil {
+			return nil, nil, 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		if event.Seq != len(events)+1 {
+			return nil, nil, 0, fmt.Errorf("line %d: expected event %d, found %d",
+				line, len(events)+1, event.Seq)
+		}
+		if err = applyEvent(invoices, &event); err != nil {
+			return nil, nil, 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		events = append(events, &event)
+		complete += int64(len(data))
+	}
+}
+
+// dropTornLine cuts the journal after its complete lines.
+func dropTornLine(file *os.File, complete int64) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == complete {
+		return err
+	}
+	return file.Truncate(complete)
+}
+
+func applyEvent(invoices map[int]*Invoice, event *AuditEvent) error {
+	_, exists := invoices[event.InvoiceId]
+	switch event.Action {
+	case ActionCreate:
+		if exists {
+			return fmt.Errorf("invoice %d already exists", event.InvoiceId)
+		}
+	case ActionUpdate, ActionPayment, ActionVoid:
+		if !exists {
+			return fmt.Errorf("invoice %d does not exist", event.InvoiceId)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", event.Action)
+	}
+	if event.Action == ActionVoid {
+		delete(invoices, event.InvoiceId)
+		return nil
+	}
+	if event.After == nil || event.After.Id != event.InvoiceId {
+		return fmt.Errorf("event %d has no state for invoice %d", event.Seq, event.InvoiceId)
+	}
+	invoices[event.InvoiceId] = event.After.clone()
+	return nil
+}
+
+// Close closes the journal file.
+func (l *AuditLog) Close() error {
+	return l.file.Close()
+}
+
+// Create records a new invoice.
+func (l *AuditLog) Create(actor string, invoice *Invoice) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, ok := l.invoices[invoice.Id]; ok {
+		return fmt.Errorf("invoice %d: %w", invoice.Id, ErrInvoiceExists)
+	}
+	return l.record(actor, ActionCreate, invoice.Id, "", nil, invoice.clone())
+}
+
+// Update replaces the invoice with the same Id. Unless etag is empty, the
+// current invoice must still have that ETag, see etagOf.
+func (l *AuditLog) Update(actor string, invoice *Invoice, etag string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	before, ok := l.invoices[invoice.Id]
+	if !ok {
+		return fmt.Errorf("invoice %d: %w", invoice.Id, ErrInvoiceNotFound)
+	}
+	if etag != "" {
+		if current, err := etagOf(before); err != nil || current != etag {
+			return fmt.Errorf("invoice %d: %w", invoice.Id, ErrInvoiceChanged)
+		}
+	}
+	return l.record(actor, ActionUpdate, invoice.Id, "", before, invoice.clone())
+}
+
+// Pay records a payment against the invoice.
+func (l *AuditLog) Pay(actor string, id int, payment *PaymentRecord) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	before, ok := l.invoices[id]
+	if !ok {
+		return fmt.Errorf("invoice %d: %w", id, ErrInvoiceNotFound)
+	}
+	if payment.Amount <= 0 {
+		return ErrInvalidPayment
+	}
+	after := before.clone()
+	paymentCopy := *payment
+	after.addPayment(&paymentCopy)
+	return l.record(actor, ActionPayment, id, payment.Reference, before, after)
+}
+
+// Void removes the invoice from the current state. Its history is kept.
+func (l *AuditLog) Void(actor string, id int, reason string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	before, ok := l.invoices[id]
+	if !ok {
+		return fmt.Errorf("invoice %d: %w", id, ErrInvoiceNotFound)
+	}
+	return l.record(actor, ActionVoid, id, reason, before, nil)
+}
+
+// record appends the event to the journal and then applies it. The caller
+// holds the mutex.
+func (l *AuditLog) record(actor string, action AuditAction, id int, reason string,
+	before, after *Invoice) error {
+	event := &AuditEvent{
+		Seq:       len(l.events) + 1,
+		Time:      time.Now().UTC(),
+		Actor:     actor,
+		Action:    action,
+		InvoiceId: id,
+		Reason:    reason,
+		Changes:   diffInvoice(before, after),
+		After:     after,
+	}
+	// A failed write is cut off, so the next event starts on a line of
+	// its own.
+	offset, err := l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err = l.encoder.Encode(event); err == nil {
+		err = l.file.Sync()
+	}
+	if err != nil {
+		if cutErr := l.file.Truncate(offset); cutErr != nil {
+			return fmt.Errorf("%v, and the journal could not be cut back: %v", err, cutErr)
+		}
+		return err
+	}
+	l.events = append(l.events, event)
+	return applyEvent(l.invoices, event)
+}
+
+// addedPayments returns the payments after has on top of those of before,
+// or nil when the invoices differ in anything else.
+func addedPayments(before, after *Invoice) []*PaymentRecord {
+	if len(after.Payments) <= len(before.Payments) {
+		return nil
+	}
+	added := after.Payments[len(before.Payments):]
+	probe := before.clone()
+	for _, payment := range added {
+		paymentCopy := *payment
+		probe.addPayment(&paymentCopy)
+	}
+	if len(diffInvoice(probe, after)) > 0 {
+		return nil
+	}
+	return added
+}
+
+// recordChanges journals how the invoices of a file went from before to
+// after: new invoices as created, removed ones as voided, added payments
+// as payments and any other change as an update. Invoices the journal does
+// not hold yet are recorded as created first, in their state before.
+func (l *AuditLog) recordChanges(actor, reason string, before, after []*Invoice) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	adopt := func(invoice *Invoice) error {
+		if _, ok := l.invoices[invoice.Id]; ok {
+			return nil
+		}
+		return l.record(actor, ActionCreate, invoice.Id, "before "+reason, nil, invoice.clone())
+	}
+	old := invoiceIndex(before)
+	kept := make(map[int]bool, len(after))
+	for _, invoice := range after {
+		kept[invoice.Id] = true
+		previous, ok := old[invoice.Id]
+		current, journaled := l.invoices[invoice.Id]
+		var err error
+		switch {
+		case journaled && len(diffInvoice(current, invoice)) == 0:
+			// Already journaled, e.g. by a save which failed to write the file.
+		case !ok && !journaled:
+			err = l.record(actor, ActionCreate, invoice.Id, reason, nil, invoice.clone())
+		case !ok:
+			err = l.record(actor, ActionUpdate, invoice.Id, reason, current, invoice.clone())
+		case len(diffInvoice(previous, invoice)) == 0:
+		default:
+			if err = adopt(previous); err != nil {
+				return err
+			}
+			current = l.invoices[invoice.Id]
+			payments := addedPayments(previous, invoice)
+			if payments == nil || len(diffInvoice(current, previous)) > 0 {
+				err = l.record(actor, ActionUpdate, invoice.Id, reason, current, invoice.clone())
+				break
+			}
+			for _, payment := range payments {
+				current = l.invoices[invoice.Id]
+				paid := current.clone()
+				paymentCopy := *payment
+				paid.addPayment(&paymentCopy)
+				if err = l.record(actor, ActionPayment, invoice.Id, payment.Reference, current, paid); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, invoice := range before {
+		if kept[invoice.Id] {
+			continue
+		}
+		if err := adopt(invoice); err != nil {
+			return err
+		}
+		if err := l.record(actor, ActionVoid, invoice.Id, reason, l.invoices[invoice.Id], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveJournaled records in the journal how invoices differ from before,
+// the invoices as the file held them, and then writes them to filename.
+func saveJournaled(journal, filename, reason string, before, invoices []*Invoice, options ...writeOption) error {
+	auditLog, err := openAuditLog(journal)
+	if err != nil {
+		return err
+	}
+	defer auditLog.Close()
+	if err = auditLog.recordChanges(currentActor(), reason, before, invoices); err != nil {
+		return fmt.Errorf("%s: %v", journal, err)
+	}
+	return replaceInvoiceFile(filename, invoices, options...)
+}
+
+// Invoice returns a copy of the current invoice with the given Id.
+func (l *AuditLog) Invoice(id int) (*Invoice, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	invoice, ok := l.invoices[id]
+	if !ok {
+		return nil, false
+	}
+	return invoice.clone(), true
+}
+
+// Invoices returns copies of the current invoices ordered by Id.
+func (l *AuditLog) Invoices() []*Invoice {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	invoices := make([]*Invoice, 0, len(l.invoices))
+	for _, invoice := range l.invoices {
+		invoices = append(invoices, invoice.clone())
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Id < invoices[j].Id })
+	return invoices
+}
+
+// History returns the events of one invoice, oldest first.
+func (l *AuditLog) History(id int) []*AuditEvent {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var history []*AuditEvent
+	for _, event := range l.events {
+		if event.InvoiceId == id {
+			history = append(history, event)
+		}
+	}
+	return history
+}
+
+// currentActor names the user recorded in new events.
+func currentActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "unknown"
+}
+
+func printHistory(writer io.Writer, id int, history []*AuditEvent) {
+	if len(history) == 0 {
+		fmt.Fprintf(writer, "No history for invoice %d.\n", id)
+		return
+	}
+	fmt.Fprintf(writer, "History of invoice %d:\n", id)
+	for _, event := range history {
+		fmt.Fprintf(writer, "#%d %s %-7s by %s", event.Seq,
+			event.Time.Local().Format("2006-01-02 15:04:05"), event.Action, event.Actor)
+		if event.Reason != "" {
+			fmt.Fprintf(writer, " (%s)", event.Reason)
+		}
+		fmt.Fprintln(writer)
+		for _, change := range event.Changes {
+			fmt.Fprintf(writer, "    %v\n", change)
+		}
+	}
+}
+
+// AuditHistory asks for a journal and an invoice Id and shows its history.
+func AuditHistory() {
+	filename, err := menu.Ask("Journal file", "invoices.journal", nil)
+	if err != nil {
+		return
+	}
+	idText, err := menu.Ask("Invoice Id", "", validateInt)
+	if err != nil {
+		return
+	}
+	id, _ := strconv.Atoi(idText)
+	if err = showHistory(filename, id); err != nil {
+		log.Errorln(err)
+	}
+}
+
+func validateInt(input string) error {
+	if _, err := strconv.Atoi(input); err != nil {
+		return errors.New("invalid number")
+	}
+	return nil
+}
+
+func showHistory(filename string, id int) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, events, _, err := replayJournal(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	var history []*AuditEvent
+	for _, event := range events {
+		if event.InvoiceId == id {
+			history = append(history, event)
+		}
+	}
+	printHistory(os.Stdout, id, history)
+	return nil
+}
+
+func historyCommand(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	id, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errUsage
+	}
+	return showHistory(args[0], id)
+}
+
+// replayCommand rebuilds the current invoices from a journal and writes
+// them to an invoice file.
+func replayCommand(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	state, events, _, err := replayJournal(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	invoices := make([]*Invoice, 0, len(state))
+	for _, invoice := range state {
+		invoices = append(invoices, invoice)
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].Id < invoices[j].Id })
+	if err = writeInvoiceFile(args[1], invoices); err != nil {
+		return err
+	}
+	log.Infof("Replayed %d events into %d invoices in %s", len(events), len(invoices), args[1])
+	return nil
+}
+
+// journalCommand records every invoice of an invoice file as created, to
+// start a journal from existing data.
+func journalCommand(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	invoices, err := readInvoiceFile(args[1])
+	if err != nil {
+		return err
+	}
+	auditLog, err := openAuditLog(args[0])
+	if err != nil {
+		return err
+	}
+	defer auditLog.Close()
+	for _, invoice := range invoices {
+		if err = auditLog.Create(currentActor(), invoice); err != nil {
+			return err
+		}
+	}
+	log.Infof("Journaled %d invoices to %s", len(invoices), args[0])
+	return nil
+}
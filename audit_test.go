@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tornWriter writes the first bytes it is given and then fails, like a
+// disk filling up.
+type tornWriter struct {
+	file *os.File
+}
+
+func (w tornWriter) Write(data []byte) (int, error) {
+	n, _ := w.file.Write(data[:len(data)/2])
+	return n, errors.New("disk full")
+}
+
+func tempJournal(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "invoices.journal"), func() { os.RemoveAll(dir) }
+}
+
+func TestAuditLogCutsFailedWrite(t *testing.T) {
+	filename, remove := tempJournal(t)
+	defer remove()
+	auditLog, err := openAuditLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = auditLog.Create("test", testInvoice(1, "")); err != nil {
+		t.Fatal(err)
+	}
+	encoder := auditLog.encoder
+	auditLog.encoder = json.NewEncoder(tornWriter{auditLog.file})
+	if err = auditLog.Create("test", testInvoice(2, "")); err == nil {
+		t.Fatal("the failed write was not reported")
+	}
+	auditLog.encoder = encoder
+	if err = auditLog.Create("test", testInvoice(3, "")); err != nil {
+		t.Fatal(err)
+	}
+	auditLog.Close()
+
+	if auditLog, err = openAuditLog(filename); err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer auditLog.Close()
+	if _, ok := auditLog.Invoice(2); ok || len(auditLog.Invoices()) != 2 {
+		t.Errorf("got %d invoices, want 1 and 3", len(auditLog.Invoices()))
+	}
+}
+
+func TestAuditLogDropsTornLastLine(t *testing.T) {
+	filename, remove := tempJournal(t)
+	defer remove()
+	auditLog, err := openAuditLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = auditLog.Create("test", testInvoice(1, "")); err != nil {
+		t.Fatal(err)
+	}
+	auditLog.Close()
+	data, _ := ioutil.ReadFile(filename)
+	complete := len(data)
+	if err = ioutil.WriteFile(filename, append(data, data[:complete/2]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if auditLog, err = openAuditLog(filename); err != nil {
+		t.Fatalf("torn line: %v", err)
+	}
+	if err = auditLog.Create("test", testInvoice(2, "")); err != nil {
+		t.Fatal(err)
+	}
+	auditLog.Close()
+	if auditLog, err = openAuditLog(filename); err != nil {
+		t.Fatalf("after the torn line: %v", err)
+	}
+	defer auditLog.Close()
+	if len(auditLog.Invoices()) != 2 {
+		t.Errorf("got %d invoices, want 2", len(auditLog.Invoices()))
+	}
+}
+
+func TestAuditLogRecordChanges(t *testing.T) {
+	payment := &PaymentRecord{Date: time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC), Amount: 10, Reference: "ref"}
+	paid := testInvoice(1, "")
+	paid.addPayment(payment)
+	tests := []struct {
+		name          string
+		journaled     []*Invoice
+		before, after []*Invoice
+		want          []AuditAction
+	}{
+		{"unchanged", []*Invoice{testInvoice(1, "")}, []*Invoice{testInvoice(1, "")}, []*Invoice{testInvoice(1, "")}, nil},
+		{"created", nil, nil, []*Invoice{testInvoice(1, "")}, []AuditAction{ActionCreate}},
+		{"paid", []*Invoice{testInvoice(1, "")}, []*Invoice{testInvoice(1, "")}, []*Invoice{paid},
+			[]AuditAction{ActionPayment}},
+		{"paid, not journaled yet", nil, []*Invoice{testInvoice(1, "")}, []*Invoice{paid},
+			[]AuditAction{ActionCreate, ActionPayment}},
+		{"paid, journal differs", []*Invoice{testInvoice(1, "old")}, []*Invoice{testInvoice(1, "")},
+			[]*Invoice{paid}, []AuditAction{ActionUpdate}},
+		{"updated", []*Invoice{testInvoice(1, "")}, []*Invoice{testInvoice(1, "")},
+			[]*Invoice{testInvoice(1, "changed")}, []AuditAction{ActionUpdate}},
+		{"already journaled", []*Invoice{testInvoice(1, "changed")}, []*Invoice{testInvoice(1, "")},
+			[]*Invoice{testInvoice(1, "changed")}, nil},
+		{"deleted", []*Invoice{testInvoice(1, "")}, []*Invoice{testInvoice(1, "")}, nil,
+			[]AuditAction{ActionVoid}},
+		{"deleted, not journaled yet", nil, []*Invoice{testInvoice(1, "")}, nil,
+			[]AuditAction{ActionCreate, ActionVoid}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename, remove := tempJournal(t)
+			defer remove()
+			auditLog, err := openAuditLog(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer auditLog.Close()
+			for _, invoice := range test.journaled {
+				if err = auditLog.Create("test", invoice); err != nil {
+					t.Fatal(err)
+				}
+			}
+			journaled := len(auditLog.events)
+			if err = auditLog.recordChanges("test", "test", test.before, test.after); err != nil {
+				t.Fatal(err)
+			}
+			var got []AuditAction
+			for _, event := range auditLog.events[journaled:] {
+				got = append(got, event.Action)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("journaled %v, want %v", got, test.want)
+			}
+			if state := auditLog.Invoices(); len(state) != len(test.after) ||
+				len(test.after) > 0 && len(diffInvoice(state[0], test.after[0])) > 0 {
+				t.Errorf("the journal holds %d invoices, not the ones saved", len(state))
+			}
+		})
+	}
+}
+
+func TestDunningRecordIsJournaled(t *testing.T) {
+	filename, remove := tempJournal(t)
+	defer remove()
+	invoiceFile := filepath.Join(filepath.Dir(filename), "invoices.json")
+	if err := writeInvoiceFile(invoiceFile, []*Invoice{testInvoice(1, "")}); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-record", "-journal", filename, "-as-of", "2020-03-15", "-out", filepath.Dir(filename), invoiceFile}
+	if err := dunningCommand(args); err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err := openAuditLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	history := auditLog.History(1)
+	if len(history) != 2 || history[1].Action != ActionUpdate || history[1].Reason != "dunning" {
+		t.Fatalf("got %d events, want the invoice as created and the dunning update", len(history))
+	}
+	if invoice, _ := auditLog.Invoice(1); len(invoice.Dunning) != 1 {
+		t.Errorf("the journal holds %d dunning records, want 1", len(invoice.Dunning))
+	}
+}
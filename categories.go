@@ -0,0 +1,211 @@
+/**
+ * Browsing and reorganising the category tree of listings, see
+ * listing.Categories.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/icodebb/go-play-ground/listing"
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+// parseCategoryPath parses a breadcrumb such as "Root > Cameras & Photo".
+func parseCategoryPath(text string) ([]string, error) {
+	var path []string
+	for _, name := range strings.Split(text, strings.TrimSpace(listing.BreadcrumbSeparator)) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil, fmt.Errorf("invalid category path %q", text)
+		}
+		path = append(path, name)
+	}
+	return path, nil
+}
+
+func printCategoryTree(top *listing.Category, depth int) {
+	top.Walk(func(category *listing.Category, level int) {
+		if level == 0 || (depth > 0 && level > depth) {
+			return
+		}
+		fmt.Printf("%s%s (%d)\n", strings.Repeat("  ", level-1), category.Name, category.Count)
+	})
+	fmt.Printf("%d listings, %d without a category\n", top.Count, top.Own)
+}
+
+func printListingTitles(listings []*listing.Listing) {
+	for _, l := range listings {
+		fmt.Printf("  %s  [%s]\n", l.Title, listing.Breadcrumb(l.CategoryId))
+	}
+}
+
+// categoryBrowser walks the category tree of a listing file in the menu.
+type categoryBrowser struct {
+	filename string
+	listings []*listing.Listing
+	repairs  []listing.Repair // not saved yet
+	top      *listing.Category
+}
+
+// reorganise renames or moves the category and writes the file, and
+// returns the category's new path.
+func (b *categoryBrowser) reorganise(category *listing.Category, rename bool) ([]string, error) {
+	var to []string
+	if rename {
+		name, err := menu.Ask("New name", category.Name, validateRequired)
+		if err != nil {
+			return nil, err
+		}
+		to = append(append(to, category.Path[:len(category.Path)-1]...), strings.TrimSpace(name))
+	} else {
+		text, err := menu.Ask("New path", category.Breadcrumb(), func(text string) error {
+			_, err := parseCategoryPath(text)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		to, _ = parseCategoryPath(text)
+	}
+	moved, err := listing.Move(b.listings, category.Path, to)
+	if err != nil {
+		return nil, err
+	}
+	if err = updateListingFile(b.filename, b.listings, b.repairs); err != nil {
+		return nil, err
+	}
+	b.repairs = nil
+	log.Infof("%d listings moved to %s", moved, listing.Breadcrumb(to))
+	b.top = listing.Categories(b.listings)
+	return to, nil
+}
+
+// browse shows the category of path until the user goes up, and returns
+// false when the user is done browsing.
+func (b *categoryBrowser) browse(path []string) ([]string, bool) {
+	category := b.top.Find(path)
+	if category == nil {
+		return nil, true // moved away, start again at the top
+	}
+	label := category.Breadcrumb()
+	if label == "" {
+		label = "Categories"
+	}
+	var items []string
+	for _, child := range category.Children {
+		items = append(items, fmt.Sprintf("%s (%d)", child.Name, child.Count))
+	}
+	actions := []string{fmt.Sprintf("Show the %d listings", category.Count)}
+	if len(path) > 0 {
+		actions = append(actions, "Rename", "Move", "Up")
+	}
+	actions = append(actions, "Done")
+	i, err := menu.Choose(label, append(items, actions...))
+	if err != nil {
+		return nil, false
+	}
+	if i < len(category.Children) {
+		return category.Children[i].Path, true
+	}
+	switch actions[i-len(items)] {
+	case "Rename", "Move":
+		to, err := b.reorganise(category, actions[i-len(items)] == "Rename")
+		if err != nil {
+			log.Errorln(err)
+			return path, true
+		}
+		return to, true
+	case "Up":
+		return path[:len(path)-1], true
+	case "Done":
+		return nil, false
+	}
+	printListingTitles(listing.Subtree(b.listings, path))
+	return path, true
+}
+
+// BrowseCategories browses the category tree of a listing file in nested
+// menus.
+func BrowseCategories() {
+	filename, err := menu.Ask("Listing file", "listings.json", validateRequired)
+	if err != nil {
+		return
+	}
+	listings, repairs, err := readListingFile(filename)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	b := &categoryBrowser{filename, listings, repairs, listing.Categories(listings)}
+	var path []string
+	for more := true; more; {
+		path, more = b.browse(path)
+	}
+}
+
+// categoriesCommand runs "tree", "show", "rename" and "move". The last two
+// rewrite the listing file.
+func categoriesCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	flags := flag.NewFlagSet("categories "+args[0], flag.ContinueOnError)
+	depth := flags.Int("depth", 0, "show N levels of the tree, 0 for all")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	switch {
+	case args[0] == "tree" && flags.NArg() > 0:
+		listings, err := readListingFiles(flags.Args())
+		if err != nil {
+			return err
+		}
+		printCategoryTree(listing.Categories(listings), *depth)
+		return nil
+	case args[0] == "show" && flags.NArg() > 1:
+		path, err := parseCategoryPath(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		listings, err := readListingFiles(flags.Args()[1:])
+		if err != nil {
+			return err
+		}
+		printListingTitles(listing.Subtree(listings, path))
+		return nil
+	case (args[0] == "rename" || args[0] == "move") && flags.NArg() == 3:
+		filename := flags.Arg(0)
+		from, err := parseCategoryPath(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		listings, repairs, err := readListingFile(filename)
+		if err != nil {
+			return err
+		}
+		var moved int
+		if args[0] == "rename" {
+			moved, err = listing.Rename(listings, from, strings.TrimSpace(flags.Arg(2)))
+		} else {
+			var to []string
+			if to, err = parseCategoryPath(flags.Arg(2)); err == nil {
+				moved, err = listing.Move(listings, from, to)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if moved == 0 {
+			return errors.New("no listing is in " + listing.Breadcrumb(from))
+		}
+		log.Infof("%s: %d listings rewritten", filename, moved)
+		return updateListingFile(filename, listings, repairs)
+	}
+	return errUsage
+}
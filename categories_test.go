@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCategoryPath(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Root", "Root"},
+		{"Root > Cameras & Photo", "Root|Cameras & Photo"},
+		{" Root>Cameras >  Digital ", "Root|Cameras|Digital"},
+		{"", ""},
+		{"Root > ", ""},
+		{"> Root", ""},
+		{"Root >> Cameras", ""},
+	}
+	for _, test := range tests {
+		path, err := parseCategoryPath(test.text)
+		switch {
+		case test.want == "" && err == nil:
+			t.Errorf("%q: got %q, want an error", test.text, path)
+		case test.want != "" && (err != nil || strings.Join(path, "|") != test.want):
+			t.Errorf("%q: got %q, %v, want %s", test.text, path, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/**
+ * Commands run from the command line instead of the menu, e.g.
+ *
+ *   go-play-ground verify -hmac-key secret.key invoices.json.gz
+ *
+ * Without arguments the program shows the interactive menu.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// errUsage tells runCommand to print the usage of the command.
+var errUsage = errors.New("invalid arguments")
+
+type command struct {
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"archive":    {"archive create|list|verify|extract [-passphrase P] [-dir DIR] ARCHIVE.tar.gz [INVOICE...]", archiveCommand},
+	"categories": {"categories tree|show|rename|move [-depth N] [LISTINGS.json] [PATH] [NAME|PATH] [LISTINGS.json...]", categoriesCommand},
+	"convert":    {"convert [-rates FILE] [-base CUR] [-date DATE] AMOUNT FROM TO", convertCommand},
+	"diff":       {"diff OLD NEW", diffCommand},
+	"dunning":    {"dunning [-config FILE] [-as-of DATE] [-out DIR] [-record] [-journal FILE] [-passphrase P] INVOICE", dunningCommand},
+	"fixtures":   {"fixtures [-n N] [-seed S] [-paid RATIO] [-from DATE] [-to DATE] OUT", fixturesCommand},
+	"forecast":   {"forecast [-weeks N] [-as-of DATE] [-format table|bars] [-currency CUR -rates FILE] INVOICE...", forecastCommand},
+	"history":    {"history JOURNAL INVOICE-ID", historyCommand},
+	"inventory":  {"inventory load|update|show|reserve|commit|release|expire [-hold D] STORE [LISTINGS.json... | SKU N | ID]  or  inventory stress [-buyers N] [-stock N] [STORE]", inventoryCommand},
+	"journal":    {"journal JOURNAL INVOICES  (records the invoices as created)", journalCommand},
+	"keygen":     {"keygen NAME  (writes NAME.key and NAME.pub)", keygenCommand},
+	"lifecycle":  {"lifecycle advance|set|history [-now TIME] [-reason R] LISTINGS.json [N] [STATUS]", lifecycleCommand},
+	"listings":   {"listings [-check] LISTINGS.json...", listingsCommand},
+	"load":       {"load [-workers N] [-fail-fast] [-timeout D] INVOICE...", loadCommand},
+	"merge":      {"merge BASE OURS THEIRS OUT  (conflicts go to OUT.conflicts)", mergeCommand},
+	"quote":      {"quote [-qty N] [-date DATE] [-pick cheapest|fastest] LISTINGS.json N", quoteCommand},
+	"reconcile":  {"reconcile [-window DAYS] [-i] [-n] [-journal FILE] [-passphrase P] STATEMENT.csv INVOICE", reconcileCommand},
+	"near":       {"near [-km N] [-limit N] LAT,LNG LISTINGS.json...", nearCommand},
+	"repair":     {"repair [-n] [-undo] LISTINGS.json  (mojibake such as \"Â£\" for \"£\")", repairCommand},
+	"replay":     {"replay JOURNAL INVOICES  (writes the current invoices)", replayCommand},
+	"report":     {"report [-by month|quarter|customer|sku|days-to-pay] [-format table|csv|bars] [-top N] [-currency CUR -rates FILE] INVOICE...", reportCommand},
+	"search":     {"search [-limit N] [-color] QUERY INVOICE...", searchCommand},
+	"serve":      {"serve [-addr HOST:PORT] [-journal JOURNAL]  (invoice REST API)", serveCommand},
+	"shop":       {"shop [-q WORDS] [-category PATH] [-brand B] [-type T] [-currency CUR] [-price BUCKET] [-sort ORDER] [-page N] [-per-page N] LISTINGS.json...", shopCommand},
+	"slugs":      {"slugs [-max N] [-all] [-n] LISTINGS.json  or  slugs -title TITLE...", slugsCommand},
+	"sign":       {"sign -hmac-key FILE | -ed25519-key FILE INVOICE...", signCommand},
+	"upgrade":    {"upgrade [-passphrase P] INVOICE...  (or -list)", upgradeCommand},
+	"verify":     {"verify -hmac-key FILE | -ed25519-pub FILE INVOICE...", verifyCommand},
+}
+
+// runCommand runs the command named by args[0] with the remaining args.
+func runCommand(args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage()
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	err := cmd.Run(args[1:])
+	if err == errUsage {
+		fmt.Fprintf(os.Stderr, "usage: %s %s\n", os.Args[0], cmd.Usage)
+	}
+	return err
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: %s [command]\n\ncommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", commands[name].Usage)
+	}
+}
@@ -0,0 +1,275 @@
+/**
+ * Encryption at rest for invoice files.
+ *
+ * Files ending in ".enc" are AES-256-GCM encrypted with a key derived from a
+ * passphrase by scrypt. The plain text is split into chunks which are sealed
+ * one by one, so files of any size are streamed. Each chunk nonce carries the
+ * chunk counter and a last-chunk flag, which detects reordered, dropped or
+ * truncated chunks. Encryption is applied last, e.g. invoices.json.gz.enc.
+ *
+ * Header layout:
+ *   magic "INVE" | version | log2(N) | r | p | chunk size (uint32) |
+ *   salt (16) | nonce prefix (7) | key check (16)
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	encryptedSuffix = ".enc"
+	cryptMagic      = "INVE"
+	cryptVersion    = 1
+	cryptChunkSize  = 64 * 1024
+	cryptSaltSize   = 16
+	cryptPrefixSize = 7
+	cryptKeySize    = 32
+
+	// scrypt cost parameters recommended for interactive logins.
+	scryptLogN = 15
+	scryptR    = 8
+	scryptP    = 1
+
+	// The most a file may ask for. The parameters come from the header,
+	// and scrypt needs 128·r·N bytes, so they are bounded before use.
+	scryptMaxLogN = 20
+	scryptMaxR    = 16
+	scryptMaxP    = 4
+
+	cryptHeaderSize = len(cryptMagic) + 4 + 4 + cryptSaltSize + cryptPrefixSize
+)
+
+var (
+	// ErrWrongPassphrase is returned when the passphrase does not match
+	// the one the file was encrypted with.
+	ErrWrongPassphrase = errors.New("wrong passphrase for encrypted invoice file")
+	// ErrNoPassphrase is returned when an encrypted file is opened without
+	// a passphrase.
+	ErrNoPassphrase = errors.New("invoice file is encrypted but no passphrase was given")
+	// ErrCorrupted is returned when an encrypted file fails authentication
+	// after the passphrase was accepted.
+	ErrCorrupted = errors.New("encrypted invoice file is corrupted or truncated")
+)
+
+// cryptStream holds the state shared by the encrypting writer and the
+// decrypting reader.
+type cryptStream struct {
+	aead    cipher.AEAD
+	header  []byte // authenticated with every chunk
+	prefix  []byte
+	counter uint32
+}
+
+func newCryptStream(passphrase, header []byte) (*cryptStream, error) {
+	logN, r, p := uint(header[5]), int(header[6]), int(header[7])
+	if logN < 10 || logN > scryptMaxLogN || r < 1 || r > scryptMaxR || p < 1 || p > scryptMaxP {
+		return nil, fmt.Errorf("unsupported scrypt cost N=2^%d r=%d p=%d", logN, r, p)
+	}
+	salt := header[12 : 12+cryptSaltSize]
+	key, err := scrypt.Key(passphrase, salt, 1<<logN, r, p, cryptKeySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &cryptStream{
+		aead:   aead,
+		header: header,
+		prefix: header[12+cryptSaltSize:],
+	}, nil
+}
+
+// nonce returns prefix | counter | flag, where flag is 1 on the last chunk.
+func (s *cryptStream) nonce(counter uint32, flag byte) []byte {
+	nonce := make([]byte, 0, s.aead.NonceSize())
+	nonce = append(nonce, s.prefix...)
+	nonce = append(nonce, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(nonce[cryptPrefixSize:], counter)
+	return append(nonce, flag)
+}
+
+// keyCheck seals an empty message under a nonce no chunk uses, so a wrong
+// passphrase is told apart from a damaged file.
+func (s *cryptStream) keyCheck() []byte {
+	return s.aead.Seal(nil, s.nonce(^uint32(0), 2), nil, s.header)
+}
+
+func (s *cryptStream) next() ([]byte, error) {
+	if s.counter == ^uint32(0) {
+		return nil, errors.New("encrypted invoice file is too large")
+	}
+	counter := s.counter
+	s.counter++
+	return s.nonce(counter, 0), nil
+}
+
+type encryptWriter struct {
+	*cryptStream
+	writer io.Writer
+	buffer []byte
+}
+
+// newEncryptWriter writes the header to writer and returns a writer which
+// encrypts everything written to it. Close must be called to write the last
+// chunk; it does not close writer.
+func newEncryptWriter(writer io.Writer, passphrase []byte) (io.WriteCloser, error) {
+	header := make([]byte, cryptHeaderSize)
+	copy(header, cryptMagic)
+	header[4] = cryptVersion
+	header[5], header[6], header[7] = scryptLogN, scryptR, scryptP
+	binary.BigEndian.PutUint32(header[8:12], cryptChunkSize)
+	if _, err := io.ReadFull(rand.Reader, header[12:]); err != nil {
+		return nil, err
+	}
+	stream, err := newCryptStream(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(header); err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(stream.keyCheck()); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{stream, writer, make([]byte, 0, cryptChunkSize)}, nil
+}
+
+func (w *encryptWriter) Write(data []byte) (int, error) {
+	written := 0
+	for len(data) > 0 {
+		// A full buffer is only sealed once more data arrives, so Close
+		// always has a last chunk to write.
+		if len(w.buffer) == cryptChunkSize {
+			if err := w.seal(0); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buffer[len(w.buffer):cryptChunkSize], data)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		data = data[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encryptWriter) Close() error {
+	return w.seal(1)
+}
+
+func (w *encryptWriter) seal(last byte) error {
+	nonce, err := w.next()
+	if err != nil {
+		return err
+	}
+	nonce[len(nonce)-1] = last
+	_, err = w.writer.Write(w.aead.Seal(nil, nonce, w.buffer, w.header))
+	w.buffer = w.buffer[:0]
+	return err
+}
+
+type decryptReader struct {
+	*cryptStream
+	reader *bufio.Reader
+	chunk  []byte
+	plain  []byte
+	done   bool
+}
+
+// newDecryptReader reads the header from reader, checks the passphrase and
+// returns a reader of the decrypted content.
+func newDecryptReader(reader io.Reader, passphrase []byte) (io.Reader, error) {
+	if len(passphrase) == 0 {
+		return nil, ErrNoPassphrase
+	}
+	header := make([]byte, cryptHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("reading encryption header: %v", err)
+	}
+	if string(header[:4]) != cryptMagic {
+		return nil, errors.New("not an encrypted invoice file")
+	}
+	if header[4] != cryptVersion {
+		return nil, fmt.Errorf("encryption version %d is not supported", header[4])
+	}
+	chunkSize := int(binary.BigEndian.Uint32(header[8:12]))
+	if chunkSize <= 0 || chunkSize > 16*1024*1024 {
+		return nil, fmt.Errorf("invalid encryption chunk size %d", chunkSize)
+	}
+	stream, err := newCryptStream(passphrase, header)
+	if err != nil {
+		return nil, err
+	}
+	check := make([]byte, stream.aead.Overhead())
+	if _, err = io.ReadFull(reader, check); err != nil {
+		return nil, ErrCorrupted
+	}
+	if _, err = stream.aead.Open(nil, stream.nonce(^uint32(0), 2), check, header); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return &decryptReader{
+		cryptStream: stream,
+		reader:      bufio.NewReader(reader),
+		chunk:       make([]byte, chunkSize+stream.aead.Overhead()),
+	}, nil
+}
+
+func (r *decryptReader) Read(data []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(data, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *decryptReader) open() error {
+	n, err := io.ReadFull(r.reader, r.chunk)
+	if err == io.EOF || (err == nil && n < len(r.chunk)) {
+		return ErrCorrupted
+	}
+	last := err == io.ErrUnexpectedEOF
+	if err != nil && !last {
+		return err
+	}
+	if !last {
+		if _, err = r.reader.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	nonce, err := r.next()
+	if err != nil {
+		return err
+	}
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	if r.plain, err = r.aead.Open(r.chunk[:0], nonce, r.chunk[:n], r.header); err != nil {
+		return ErrCorrupted
+	}
+	r.done = last
+	return nil
+}
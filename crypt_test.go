@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encrypt(t *testing.T, plain []byte, passphrase string) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	writer, err := newEncryptWriter(&sealed, []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = writer.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func decrypt(sealed []byte, passphrase string) ([]byte, error) {
+	reader, err := newDecryptReader(bytes.NewReader(sealed), []byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+func TestCryptRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, cryptChunkSize - 1, cryptChunkSize, cryptChunkSize + 1, 3 * cryptChunkSize} {
+		plain := bytes.Repeat([]byte("x"), size)
+		got, err := decrypt(encrypt(t, plain, "secret"), "secret")
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: got %d bytes back", size, len(got))
+		}
+	}
+}
+
+func TestCryptRejects(t *testing.T) {
+	sealed := encrypt(t, bytes.Repeat([]byte("x"), 2*cryptChunkSize+10), "secret")
+	withHeader := func(i int, value byte) []byte {
+		crafted := append([]byte{}, sealed...)
+		crafted[i] = value
+		return crafted
+	}
+	tests := []struct {
+		name       string
+		sealed     []byte
+		passphrase string
+		want       string
+	}{
+		{"no passphrase", sealed, "", ErrNoPassphrase.Error()},
+		{"wrong passphrase", sealed, "guess", ErrWrongPassphrase.Error()},
+		{"truncated", sealed[:len(sealed)-100], "secret", ErrCorrupted.Error()},
+		{"last chunk dropped", sealed[:len(sealed)-26], "secret", ErrCorrupted.Error()},
+		{"flipped byte", withHeader(len(sealed)-1, sealed[len(sealed)-1]^1), "secret", ErrCorrupted.Error()},
+		{"huge N", withHeader(5, 30), "secret", "unsupported scrypt cost"},
+		{"huge r", withHeader(6, 255), "secret", "unsupported scrypt cost"},
+		{"huge p", withHeader(7, 255), "secret", "unsupported scrypt cost"},
+		{"zero r", withHeader(6, 0), "secret", "unsupported scrypt cost"},
+		{"bad magic", withHeader(0, 'X'), "secret", "not an encrypted invoice file"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decrypt(test.sealed, test.passphrase)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestCreateEncryptedFileWithoutPassphraseKeepsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "invoices.json.enc")
+	if err = ioutil.WriteFile(filename, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeInvoiceFile(filename, nil); err != ErrNoPassphrase {
+		t.Fatalf("got %v, want %v", err, ErrNoPassphrase)
+	}
+	if data, _ := ioutil.ReadFile(filename); string(data) != "existing" {
+		t.Errorf("the file now holds %q", data)
+	}
+}
@@ -0,0 +1,301 @@
+/**
+ * Currencies and a dated exchange-rate table.
+ *
+ * Rates are kept like the ECB reference rates: per date, how many units of
+ * each currency one unit of the table's base currency buys. A conversion
+ * uses the latest rates on or before the date asked for, so weekends and
+ * holidays take the rates of the last working day.
+ *
+ * Tables are loaded from JSON, {"Base": "EUR", "Rates": {"2019-01-02":
+ * {"GBP": 0.8945, "USD": 1.1397}}}, or from CSV with a Date column and a
+ * column per currency, the layout of the ECB's eurofxref-hist.csv.
+ * Rates older than maxRateAge are not used, so a table that was not kept
+ * up to date is an error rather than a silently wrong conversion.
+ * Invoices without a Currency are taken to be in whatever currency they are
+ * converted to, which keeps files from before currencies unchanged; a
+ * warning names them.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoRate is returned when the table has no rate for a currency on or
+// before a date.
+var ErrNoRate = errors.New("no exchange rate")
+
+// maxRateAge is how far back rate looks for the last rates before a date.
+// A week covers weekends and the longest run of TARGET holidays.
+const maxRateAge = 7 * day
+
+// validateCurrency accepts ISO 4217 style codes like "GBP", and empty.
+func validateCurrency(code string) error {
+	if code == "" {
+		return nil
+	}
+	notUpper := func(r rune) bool { return r < 'A' || r > 'Z' }
+	if len(code) != 3 || strings.IndexFunc(code, notUpper) >= 0 {
+		return fmt.Errorf("invalid currency %q, use a code such as GBP", code)
+	}
+	return nil
+}
+
+// datedRates are the rates of one date.
+type datedRates struct {
+	Date  time.Time
+	Rates map[string]float64 // units of the currency per unit of Base
+}
+
+// rateTable converts amounts between currencies as of a date.
+type rateTable struct {
+	Base  string
+	dates []datedRates // by date
+}
+
+type jsonRateTable struct {
+	Base  string
+	Rates map[string]map[string]float64 // date in dateFormat -> currency -> rate
+}
+
+func (table *rateTable) add(date time.Time, currency string, rate float64) error {
+	if err := validateCurrency(currency); err != nil || currency == "" {
+		return fmt.Errorf("invalid currency %q", currency)
+	}
+	if !(rate > 0) { // also refuses NaN
+		return fmt.Errorf("%s %s: rate must be positive", date.Format(dateFormat), currency)
+	}
+	i := sort.Search(len(table.dates), func(i int) bool { return !table.dates[i].Date.Before(date) })
+	if i == len(table.dates) || !table.dates[i].Date.Equal(date) {
+		table.dates = append(table.dates, datedRates{})
+		copy(table.dates[i+1:], table.dates[i:])
+		table.dates[i] = datedRates{date, make(map[string]float64)}
+	}
+	table.dates[i].Rates[currency] = rate
+	return nil
+}
+
+// rate returns the rate of currency on or before date, at most maxRateAge
+// before it.
+func (table *rateTable) rate(currency string, date time.Time) (float64, error) {
+	if currency == table.Base {
+		return 1, nil
+	}
+	// The first date after date, so the search starts just before it.
+	i := sort.Search(len(table.dates), func(i int) bool { return table.dates[i].Date.After(date) })
+	oldest := date.Add(-maxRateAge)
+	for i--; i >= 0 && !table.dates[i].Date.Before(oldest); i-- {
+		if rate, ok := table.dates[i].Rates[currency]; ok {
+			return rate, nil
+		}
+	}
+	if i >= 0 {
+		return 0, fmt.Errorf("%s on %s: %w in the %d days before", currency, date.Format(dateFormat),
+			ErrNoRate, int(maxRateAge/day))
+	}
+	return 0, fmt.Errorf("%s on %s: %w", currency, date.Format(dateFormat), ErrNoRate)
+}
+
+// convert returns amount in from as an amount in to, at the rates of date.
+func (table *rateTable) convert(amount float64, from, to string, date time.Time) (float64, error) {
+	if from == to || from == "" || to == "" {
+		return amount, nil
+	}
+	fromRate, err := table.rate(from, date)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := table.rate(to, date)
+	if err != nil {
+		return 0, err
+	}
+	return amount / fromRate * toRate, nil
+}
+
+// loadRateTable reads a JSON or CSV rate table. base names the base
+// currency of CSV tables, which do not state it.
+func loadRateTable(filename, base string) (*rateTable, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var table *rateTable
+	if strings.HasSuffix(strings.ToLower(filename), ".csv") {
+		table, err = readRateCSV(file, base)
+	} else {
+		table, err = readRateJSON(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return table, nil
+}
+
+func readRateJSON(reader io.Reader) (*rateTable, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	var jsonTable jsonRateTable
+	if err = json.Unmarshal(data, &jsonTable); err != nil {
+		return nil, err
+	}
+	if err = validateCurrency(jsonTable.Base); err != nil || jsonTable.Base == "" {
+		return nil, fmt.Errorf("invalid base currency %q", jsonTable.Base)
+	}
+	table := &rateTable{Base: jsonTable.Base}
+	for dateText, rates := range jsonTable.Rates {
+		date, err := time.Parse(dateFormat, dateText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q, use %s", dateText, dateFormat)
+		}
+		for currency, rate := range rates {
+			if err = table.add(date, currency, rate); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return table, nil
+}
+
+// readRateCSV reads a Date column and a column per currency. Empty and
+// "N/A" cells are skipped.
+func readRateCSV(reader io.Reader, base string) (*rateTable, error) {
+	if err := validateCurrency(base); err != nil || base == "" {
+		return nil, fmt.Errorf("invalid base currency %q", base)
+	}
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 || !strings.EqualFold(strings.TrimSpace(header[0]), "date") {
+		return nil, errors.New("the first column must be Date")
+	}
+	table := &rateTable{Base: base}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return table, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		date, err := time.Parse(dateFormat, strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q, use %s", line, record[0], dateFormat)
+		}
+		for i := 1; i < len(record) && i < len(header); i++ {
+			cell := strings.TrimSpace(record[i])
+			currency := strings.TrimSpace(header[i])
+			if cell == "" || cell == "N/A" || currency == "" {
+				continue
+			}
+			rate, err := strconv.ParseFloat(cell, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s rate %q", line, currency, cell)
+			}
+			if err = table.add(date, currency, rate); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+		}
+	}
+}
+
+// normalizeInvoices returns copies of the invoices with prices, payments
+// and charges converted into currency at the rates of each invoice's
+// Raised date. Invoices without a currency are kept as they are, with a
+// warning.
+func normalizeInvoices(invoices []*Invoice, table *rateTable, currency string) ([]*Invoice, error) {
+	normalized := make([]*Invoice, len(invoices))
+	var unknown []string
+	for i, invoice := range invoices {
+		if invoice.Currency == "" {
+			unknown = append(unknown, strconv.Itoa(invoice.Id))
+		}
+		factor, err := table.convert(1, invoice.Currency, currency, invoice.Raised)
+		if err != nil {
+			return nil, fmt.Errorf("invoice %d: %w", invoice.Id, err)
+		}
+		copied := invoice.clone()
+		copied.Currency = currency
+		for _, item := range copied.Items {
+			item.Price *= factor
+		}
+		for _, payment := range copied.Payments {
+			payment.Amount *= factor
+		}
+		for _, record := range copied.Dunning {
+			record.Fee *= factor
+			record.Interest *= factor
+		}
+		normalized[i] = copied
+	}
+	if len(unknown) > 0 {
+		log.Warnf("invoices %s have no currency and are taken to be in %s",
+			strings.Join(unknown, ", "), currency)
+	}
+	return normalized, nil
+}
+
+// currenciesOf returns the distinct currencies the invoices name, sorted.
+func currenciesOf(invoices []*Invoice) []string {
+	seen := make(map[string]bool)
+	var currencies []string
+	for _, invoice := range invoices {
+		if invoice.Currency != "" && !seen[invoice.Currency] {
+			seen[invoice.Currency] = true
+			currencies = append(currencies, invoice.Currency)
+		}
+	}
+	sort.Strings(currencies)
+	return currencies
+}
+
+func convertCommand(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	rates := flags.String("rates", "rates.json", "exchange-rate table, JSON or CSV")
+	base := flags.String("base", "EUR", "base currency of CSV tables")
+	date := flags.String("date", today().Format(dateFormat), "use the rates of this date")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 3 {
+		return errUsage
+	}
+	amount, err := strconv.ParseFloat(flags.Arg(0), 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount %q", flags.Arg(0))
+	}
+	on, err := time.Parse(dateFormat, *date)
+	if err != nil {
+		return err
+	}
+	table, err := loadRateTable(*rates, *base)
+	if err != nil {
+		return err
+	}
+	from, to := strings.ToUpper(flags.Arg(1)), strings.ToUpper(flags.Arg(2))
+	converted, err := table.convert(amount, from, to, on)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%.2f %s = %.2f %s on %s\n", amount, from, converted, to, on.Format(dateFormat))
+	return nil
+}
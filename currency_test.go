@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	table, err := readRateJSON(strings.NewReader(`{"Base": "EUR", "Rates": {
+		"2020-01-02": {"GBP": 0.85, "USD": 1.1},
+		"2020-01-03": {"GBP": 0.86}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := func(text string) time.Time {
+		d, _ := time.Parse(dateFormat, text)
+		return d
+	}
+	tests := []struct {
+		currency, date string
+		want           float64
+		err            error
+	}{
+		{"EUR", "2019-01-01", 1, nil},
+		{"GBP", "2020-01-02", 0.85, nil},
+		{"GBP", "2020-01-05", 0.86, nil},
+		{"USD", "2020-01-05", 1.1, nil},
+		{"USD", "2020-01-09", 1.1, nil},
+		{"USD", "2020-01-10", 0, ErrNoRate},
+		{"GBP", "2020-03-01", 0, ErrNoRate},
+		{"GBP", "2020-01-01", 0, ErrNoRate},
+		{"CHF", "2020-01-02", 0, ErrNoRate},
+	}
+	for _, test := range tests {
+		got, err := table.rate(test.currency, date(test.date))
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("%s on %s: got %v, %v, want %v, %v", test.currency, test.date, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestNormalizeInvoices(t *testing.T) {
+	table, err := readRateJSON(strings.NewReader(`{"Base": "EUR", "Rates": {"2019-12-31": {"GBP": 0.5}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	euros, plain := testInvoice(1, ""), testInvoice(2, "")
+	euros.Currency = "EUR"
+	normalized, err := normalizeInvoices([]*Invoice{euros, plain}, table, "GBP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := normalized[0].Total(); got != 5 || normalized[0].Currency != "GBP" {
+		t.Errorf("10 EUR became %.2f %s, want 5.00 GBP", got, normalized[0].Currency)
+	}
+	if got := normalized[1].Total(); got != 10 || normalized[1].Currency != "GBP" {
+		t.Errorf("10 without a currency became %.2f %s, want 10.00 GBP", got, normalized[1].Currency)
+	}
+	if euros.Total() != 10 || euros.Currency != "EUR" {
+		t.Errorf("the original changed to %.2f %s", euros.Total(), euros.Currency)
+	}
+}
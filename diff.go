@@ -0,0 +1,681 @@
+/**
+ * Structural differences between invoices.
+ *
+ * Invoices are matched by Invoice.Id and compared field by field, and items
+ * are matched by Item.Id, so reordering is not reported as a change. A
+ * three-way merge applies the changes of two copies of the same base and
+ * reports conflicts where both changed the same field differently.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+// ChangeKind tells whether a path was added, removed or changed.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// Change is one difference, e.g. Path "Items[AM2574].Price".
+type Change struct {
+	Kind   ChangeKind
+	Path   string
+	Before string `json:",omitempty"`
+	After  string `json:",omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s: %s", c.Path, c.After)
+	case Removed:
+		return fmt.Sprintf("- %s: %s", c.Path, c.Before)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Path, c.Before, c.After)
+}
+
+type invoiceField struct {
+	name string
+	get  func(*Invoice) string
+	copy func(dst, src *Invoice)
+}
+
+type itemField struct {
+	name string
+	get  func(*Item) string
+	copy func(dst, src *Item)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+var invoiceFields = []invoiceField{
+	{"CustomerId",
+		func(i *Invoice) string { return strconv.Itoa(i.CustomerId) },
+		func(dst, src *Invoice) { dst.CustomerId = src.CustomerId }},
+	{"Raised",
+		func(i *Invoice) string { return i.Raised.Format(dateFormat) },
+		func(dst, src *Invoice) { dst.Raised = src.Raised }},
+	{"Due",
+		func(i *Invoice) string { return i.Due.Format(dateFormat) },
+		func(dst, src *Invoice) { dst.Due = src.Due }},
+	{"Paid",
+		func(i *Invoice) string { return strconv.FormatBool(i.Paid) },
+		func(dst, src *Invoice) { dst.Paid = src.Paid }},
+	{"Note",
+		func(i *Invoice) string { return i.Note },
+		func(dst, src *Invoice) { dst.Note = src.Note }},
+	{"Currency",
+		func(i *Invoice) string { return i.Currency },
+		func(dst, src *Invoice) { dst.Currency = src.Currency }},
+}
+
+var itemFields = []itemField{
+	{"Price",
+		func(i *Item) string { return formatFloat(i.Price) },
+		func(dst, src *Item) { dst.Price = src.Price }},
+	{"Quantity",
+		func(i *Item) string { return strconv.Itoa(i.Quantity) },
+		func(dst, src *Item) { dst.Quantity = src.Quantity }},
+	{"Note",
+		func(i *Item) string { return i.Note },
+		func(dst, src *Item) { dst.Note = src.Note }},
+}
+
+func formatItem(item *Item) string {
+	return fmt.Sprintf("%s x %d", formatFloat(item.Price), item.Quantity)
+}
+
+func formatPayment(payment *PaymentRecord) string {
+	return fmt.Sprintf("%s %s %s", payment.Date.Format(dateFormat),
+		formatFloat(payment.Amount), payment.Reference)
+}
+
+// diffInvoice returns the changes that turn before into after. Either may
+// be nil for a created or removed invoice.
+func diffInvoice(before, after *Invoice) []Change {
+	var changes []Change
+	switch {
+	case before == nil && after == nil:
+		return nil
+	case before == nil:
+		return []Change{{Kind: Added, Path: invoicePath(after.Id), After: formatInvoice(after)}}
+	case after == nil:
+		return []Change{{Kind: Removed, Path: invoicePath(before.Id), Before: formatInvoice(before)}}
+	}
+	for _, field := range invoiceFields {
+		if b, a := field.get(before), field.get(after); b != a {
+			changes = append(changes, Change{Changed, field.name, b, a})
+		}
+	}
+	changes = append(changes, diffItems(before.Items, after.Items)...)
+	changes = append(changes, diffPayments(before.Payments, after.Payments)...)
+	return append(changes, diffDunning(before.Dunning, after.Dunning)...)
+}
+
+func invoicePath(id int) string {
+	return fmt.Sprintf("Invoice[%d]", id)
+}
+
+func itemPath(id string) string {
+	return fmt.Sprintf("Items[%s]", id)
+}
+
+func formatInvoice(invoice *Invoice) string {
+	return fmt.Sprintf("customer %d, %d items, total %s", invoice.CustomerId,
+		len(invoice.Items), formatFloat(invoice.Total()))
+}
+
+func itemIndex(items []*Item) map[string]*Item {
+	index := make(map[string]*Item, len(items))
+	for _, item := range items {
+		index[item.Id] = item
+	}
+	return index
+}
+
+func diffItems(before, after []*Item) []Change {
+	var changes []Change
+	afterIndex := itemIndex(after)
+	beforeIndex := itemIndex(before)
+	for _, b := range before {
+		a, ok := afterIndex[b.Id]
+		if !ok {
+			changes = append(changes, Change{Kind: Removed, Path: itemPath(b.Id), Before: formatItem(b)})
+			continue
+		}
+		for _, field := range itemFields {
+			if bv, av := field.get(b), field.get(a); bv != av {
+				changes = append(changes, Change{Changed, itemPath(b.Id) + "." + field.name, bv, av})
+			}
+		}
+	}
+	for _, a := range after {
+		if _, ok := beforeIndex[a.Id]; !ok {
+			changes = append(changes, Change{Kind: Added, Path: itemPath(a.Id), After: formatItem(a)})
+		}
+	}
+	return changes
+}
+
+// diffPayments compares payments by position, since they are only appended.
+func diffPayments(before, after []*PaymentRecord) []Change {
+	var changes []Change
+	for i := 0; i < len(before) || i < len(after); i++ {
+		path := fmt.Sprintf("Payments[%d]", i)
+		switch {
+		case i >= len(after):
+			changes = append(changes, Change{Kind: Removed, Path: path, Before: formatPayment(before[i])})
+		case i >= len(before):
+			changes = append(changes, Change{Kind: Added, Path: path, After: formatPayment(after[i])})
+		default:
+			if b, a := formatPayment(before[i]), formatPayment(after[i]); b != a {
+				changes = append(changes, Change{Changed, path, b, a})
+			}
+		}
+	}
+	return changes
+}
+
+func formatDunning(record *DunningRecord) string {
+	return fmt.Sprintf("%s %s fee %s interest %s", record.Date.Format(dateFormat),
+		record.Level, formatFloat(record.Fee), formatFloat(record.Interest))
+}
+
+// diffDunning compares dunning records by position, like payments.
+func diffDunning(before, after []*DunningRecord) []Change {
+	var changes []Change
+	for i := 0; i < len(before) || i < len(after); i++ {
+		path := fmt.Sprintf("Dunning[%d]", i)
+		switch {
+		case i >= len(after):
+			changes = append(changes, Change{Kind: Removed, Path: path, Before: formatDunning(before[i])})
+		case i >= len(before):
+			changes = append(changes, Change{Kind: Added, Path: path, After: formatDunning(after[i])})
+		default:
+			if b, a := formatDunning(before[i]), formatDunning(after[i]); b != a {
+				changes = append(changes, Change{Changed, path, b, a})
+			}
+		}
+	}
+	return changes
+}
+
+// InvoiceDiff holds the changes of one invoice.
+type InvoiceDiff struct {
+	Id      int
+	Changes []Change
+}
+
+// diffInvoiceSets returns the changed invoices ordered by Id.
+func diffInvoiceSets(before, after []*Invoice) []InvoiceDiff {
+	beforeIndex, afterIndex := invoiceIndex(before), invoiceIndex(after)
+	var diffs []InvoiceDiff
+	for _, id := range unionIds(beforeIndex, afterIndex) {
+		if changes := diffInvoice(beforeIndex[id], afterIndex[id]); len(changes) > 0 {
+			diffs = append(diffs, InvoiceDiff{id, changes})
+		}
+	}
+	return diffs
+}
+
+func unionIds(indexes ...map[int]*Invoice) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, index := range indexes {
+		for id := range index {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func sameInvoice(a, b *Invoice) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return len(diffInvoice(a, b)) == 0
+}
+
+func sameItem(a, b *Item) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// Side names one of the inputs of a three-way merge.
+type Side int
+
+const (
+	Ours Side = iota
+	Theirs
+	Base
+)
+
+func (s Side) String() string {
+	return [...]string{"ours", "theirs", "base"}[s]
+}
+
+// Conflict is a path both sides changed differently, or an invoice when
+// Path is empty. Until it is resolved the merge keeps our value.
+type Conflict struct {
+	InvoiceId int
+	Path      string
+	Values    [3]string // indexed by Side
+	Resolved  bool
+	resolve   func(side Side)
+}
+
+// Resolve takes the value of the given side.
+func (c *Conflict) Resolve(side Side) {
+	c.resolve(side)
+	c.Resolved = true
+}
+
+// Markers renders the conflict like a version control conflict.
+func (c *Conflict) Markers() string {
+	path := invoicePath(c.InvoiceId)
+	if c.Path != "" {
+		path += "." + c.Path
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<<<<<<< ours %s\n%s\n", path, c.Values[Ours])
+	fmt.Fprintf(&b, "||||||| base\n%s\n", c.Values[Base])
+	fmt.Fprintf(&b, "=======\n%s\n>>>>>>> theirs\n", c.Values[Theirs])
+	return b.String()
+}
+
+// MergeResult holds the merged invoices and the conflicts left to resolve.
+type MergeResult struct {
+	invoices  map[int]*Invoice
+	Conflicts []*Conflict
+}
+
+// Invoices returns the merged invoices ordered by Id.
+func (r *MergeResult) Invoices() []*Invoice {
+	invoices := make([]*Invoice, 0, len(r.invoices))
+	for _, id := range unionIds(r.invoices) {
+		invoices = append(invoices, r.invoices[id])
+	}
+	return invoices
+}
+
+// Unresolved returns how many conflicts are still open.
+func (r *MergeResult) Unresolved() int {
+	n := 0
+	for _, conflict := range r.Conflicts {
+		if !conflict.Resolved {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *MergeResult) keep(id int, invoice *Invoice) {
+	if invoice == nil {
+		delete(r.invoices, id)
+	} else {
+		r.invoices[id] = invoice.clone()
+	}
+}
+
+func describeInvoice(invoice *Invoice) string {
+	if invoice == nil {
+		return "(deleted)"
+	}
+	return formatInvoice(invoice)
+}
+
+func describeItem(item *Item) string {
+	if item == nil {
+		return "(deleted)"
+	}
+	return formatItem(item)
+}
+
+// mergeInvoices merges the changes made in ours and theirs since base.
+func mergeInvoices(base, ours, theirs []*Invoice) *MergeResult {
+	baseIndex, ourIndex, theirIndex := invoiceIndex(base), invoiceIndex(ours), invoiceIndex(theirs)
+	result := &MergeResult{invoices: make(map[int]*Invoice)}
+	for _, id := range unionIds(baseIndex, ourIndex, theirIndex) {
+		id := id
+		b, o, t := baseIndex[id], ourIndex[id], theirIndex[id]
+		switch {
+		case sameInvoice(o, t), sameInvoice(b, t):
+			result.keep(id, o)
+		case sameInvoice(b, o):
+			result.keep(id, t)
+		case b != nil && (o == nil || t == nil):
+			// Deleted on one side and changed on the other.
+			result.keep(id, o)
+			sides := [3]*Invoice{o, t, b}
+			result.Conflicts = append(result.Conflicts, &Conflict{
+				InvoiceId: id,
+				Values:    [3]string{describeInvoice(o), describeInvoice(t), describeInvoice(b)},
+				resolve:   func(side Side) { result.keep(id, sides[side]) },
+			})
+		default:
+			if b == nil {
+				// Added on both sides; fields that agree merge cleanly.
+				b = &Invoice{Id: id}
+			}
+			result.mergeInvoice(b, o, t)
+		}
+	}
+	return result
+}
+
+func (r *MergeResult) mergeInvoice(b, o, t *Invoice) {
+	merged := o.clone()
+	r.invoices[merged.Id] = merged
+	sides := [3]*Invoice{o, t, b}
+	for _, field := range invoiceFields {
+		field := field
+		bv, ov, tv := field.get(b), field.get(o), field.get(t)
+		switch {
+		case ov == tv || bv == tv:
+		case bv == ov:
+			field.copy(merged, t)
+		default:
+			r.Conflicts = append(r.Conflicts, &Conflict{
+				InvoiceId: merged.Id,
+				Path:      field.name,
+				Values:    [3]string{ov, tv, bv},
+				resolve:   func(side Side) { field.copy(merged, sides[side]) },
+			})
+		}
+	}
+	r.mergeItems(merged, b.Items, o.Items, t.Items)
+	r.mergePayments(merged, b, o, t)
+	mergeDunning(merged, o, t)
+}
+
+// mergeDunning keeps the dunning records of both sides. They are only
+// appended, so ours are followed by the ones only they recorded.
+func mergeDunning(merged, o, t *Invoice) {
+	seen := make(map[string]bool)
+	merged.Dunning = nil
+	for _, side := range []*Invoice{o, t} {
+		for _, record := range side.Dunning {
+			if key := formatDunning(record); !seen[key] {
+				seen[key] = true
+				recordCopy := *record
+				merged.Dunning = append(merged.Dunning, &recordCopy)
+			}
+		}
+	}
+}
+
+func (r *MergeResult) mergeItems(merged *Invoice, base, ours, theirs []*Item) {
+	baseIndex, ourIndex, theirIndex := itemIndex(base), itemIndex(ours), itemIndex(theirs)
+	// Keep our order and append the items only they added.
+	ids := make([]string, 0, len(ours)+len(theirs))
+	for _, item := range ours {
+		ids = append(ids, item.Id)
+	}
+	for _, item := range theirs {
+		if _, ok := ourIndex[item.Id]; !ok {
+			ids = append(ids, item.Id)
+		}
+	}
+	for _, item := range base {
+		if _, ok := ourIndex[item.Id]; !ok {
+			if _, ok = theirIndex[item.Id]; !ok {
+				ids = append(ids, item.Id)
+			}
+		}
+	}
+	merged.Items = nil
+	for _, id := range ids {
+		id := id
+		b, o, t := baseIndex[id], ourIndex[id], theirIndex[id]
+		switch {
+		case sameItem(o, t), sameItem(b, t):
+			setItem(merged, id, o)
+		case sameItem(b, o):
+			setItem(merged, id, t)
+		case b != nil && (o == nil || t == nil):
+			setItem(merged, id, o)
+			sides := [3]*Item{o, t, b}
+			r.Conflicts = append(r.Conflicts, &Conflict{
+				InvoiceId: merged.Id,
+				Path:      itemPath(id),
+				Values:    [3]string{describeItem(o), describeItem(t), describeItem(b)},
+				resolve:   func(side Side) { setItem(merged, id, sides[side]) },
+			})
+		default:
+			if b == nil {
+				b = &Item{Id: id}
+			}
+			r.mergeItem(merged, b, o, t)
+		}
+	}
+}
+
+func (r *MergeResult) mergeItem(merged *Invoice, b, o, t *Item) {
+	item := *o
+	mergedItem := &item
+	merged.Items = append(merged.Items, mergedItem)
+	sides := [3]*Item{o, t, b}
+	for _, field := range itemFields {
+		field := field
+		bv, ov, tv := field.get(b), field.get(o), field.get(t)
+		switch {
+		case ov == tv || bv == tv:
+		case bv == ov:
+			field.copy(mergedItem, t)
+		default:
+			r.Conflicts = append(r.Conflicts, &Conflict{
+				InvoiceId: merged.Id,
+				Path:      itemPath(o.Id) + "." + field.name,
+				Values:    [3]string{ov, tv, bv},
+				resolve:   func(side Side) { field.copy(mergedItem, sides[side]) },
+			})
+		}
+	}
+}
+
+// setItem replaces, adds or with a nil item removes the item with id.
+func setItem(invoice *Invoice, id string, item *Item) {
+	for i, existing := range invoice.Items {
+		if existing.Id == id {
+			if item == nil {
+				invoice.Items = append(invoice.Items[:i], invoice.Items[i+1:]...)
+			} else {
+				itemCopy := *item
+				invoice.Items[i] = &itemCopy
+			}
+			return
+		}
+	}
+	if item != nil {
+		itemCopy := *item
+		invoice.Items = append(invoice.Items, &itemCopy)
+	}
+}
+
+// mergePayments keeps the payments of whichever side added some. Equal
+// payments are told apart by how many of them each side has, so two real
+// payments with the same date, amount and reference both stay. Payments
+// removed on either side stay removed; ours come first, followed by the
+// ones only they recorded.
+func (r *MergeResult) mergePayments(merged, b, o, t *Invoice) {
+	count := func(invoice *Invoice) map[string]int {
+		counts := make(map[string]int)
+		for _, payment := range invoice.Payments {
+			counts[formatPayment(payment)]++
+		}
+		return counts
+	}
+	baseCounts, ourCounts, theirCounts := count(b), count(o), count(t)
+	wanted := func(key string) int {
+		nb, no, nt := baseCounts[key], ourCounts[key], theirCounts[key]
+		kept := nb
+		if no < kept {
+			kept = no
+		}
+		if nt < kept {
+			kept = nt
+		}
+		added := 0
+		if no > nb {
+			added += no - nb
+		}
+		if nt > nb {
+			added += nt - nb
+		}
+		return kept + added
+	}
+	taken := make(map[string]int)
+	merged.Payments = nil
+	for _, side := range []*Invoice{o, t} {
+		for _, payment := range side.Payments {
+			key := formatPayment(payment)
+			if taken[key] < wanted(key) {
+				taken[key]++
+				paymentCopy := *payment
+				merged.Payments = append(merged.Payments, &paymentCopy)
+			}
+		}
+	}
+}
+
+func printDiffs(writer io.Writer, diffs []InvoiceDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(writer, "No differences.")
+		return
+	}
+	for _, diff := range diffs {
+		fmt.Fprintf(writer, "%s:\n", invoicePath(diff.Id))
+		for _, change := range diff.Changes {
+			fmt.Fprintf(writer, "    %v\n", change)
+		}
+	}
+}
+
+func readInvoiceFiles(filenames ...string) ([][]*Invoice, error) {
+	sets := make([][]*Invoice, len(filenames))
+	for i, filename := range filenames {
+		invoices, err := readInvoiceFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		sets[i] = invoices
+	}
+	return sets, nil
+}
+
+// DiffInvoices asks for two invoice files and shows their differences.
+func DiffInvoices() {
+	before, err := menu.Ask("Old invoice file", "", nil)
+	if err != nil {
+		return
+	}
+	after, err := menu.Ask("New invoice file", "", nil)
+	if err != nil {
+		return
+	}
+	if err = diffCommand([]string{before, after}); err != nil {
+		log.Errorln(err)
+	}
+}
+
+// MergeInvoices asks for a base and two edited copies, merges them and lets
+// the user resolve each conflict before the result is written.
+func MergeInvoices() {
+	var filenames [4]string
+	labels := []string{"Base invoice file", "Our invoice file", "Their invoice file", "Output file"}
+	for i, label := range labels {
+		var err error
+		if filenames[i], err = menu.Ask(label, "", nil); err != nil {
+			return
+		}
+	}
+	sets, err := readInvoiceFiles(filenames[:3]...)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	result := mergeInvoices(sets[0], sets[1], sets[2])
+	for i, conflict := range result.Conflicts {
+		fmt.Printf("Conflict %d of %d:\n%s", i+1, len(result.Conflicts), conflict.Markers())
+		choices := []string{
+			"Keep ours: " + conflict.Values[Ours],
+			"Take theirs: " + conflict.Values[Theirs],
+			"Use base: " + conflict.Values[Base],
+		}
+		side, err := menu.Choose("Resolve with", choices)
+		if err != nil {
+			log.Warnf("Merge abandoned, %d conflicts left", result.Unresolved())
+			return
+		}
+		conflict.Resolve(Side(side))
+	}
+	if err = writeInvoiceFile(filenames[3], result.Invoices()); err != nil {
+		log.Errorln(err)
+		return
+	}
+	log.Infof("Merged %d invoices into %s", len(result.invoices), filenames[3])
+}
+
+func diffCommand(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	sets, err := readInvoiceFiles(args...)
+	if err != nil {
+		return err
+	}
+	printDiffs(os.Stdout, diffInvoiceSets(sets[0], sets[1]))
+	return nil
+}
+
+// mergeCommand writes the merge to OUT, keeping our values where there are
+// conflicts, and writes the conflict markers to OUT.conflicts.
+func mergeCommand(args []string) error {
+	if len(args) != 4 {
+		return errUsage
+	}
+	sets, err := readInvoiceFiles(args[:3]...)
+	if err != nil {
+		return err
+	}
+	result := mergeInvoices(sets[0], sets[1], sets[2])
+	if err = writeInvoiceFile(args[3], result.Invoices()); err != nil {
+		return err
+	}
+	if len(result.Conflicts) == 0 {
+		log.Infof("Merged %d invoices into %s", len(result.invoices), args[3])
+		return nil
+	}
+	var markers strings.Builder
+	for _, conflict := range result.Conflicts {
+		markers.WriteString(conflict.Markers())
+	}
+	conflictFile := args[3] + ".conflicts"
+	if err = ioutil.WriteFile(conflictFile, []byte(markers.String()), 0644); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d conflicts, see %s", len(result.Conflicts), conflictFile)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func testInvoice(id int, note string, payments ...*PaymentRecord) *Invoice {
+	raised := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &Invoice{
+		Id:       id,
+		Raised:   raised,
+		Due:      raised.AddDate(0, 0, 30),
+		Note:     note,
+		Items:    []*Item{{Id: "A", Price: 10, Quantity: 1}},
+		Payments: payments,
+	}
+}
+
+func TestMergeResolvesDeleteModifyPerInvoice(t *testing.T) {
+	base := []*Invoice{testInvoice(1, "one"), testInvoice(2, "two")}
+	var ours []*Invoice // both deleted
+	theirs := []*Invoice{testInvoice(1, "one changed"), testInvoice(2, "two changed")}
+
+	result := mergeInvoices(base, ours, theirs)
+	if len(result.Conflicts) != 2 {
+		t.Fatalf("got %d conflicts, want 2", len(result.Conflicts))
+	}
+	resolved, other := result.Conflicts[0].InvoiceId, result.Conflicts[1].InvoiceId
+	result.Conflicts[0].Resolve(Theirs)
+	invoice, ok := result.invoices[resolved]
+	if !ok {
+		t.Fatalf("invoice %d was not restored", resolved)
+	}
+	if want := theirs[resolved-1].Note; invoice.Id != resolved || invoice.Note != want {
+		t.Errorf("invoice %d holds invoice %d %q, want %q", resolved, invoice.Id, invoice.Note, want)
+	}
+	if _, ok := result.invoices[other]; ok {
+		t.Errorf("invoice %d was restored by the conflict of invoice %d", other, resolved)
+	}
+}
+
+func TestMergePayments(t *testing.T) {
+	day := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	p := func(amount float64) *PaymentRecord {
+		return &PaymentRecord{Date: day, Amount: amount, Reference: "ref"}
+	}
+	tests := []struct {
+		name               string
+		base, ours, theirs []*PaymentRecord
+		want               []float64
+	}{
+		{"none", nil, nil, nil, nil},
+		{"ours added", nil, []*PaymentRecord{p(5)}, nil, []float64{5}},
+		{"two equal payments added", nil, []*PaymentRecord{p(5), p(5)}, nil, []float64{5, 5}},
+		{"both added an equal payment", []*PaymentRecord{p(5)}, []*PaymentRecord{p(5), p(5)}, []*PaymentRecord{p(5), p(5)}, []float64{5, 5, 5}},
+		{"theirs removed one of two", []*PaymentRecord{p(5), p(5)}, []*PaymentRecord{p(5), p(5)}, []*PaymentRecord{p(5)}, []float64{5}},
+		{"ours removed, theirs added another", []*PaymentRecord{p(5)}, nil, []*PaymentRecord{p(5), p(7)}, []float64{7}},
+		{"ours first", nil, []*PaymentRecord{p(3)}, []*PaymentRecord{p(4)}, []float64{3, 4}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := testInvoice(1, "")
+			result := &MergeResult{invoices: make(map[int]*Invoice)}
+			result.mergePayments(merged, testInvoice(1, "", test.base...), testInvoice(1, "", test.ours...),
+				testInvoice(1, "", test.theirs...))
+			var got []float64
+			for _, payment := range merged.Payments {
+				got = append(got, payment.Amount)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
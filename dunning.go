@@ -0,0 +1,328 @@
+/**
+ * Dunning of overdue invoices.
+ *
+ * Levels are reached a number of days after Due, e.g. a reminder at +7, a
+ * warning at +30 and a final notice at +60. An invoice gets a notice for
+ * the highest level it has reached unless that level is already recorded
+ * on it, so running the dunning again does not send a notice twice. Each
+ * level may charge a fixed fee and simple interest on the unpaid part of
+ * the invoice total since Due, less the interest charged before. A notice
+ * which skips levels, e.g. a final notice for an invoice never dunned
+ * before, also charges the fees of the levels skipped since the last
+ * notice. Charges are part of the invoice balance, so paying the amount
+ * due settles it.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+// dunningLevel is one step of the dunning process.
+type dunningLevel struct {
+	Name           string
+	Days           int     // days after Due
+	Fee            float64 // fixed fee charged when the level is reached
+	AnnualInterest float64 // percent per year on the balance since Due
+	Template       string  // text/template executed with a dunningNotice
+}
+
+// dunningConfig lists the levels in the order they are reached.
+type dunningConfig struct {
+	Levels []dunningLevel
+}
+
+func defaultDunningConfig() dunningConfig {
+	return dunningConfig{Levels: []dunningLevel{
+		{
+			Name: "reminder",
+			Days: 7,
+			Template: "Dear customer {{.Invoice.CustomerId}},\n\n" +
+				"invoice {{.Invoice.Id}} was due on {{date .Invoice.Due}}. " +
+				"Please pay the open amount of {{money .Balance}} at your earliest convenience.\n",
+		},
+		{
+			Name:           "warning",
+			Days:           30,
+			Fee:            10,
+			AnnualInterest: 8,
+			Template: "Dear customer {{.Invoice.CustomerId}},\n\n" +
+				"invoice {{.Invoice.Id}} is {{.DaysOverdue}} days overdue. " +
+				"We have added a fee of {{money .Fee}} and interest of {{money .Interest}}. " +
+				"Please pay {{money .AmountDue}} within 14 days.\n",
+		},
+		{
+			Name:           "final",
+			Days:           60,
+			Fee:            25,
+			AnnualInterest: 8,
+			Template: "FINAL NOTICE to customer {{.Invoice.CustomerId}}\n\n" +
+				"invoice {{.Invoice.Id}} is {{.DaysOverdue}} days overdue. " +
+				"Unless {{money .AmountDue}} is paid within 7 days we will pass the debt on for collection.\n",
+		},
+	}}
+}
+
+// loadDunningConfig reads levels from a JSON file shaped like
+// dunningConfig.
+func loadDunningConfig(filename string) (dunningConfig, error) {
+	var config dunningConfig
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return config, err
+	}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%s: %v", filename, err)
+	}
+	return config, config.validate()
+}
+
+func (c dunningConfig) validate() error {
+	if len(c.Levels) == 0 {
+		return errors.New("no dunning levels configured")
+	}
+	names := make(map[string]bool)
+	for i, level := range c.Levels {
+		switch {
+		case level.Name == "" || names[level.Name]:
+			return fmt.Errorf("dunning level %d needs a unique name", i+1)
+		case i > 0 && level.Days <= c.Levels[i-1].Days:
+			return fmt.Errorf("dunning level %s must come later than %s", level.Name, c.Levels[i-1].Name)
+		case level.Fee < 0 || level.AnnualInterest < 0:
+			return fmt.Errorf("dunning level %s has a negative charge", level.Name)
+		}
+		if _, err := parseDunningTemplate(level); err != nil {
+			return err
+		}
+		names[level.Name] = true
+	}
+	return nil
+}
+
+var dunningFuncs = template.FuncMap{
+	"date":  func(t time.Time) string { return t.Format(dateFormat) },
+	"money": func(f float64) string { return fmt.Sprintf("%.2f", f) },
+}
+
+func parseDunningTemplate(level dunningLevel) (*template.Template, error) {
+	return template.New(level.Name).Funcs(dunningFuncs).Parse(level.Template)
+}
+
+// dunningNotice is a notice to send for one invoice.
+type dunningNotice struct {
+	Invoice     *Invoice
+	Level       dunningLevel
+	Date        time.Time
+	DaysOverdue int
+	Balance     float64 // owed before this notice, earlier charges included
+	Fee         float64 // charged by this notice, skipped levels included
+	Interest    float64 // charged by this notice
+	AmountDue   float64 // balance plus the charges of this notice
+	Message     string
+}
+
+// chargedInterest returns the interest already recorded on the invoice.
+func chargedInterest(invoice *Invoice) float64 {
+	interest := 0.0
+	for _, record := range invoice.Dunning {
+		interest += record.Interest
+	}
+	return interest
+}
+
+func hasDunningLevel(invoice *Invoice, level string) bool {
+	for _, record := range invoice.Dunning {
+		if record.Level == level {
+			return true
+		}
+	}
+	return false
+}
+
+func roundCents(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+// notices returns the notices due on asOf.
+func (c dunningConfig) notices(invoices []*Invoice, asOf time.Time) ([]*dunningNotice, error) {
+	var notices []*dunningNotice
+	for _, invoice := range invoices {
+		balance := invoice.Balance()
+		if balance < paidTolerance {
+			continue
+		}
+		overdue := int(asOf.Sub(invoice.Due).Hours() / 24)
+		var reached *dunningLevel
+		fee := 0.0 // of the levels reached since the last one recorded
+		for i := range c.Levels {
+			if overdue < c.Levels[i].Days {
+				continue
+			}
+			reached = &c.Levels[i]
+			if hasDunningLevel(invoice, reached.Name) {
+				fee = 0
+			} else {
+				fee += reached.Fee
+			}
+		}
+		if reached == nil || hasDunningLevel(invoice, reached.Name) {
+			continue
+		}
+		notice := &dunningNotice{
+			Invoice:     invoice,
+			Level:       *reached,
+			Date:        asOf,
+			DaysOverdue: overdue,
+			Balance:     balance,
+			Fee:         fee,
+		}
+		if reached.AnnualInterest > 0 {
+			unpaid := math.Max(0, invoice.Total()-invoice.AmountPaid())
+			total := unpaid * reached.AnnualInterest / 100 * float64(overdue) / 365
+			notice.Interest = math.Max(0, roundCents(total-chargedInterest(invoice)))
+		}
+		notice.AmountDue = roundCents(balance + notice.Fee + notice.Interest)
+		tmpl, err := parseDunningTemplate(*reached)
+		if err != nil {
+			return nil, err
+		}
+		var message bytes.Buffer
+		if err = tmpl.Execute(&message, notice); err != nil {
+			return nil, fmt.Errorf("invoice %d: %v", invoice.Id, err)
+		}
+		notice.Message = message.String()
+		notices = append(notices, notice)
+	}
+	return notices, nil
+}
+
+// record marks the notice's level as reached on its invoice.
+func (n *dunningNotice) record() {
+	n.Invoice.Dunning = append(n.Invoice.Dunning, &DunningRecord{
+		Level:    n.Level.Name,
+		Date:     n.Date,
+		Fee:      n.Fee,
+		Interest: n.Interest,
+	})
+}
+
+func printNotices(notices []*dunningNotice) {
+	if len(notices) == 0 {
+		fmt.Println("No notices due.")
+		return
+	}
+	for _, notice := range notices {
+		fmt.Printf("---- %s for invoice %d ----\n%s\n", notice.Level.Name, notice.Invoice.Id, notice.Message)
+	}
+}
+
+// Dunning asks for an invoice file, shows the notices due today and records
+// them on the invoices when confirmed.
+func Dunning() {
+	filename, err := menu.Ask("Invoice file", "", nil)
+	if err != nil {
+		return
+	}
+	invoices, err := readInvoiceFile(filename)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	before := cloneInvoices(invoices)
+	notices, err := defaultDunningConfig().notices(invoices, today())
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	printNotices(notices)
+	if len(notices) == 0 {
+		return
+	}
+	answer, err := menu.Choose(fmt.Sprintf("Record %d notices as sent", len(notices)), []string{"No", "Yes"})
+	if err != nil || answer == 0 {
+		return
+	}
+	journal, err := menu.Ask("Journal file", "invoices.journal", validateRequired)
+	if err != nil {
+		return
+	}
+	for _, notice := range notices {
+		notice.record()
+	}
+	if err = saveJournaled(journal, filename, "dunning", before, invoices); err != nil {
+		log.Errorln(err)
+		return
+	}
+	log.Infof("Recorded %d notices in %s", len(notices), filename)
+}
+
+func dunningCommand(args []string) error {
+	flags := flag.NewFlagSet("dunning", flag.ContinueOnError)
+	configFile := flags.String("config", "", "JSON file with the dunning levels")
+	asOf := flags.String("as-of", today().Format(dateFormat), "date of the run")
+	record := flags.Bool("record", false, "record the notices on the invoices")
+	outDir := flags.String("out", "", "write each notice to DIR/INVOICE-LEVEL.txt")
+	passphrase := flags.String("passphrase", "", "passphrase for .enc files")
+	journal := flags.String("journal", "invoices.journal", "audit journal recording the notices with -record")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	config := defaultDunningConfig()
+	if *configFile != "" {
+		var err error
+		if config, err = loadDunningConfig(*configFile); err != nil {
+			return err
+		}
+	}
+	date, err := time.Parse(dateFormat, *asOf)
+	if err != nil {
+		return err
+	}
+	filename := flags.Arg(0)
+	invoices, err := readInvoiceFile(filename, decryptWith([]byte(*passphrase)))
+	if err != nil {
+		return err
+	}
+	before := cloneInvoices(invoices)
+	notices, err := config.notices(invoices, date)
+	if err != nil {
+		return err
+	}
+	if *outDir == "" {
+		printNotices(notices)
+	}
+	for _, notice := range notices {
+		if *outDir != "" {
+			name := filepath.Join(*outDir, fmt.Sprintf("%d-%s.txt", notice.Invoice.Id, notice.Level.Name))
+			if err = ioutil.WriteFile(name, []byte(notice.Message), 0644); err != nil {
+				return err
+			}
+		}
+		notice.record()
+	}
+	if !*record || len(notices) == 0 {
+		return nil
+	}
+	err = saveJournaled(*journal, filename, "dunning", before, invoices, encryptWith([]byte(*passphrase)))
+	if err != nil {
+		return err
+	}
+	log.Infof("Recorded %d notices in %s", len(notices), filename)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDunningAmountDueSettlesInvoice(t *testing.T) {
+	invoice := testInvoice(1, "") // 10 due 2020-01-31
+	config := defaultDunningConfig()
+	asOf := invoice.Due.AddDate(0, 0, 40)
+	notices, err := config.notices([]*Invoice{invoice}, asOf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 1 || notices[0].Level.Name != "warning" {
+		t.Fatalf("got %d notices, want a warning", len(notices))
+	}
+	notice := notices[0]
+	notice.record()
+	if got := invoice.Balance(); got != notice.AmountDue {
+		t.Fatalf("balance %.2f, but the notice asks for %.2f", got, notice.AmountDue)
+	}
+
+	// The customer pays what the notice asked for.
+	credit := bankCredit{Line: 2, Date: asOf.AddDate(0, 0, 3), Amount: notice.AmountDue, Reference: "Invoice 1"}
+	matched, review, unmatched := reconcile([]bankCredit{credit}, []*Invoice{invoice}, 30)
+	if matched != 1 || len(review) != 0 || len(unmatched) != 0 {
+		t.Fatalf("matched %d, %d to review, %d unmatched", matched, len(review), len(unmatched))
+	}
+	if !invoice.Paid || invoice.Balance() != 0 {
+		t.Errorf("paid %v with a balance of %.2f", invoice.Paid, invoice.Balance())
+	}
+}
+
+func TestDunningNotices(t *testing.T) {
+	due := time.Date(2020, 1, 31, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		overdue   int
+		recorded  []*DunningRecord
+		level     string
+		fee       float64
+		amountDue float64
+	}{
+		{"not overdue enough", 6, nil, "", 0, 0},
+		{"reminder", 7, nil, "reminder", 0, 10},
+		{"reminder already sent", 20, []*DunningRecord{{Level: "reminder", Date: due}}, "", 0, 0},
+		{"warning", 30, nil, "warning", 10, 20.07},
+		{"final after warning", 60, []*DunningRecord{{Level: "warning", Date: due, Fee: 10, Interest: 0.07}},
+			"final", 25, 45.13},
+		// The warning was skipped, so the final notice charges its fee too.
+		{"final without notices", 60, nil, "final", 35, 45.13},
+		{"final after reminder", 60, []*DunningRecord{{Level: "reminder", Date: due}}, "final", 35, 45.13},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invoice := testInvoice(1, "")
+			invoice.Dunning = test.recorded
+			notices, err := defaultDunningConfig().notices([]*Invoice{invoice}, due.AddDate(0, 0, test.overdue))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.level == "" {
+				if len(notices) > 0 {
+					t.Errorf("got a %s notice, want none", notices[0].Level.Name)
+				}
+				return
+			}
+			if len(notices) != 1 || notices[0].Level.Name != test.level ||
+				notices[0].Fee != test.fee || notices[0].AmountDue != test.amountDue {
+				for _, notice := range notices {
+					t.Logf("%s: fee %.2f, %.2f", notice.Level.Name, notice.Fee, notice.AmountDue)
+				}
+				t.Fatalf("want a %s notice with a fee of %.2f asking for %.2f", test.level, test.fee, test.amountDue)
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+/**
+ * Interactive invoice editor.
+ *
+ * Invoices of a file are edited in memory and only written back, through
+ * saveJournaled, when saved, so the journal records what each save
+ * changed. An edited invoice must pass validate before it replaces the
+ * original, so the file never holds an invoice the other commands would
+ * refuse.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+func validateDate(input string) error {
+	if _, err := time.Parse(dateFormat, input); err != nil {
+		return fmt.Errorf("use %s", dateFormat)
+	}
+	return nil
+}
+
+func validatePrice(input string) error {
+	price, err := strconv.ParseFloat(input, 64)
+	if err != nil || price < 0 {
+		return errors.New("invalid price")
+	}
+	return nil
+}
+
+func validateQuantity(input string) error {
+	quantity, err := strconv.Atoi(input)
+	if err != nil || quantity <= 0 {
+		return errors.New("quantity must be a positive number")
+	}
+	return nil
+}
+
+func validateRequired(input string) error {
+	if strings.TrimSpace(input) == "" {
+		return errors.New("required")
+	}
+	return nil
+}
+
+// invoiceEditor holds the invoices of a file while they are edited.
+type invoiceEditor struct {
+	filename   string
+	journal    string
+	passphrase []byte
+	invoices   []*Invoice
+	saved      []*Invoice // as the file holds them
+	changed    bool
+}
+
+func openInvoiceEditor(filename, journal string, passphrase []byte) (*invoiceEditor, error) {
+	editor := &invoiceEditor{filename: filename, journal: journal, passphrase: passphrase}
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		log.Infof("%s does not exist yet, it is created on save", filename)
+		return editor, nil
+	}
+	invoices, err := readInvoiceFile(filename, decryptWith(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	editor.invoices = invoices
+	editor.saved = cloneInvoices(invoices)
+	return editor, nil
+}
+
+func (e *invoiceEditor) save() error {
+	err := saveJournaled(e.journal, e.filename, "editor", e.saved, e.invoices, encryptWith(e.passphrase))
+	if err != nil {
+		return err
+	}
+	e.saved = cloneInvoices(e.invoices)
+	e.changed = false
+	log.Infof("Saved %d invoices to %s", len(e.invoices), e.filename)
+	return nil
+}
+
+func (e *invoiceEditor) nextId() int {
+	id := 0
+	for _, invoice := range e.invoices {
+		if invoice.Id > id {
+			id = invoice.Id
+		}
+	}
+	return id + 1
+}
+
+// choose lets the user pick an invoice, returning its index.
+func (e *invoiceEditor) choose(label string) (int, error) {
+	if len(e.invoices) == 0 {
+		return 0, errors.New("there are no invoices")
+	}
+	return menu.Choose(label, e.summaries())
+}
+
+func (e *invoiceEditor) summaries() []string {
+	lines := make([]string, len(e.invoices))
+	for i, invoice := range e.invoices {
+		lines[i] = invoiceSummary(invoice)
+	}
+	return lines
+}
+
+func invoiceSummary(invoice *Invoice) string {
+	status := "open"
+	if invoice.Paid {
+		status = "paid"
+	}
+	return fmt.Sprintf("%d  customer %d  raised %s  due %s  %.2f %s %s", invoice.Id,
+		invoice.CustomerId, invoice.Raised.Format(dateFormat), invoice.Due.Format(dateFormat),
+		invoice.Total(), invoice.Currency, status)
+}
+
+func printInvoice(invoice *Invoice) {
+	fmt.Println(invoiceSummary(invoice))
+	if invoice.Note != "" {
+		fmt.Printf("  Note: %s\n", invoice.Note)
+	}
+	for _, item := range invoice.Items {
+		fmt.Printf("  %-10s %4d x %10.2f  %s\n", item.Id, item.Quantity, item.Price, item.Note)
+	}
+	for _, payment := range invoice.Payments {
+		fmt.Printf("  paid %s %.2f %s\n", payment.Date.Format(dateFormat), payment.Amount, payment.Reference)
+	}
+	fmt.Printf("  Balance: %.2f\n", invoice.Balance())
+}
+
+// askDate asks for a date, offering value when it is set.
+func askDate(label string, value time.Time) (time.Time, error) {
+	defaultValue := ""
+	if !value.IsZero() {
+		defaultValue = value.Format(dateFormat)
+	}
+	text, err := menu.Ask(label, defaultValue, validateDate)
+	if err != nil {
+		return value, err
+	}
+	return time.Parse(dateFormat, text)
+}
+
+func askInt(label string, value int, validate func(string) error) (int, error) {
+	text, err := menu.Ask(label, strconv.Itoa(value), validate)
+	if err != nil {
+		return value, err
+	}
+	return strconv.Atoi(text)
+}
+
+// editItem prompts for every field of item.
+func editItem(item *Item) (err error) {
+	if item.Id, err = menu.Ask("Item Id", item.Id, validateRequired); err != nil {
+		return err
+	}
+	price, err := menu.Ask("Price", strconv.FormatFloat(item.Price, 'f', 2, 64), validatePrice)
+	if err != nil {
+		return err
+	}
+	item.Price, _ = strconv.ParseFloat(price, 64)
+	if item.Quantity, err = askInt("Quantity", item.Quantity, validateQuantity); err != nil {
+		return err
+	}
+	item.Note, err = menu.Ask("Note", item.Note, nil)
+	return err
+}
+
+// editItems adds, edits and deletes the items of invoice.
+func editItems(invoice *Invoice) error {
+	for {
+		choices := make([]string, len(invoice.Items), len(invoice.Items)+2)
+		for i, item := range invoice.Items {
+			choices[i] = fmt.Sprintf("%s  %d x %.2f  %s", item.Id, item.Quantity, item.Price, item.Note)
+		}
+		choices = append(choices, "Add item", "Done")
+		i, err := menu.Choose("Items", choices)
+		if err != nil {
+			return err
+		}
+		switch {
+		case i == len(invoice.Items):
+			item := &Item{Quantity: 1}
+			if err = editItem(item); err != nil {
+				return err
+			}
+			invoice.Items = append(invoice.Items, item)
+		case i == len(invoice.Items)+1:
+			return nil
+		default:
+			action, err := menu.Choose(invoice.Items[i].Id, []string{"Edit", "Delete", "Back"})
+			if err != nil {
+				return err
+			}
+			switch action {
+			case 0:
+				if err = editItem(invoice.Items[i]); err != nil {
+					return err
+				}
+			case 1:
+				invoice.Items = append(invoice.Items[:i], invoice.Items[i+1:]...)
+			}
+		}
+	}
+}
+
+// editInvoice prompts for the fields and items of invoice.
+func editInvoice(invoice *Invoice) (err error) {
+	if invoice.CustomerId, err = askInt("Customer Id", invoice.CustomerId, validateInt); err != nil {
+		return err
+	}
+	if invoice.Raised, err = askDate("Raised", invoice.Raised); err != nil {
+		return err
+	}
+	if invoice.Due, err = askDate("Due", invoice.Due); err != nil {
+		return err
+	}
+	if invoice.Note, err = menu.Ask("Note", invoice.Note, nil); err != nil {
+		return err
+	}
+	if invoice.Currency, err = menu.Ask("Currency", invoice.Currency, validateCurrency); err != nil {
+		return err
+	}
+	if len(invoice.Payments) == 0 {
+		paid, err := menu.Choose("Paid", []string{"No", "Yes"})
+		if err != nil {
+			return err
+		}
+		invoice.Paid = paid == 1
+	}
+	return editItems(invoice)
+}
+
+// commit validates the edited copy and stores it at index, or appends it
+// when index is -1.
+func (e *invoiceEditor) commit(index int, edited *Invoice) error {
+	if err := edited.validate(); err != nil {
+		return fmt.Errorf("invoice %d not changed: %v", edited.Id, err)
+	}
+	if index < 0 {
+		e.invoices = append(e.invoices, edited)
+	} else {
+		e.invoices[index] = edited
+	}
+	e.changed = true
+	return nil
+}
+
+func (e *invoiceEditor) run() {
+	actions := []string{"List", "View", "Create", "Edit", "Delete", "Save", "Quit"}
+	for {
+		title := e.filename
+		if e.changed {
+			title += " (modified)"
+		}
+		action, err := menu.Choose(title, actions)
+		if err != nil {
+			return
+		}
+		switch actions[action] {
+		case "List":
+			for _, line := range e.summaries() {
+				fmt.Println(line)
+			}
+		case "View":
+			var i int
+			if i, err = e.choose("Invoice"); err == nil {
+				printInvoice(e.invoices[i])
+			}
+		case "Create":
+			raised := today()
+			invoice := &Invoice{Id: e.nextId(), Raised: raised, Due: raised.Add(30 * day)}
+			if invoice.Id, err = askInt("Invoice Id", invoice.Id, validateInt); err != nil {
+				break
+			}
+			if invoiceIndex(e.invoices)[invoice.Id] != nil {
+				err = fmt.Errorf("invoice %d: %w", invoice.Id, ErrInvoiceExists)
+				break
+			}
+			if err = editInvoice(invoice); err == nil {
+				err = e.commit(-1, invoice)
+			}
+		case "Edit":
+			var i int
+			if i, err = e.choose("Invoice"); err != nil {
+				break
+			}
+			edited := e.invoices[i].clone()
+			if err = editInvoice(edited); err == nil {
+				err = e.commit(i, edited)
+			}
+		case "Delete":
+			var i int
+			if i, err = e.choose("Delete invoice"); err != nil {
+				break
+			}
+			var sure int
+			sure, err = menu.Choose(fmt.Sprintf("Delete invoice %d", e.invoices[i].Id), []string{"No", "Yes"})
+			if err == nil && sure == 1 {
+				e.invoices = append(e.invoices[:i], e.invoices[i+1:]...)
+				e.changed = true
+			}
+		case "Save":
+			err = e.save()
+		case "Quit":
+			if e.changed {
+				var discard int
+				discard, err = menu.Choose("Discard the unsaved changes", []string{"No", "Yes"})
+				if err != nil || discard == 0 {
+					break
+				}
+			}
+			return
+		}
+		if err != nil {
+			log.Errorln(err)
+		}
+	}
+}
+
+// InvoiceEditor asks for an invoice file and edits its invoices.
+func InvoiceEditor() {
+	filename, err := menu.Ask("Invoice file", "invoices.json", validateRequired)
+	if err != nil {
+		return
+	}
+	var passphrase []byte
+	if strings.HasSuffix(filename, encryptedSuffix) {
+		text, err := menu.AskPassword("Passphrase")
+		if err != nil {
+			return
+		}
+		passphrase = []byte(text)
+	}
+	journal, err := menu.Ask("Journal file", "invoices.journal", validateRequired)
+	if err != nil {
+		return
+	}
+	editor, err := openInvoiceEditor(filename, journal, passphrase)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	editor.run()
+}
@@ -0,0 +1,217 @@
+/**
+ * Invoice fixtures for load testing.
+ *
+ * The generator draws everything from one num.Random, so the same spec and
+ * seed always produce the same invoices.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/icodebb/go-play-ground/num"
+	log "github.com/sirupsen/logrus"
+)
+
+// fixtureSpec describes the invoices to generate.
+type fixtureSpec struct {
+	Count     int
+	Seed      int64
+	FirstId   int
+	Customers int // size of the customer pool
+	Products  int // size of the SKU catalog
+	MaxItems  int // items per invoice, at least one
+	From, To  time.Time
+	PaidRatio float64 // share of invoices which are paid
+}
+
+func defaultFixtureSpec() fixtureSpec {
+	return fixtureSpec{
+		Count:     1000,
+		Seed:      1,
+		FirstId:   1000,
+		Customers: 200,
+		Products:  500,
+		MaxItems:  8,
+		From:      time.Date(2012, time.January, 1, 0, 0, 0, 0, time.UTC),
+		To:        time.Date(2012, time.December, 31, 0, 0, 0, 0, time.UTC),
+		PaidRatio: 0.8,
+	}
+}
+
+var fixtureNotes = []string{
+	"Use trade entrance",
+	"Deliver to reception",
+	"Leave with neighbour",
+	"Call before delivery",
+	"Fragile, handle with care",
+	"Back door after 5pm",
+	"Signature required",
+}
+
+// maxFixtureProducts bounds the SKU catalog. There are 25*25*9000 SKUs of
+// two letters and four digits; a tenth of them keeps drawing unique ones
+// quick.
+const maxFixtureProducts = 25 * 25 * 9000 / 10
+
+// paymentTerms are the days between Raised and Due a customer can have.
+var paymentTerms = []int{14, 30, 30, 30, 45, 60}
+
+type fixtureProduct struct {
+	sku   string
+	price float64
+}
+
+type fixtureCustomer struct {
+	id    int
+	terms int
+	delay int // usual days paid after Due, may be negative
+}
+
+func (spec fixtureSpec) validate() error {
+	switch {
+	case spec.Count < 0:
+		return errors.New("count must not be negative")
+	case spec.Customers <= 0 || spec.Products <= 0 || spec.MaxItems <= 0:
+		return errors.New("customers, products and items must be positive")
+	case spec.Products > maxFixtureProducts:
+		return fmt.Errorf("at most %d products", maxFixtureProducts)
+	case !spec.To.After(spec.From):
+		return errors.New("the date range is empty")
+	case spec.PaidRatio < 0 || spec.PaidRatio > 1:
+		return errors.New("paid ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// generateInvoices returns spec.Count invoices with Ids from spec.FirstId.
+func generateInvoices(spec fixtureSpec) ([]*Invoice, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	random := num.NewRandom(spec.Seed)
+
+	products := make([]fixtureProduct, spec.Products)
+	skus := make(map[string]bool)
+	for i := range products {
+		sku := random.RandomString(2) + fmt.Sprintf("%04d", random.RandomInt(1000, 10000))
+		for skus[sku] {
+			sku = random.RandomString(2) + fmt.Sprintf("%04d", random.RandomInt(1000, 10000))
+		}
+		skus[sku] = true
+		// Prices in cents between 1.00 and 999.99.
+		products[i] = fixtureProduct{sku, float64(random.RandomInt(100, 100000)) / 100}
+	}
+
+	customers := make([]fixtureCustomer, spec.Customers)
+	for i := range customers {
+		customers[i] = fixtureCustomer{
+			id:    random.RandomInt(100, 1000) + 1000*i,
+			terms: paymentTerms[random.RandomInt(0, len(paymentTerms))],
+			delay: random.RandomInt(-10, 30),
+		}
+	}
+
+	days := int(spec.To.Sub(spec.From).Hours()/24) + 1
+	invoices := make([]*Invoice, spec.Count)
+	for i := range invoices {
+		// Squaring the draw makes a few customers order much more often.
+		pick := random.RandomInt(0, spec.Customers*spec.Customers)
+		customer := customers[isqrt(pick)]
+		raised := spec.From.AddDate(0, 0, random.RandomInt(0, days))
+		invoice := &Invoice{
+			Id:         spec.FirstId + i,
+			CustomerId: customer.id,
+			Raised:     raised,
+			Due:        raised.AddDate(0, 0, customer.terms),
+		}
+		if random.RandomInt(0, 3) == 0 {
+			invoice.Note = fixtureNotes[random.RandomInt(0, len(fixtureNotes))]
+		}
+		used := make(map[string]bool)
+		for n := random.RandomInt(1, spec.MaxItems+1); n > 0; n-- {
+			product := products[random.RandomInt(0, len(products))]
+			if used[product.sku] {
+				continue
+			}
+			used[product.sku] = true
+			item := &Item{Id: product.sku, Price: product.price, Quantity: random.RandomInt(1, 25)}
+			if random.RandomInt(0, 10) == 0 {
+				item.Note = fixtureNotes[random.RandomInt(0, len(fixtureNotes))]
+			}
+			invoice.Items = append(invoice.Items, item)
+		}
+		if float64(random.RandomInt(0, 10000)) < spec.PaidRatio*10000 {
+			delay := customer.delay + random.RandomInt(-5, 6)
+			paidOn := invoice.Due.AddDate(0, 0, delay)
+			if paidOn.Before(raised) {
+				paidOn = raised
+			}
+			invoice.addPayment(&PaymentRecord{
+				Date:      paidOn,
+				Amount:    math.Round(invoice.Total()*100) / 100,
+				Reference: fmt.Sprintf("INV%d", invoice.Id),
+			})
+		}
+		invoices[i] = invoice
+	}
+	return invoices, nil
+}
+
+// isqrt returns the integer square root of n.
+func isqrt(n int) int {
+	r := 0
+	for (r+1)*(r+1) <= n {
+		r++
+	}
+	return r
+}
+
+// writeFixtures generates invoices and writes them with the writer,
+// compression and encryption chosen by the filename.
+func writeFixtures(filename string, spec fixtureSpec, options ...writeOption) error {
+	invoices, err := generateInvoices(spec)
+	if err != nil {
+		return err
+	}
+	return writeInvoiceFile(filename, invoices, options...)
+}
+
+func fixturesCommand(args []string) error {
+	spec := defaultFixtureSpec()
+	flags := flag.NewFlagSet("fixtures", flag.ContinueOnError)
+	flags.IntVar(&spec.Count, "n", spec.Count, "number of invoices")
+	flags.Int64Var(&spec.Seed, "seed", spec.Seed, "random seed")
+	flags.IntVar(&spec.FirstId, "first-id", spec.FirstId, "Id of the first invoice")
+	flags.IntVar(&spec.Customers, "customers", spec.Customers, "number of customers")
+	flags.IntVar(&spec.Products, "products", spec.Products, "number of SKUs")
+	flags.IntVar(&spec.MaxItems, "max-items", spec.MaxItems, "most items per invoice")
+	flags.Float64Var(&spec.PaidRatio, "paid", spec.PaidRatio, "share of paid invoices")
+	from := flags.String("from", spec.From.Format(dateFormat), "first Raised date")
+	to := flags.String("to", spec.To.Format(dateFormat), "last Raised date")
+	passphrase := flags.String("passphrase", "", "passphrase for .enc files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	var err error
+	if spec.From, err = time.Parse(dateFormat, *from); err != nil {
+		return err
+	}
+	if spec.To, err = time.Parse(dateFormat, *to); err != nil {
+		return err
+	}
+	filename := flags.Arg(0)
+	if err = writeFixtures(filename, spec, encryptWith([]byte(*passphrase))); err != nil {
+		return err
+	}
+	log.Infof("Wrote %d invoices to %s", spec.Count, filename)
+	return nil
+}
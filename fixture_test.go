@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixtureSpecValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*fixtureSpec)
+		err    string
+	}{
+		{"defaults", func(*fixtureSpec) {}, ""},
+		{"most products", func(spec *fixtureSpec) { spec.Products = maxFixtureProducts }, ""},
+		{"too many products", func(spec *fixtureSpec) { spec.Products = maxFixtureProducts + 1 }, "at most"},
+		{"no products", func(spec *fixtureSpec) { spec.Products = 0 }, "must be positive"},
+		{"negative count", func(spec *fixtureSpec) { spec.Count = -1 }, "count"},
+		{"empty range", func(spec *fixtureSpec) { spec.To = spec.From }, "date range"},
+		{"paid ratio", func(spec *fixtureSpec) { spec.PaidRatio = 1.5 }, "paid ratio"},
+	}
+	for _, test := range tests {
+		spec := defaultFixtureSpec()
+		test.change(&spec)
+		err := spec.validate()
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, want an error with %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestFixturesDependOnlyOnTheSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, seed int64) []byte {
+		spec := defaultFixtureSpec()
+		spec.Count = 200
+		spec.Seed = seed
+		filename := filepath.Join(dir, name)
+		if err := writeFixtures(filename, spec); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	for _, suffix := range []string{".json", ".json.gz"} {
+		first := write("first"+suffix, 42)
+		again := write("again"+suffix, 42)
+		other := write("other"+suffix, 43)
+		if !bytes.Equal(first, again) {
+			t.Errorf("%s: the same seed wrote different files", suffix)
+		}
+		if bytes.Equal(first, other) {
+			t.Errorf("%s: different seeds wrote the same file", suffix)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+/**
+ * Cash-flow forecast of open invoices.
+ *
+ * Every invoice with a balance is expected to be paid its customer's usual
+ * number of days after Due, learnt from the invoices they settled before.
+ * The best and worst cases use the customer's early and late payments, the
+ * 10th and 90th percentiles. Customers without history get the figures of
+ * all customers.
+ *
+ * Balances in different currencies are never added up: there is a forecast
+ * per currency, unless -currency converts them all into one first.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+const day = 24 * time.Hour
+
+// paymentDelay is how many days after Due a customer pays.
+type paymentDelay struct {
+	Early, Expected, Late float64
+	Samples               int
+}
+
+// forecastWeek holds the inflows expected in the week from Start.
+type forecastWeek struct {
+	Start                 time.Time
+	Best, Expected, Worst float64
+	Invoices              int // expected to be paid this week
+}
+
+// cashFlowForecast is a forecast of Weeks weeks of the invoices in
+// Currency. Overdue amounts expected before the first week are counted in
+// it, and Later holds what is expected after the last.
+type cashFlowForecast struct {
+	Currency string // empty for invoices without a currency
+	AsOf     time.Time
+	Weeks    []forecastWeek
+	Later    forecastWeek
+}
+
+// percentile returns the p-th percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+func delayOf(days []float64) paymentDelay {
+	sort.Float64s(days)
+	sum := 0.0
+	for _, d := range days {
+		sum += d
+	}
+	delay := paymentDelay{Samples: len(days)}
+	if len(days) > 0 {
+		delay.Early = percentile(days, 0.1)
+		delay.Expected = sum / float64(len(days))
+		delay.Late = percentile(days, 0.9)
+	}
+	return delay
+}
+
+// customerDelays learns the payment delays of each customer and of all
+// customers together from the settled invoices.
+func customerDelays(invoices []*Invoice) (map[int]paymentDelay, paymentDelay) {
+	byCustomer := make(map[int][]float64)
+	var all []float64
+	for _, invoice := range invoices {
+		paid, ok := paidOn(invoice)
+		if !ok {
+			continue
+		}
+		days := paid.Sub(invoice.Due).Hours() / 24
+		byCustomer[invoice.CustomerId] = append(byCustomer[invoice.CustomerId], days)
+		all = append(all, days)
+	}
+	delays := make(map[int]paymentDelay, len(byCustomer))
+	for customer, days := range byCustomer {
+		delays[customer] = delayOf(days)
+	}
+	return delays, delayOf(all)
+}
+
+// newCashFlowForecast returns an empty forecast of weeks weeks from asOf.
+func newCashFlowForecast(currency string, asOf time.Time, weeks int) *cashFlowForecast {
+	forecast := &cashFlowForecast{Currency: currency, AsOf: asOf, Weeks: make([]forecastWeek, weeks)}
+	for i := range forecast.Weeks {
+		forecast.Weeks[i].Start = asOf.Add(time.Duration(i) * 7 * day)
+	}
+	forecast.Later.Start = asOf.Add(time.Duration(weeks) * 7 * day)
+	return forecast
+}
+
+// week returns the bucket of a date; overdue dates fall in the first.
+func (f *cashFlowForecast) week(date time.Time) *forecastWeek {
+	index := int(math.Floor(date.Sub(f.AsOf).Hours() / 24 / 7))
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(f.Weeks) {
+		return &f.Later
+	}
+	return &f.Weeks[index]
+}
+
+// forecastCashFlow projects the open balances into weeks from asOf, one
+// forecast per currency, sorted by currency. Payment delays are learnt
+// from the invoices of all currencies.
+func forecastCashFlow(invoices []*Invoice, asOf time.Time, weeks int) []*cashFlowForecast {
+	delays, overall := customerDelays(invoices)
+	byCurrency := make(map[string]*cashFlowForecast)
+	var forecasts []*cashFlowForecast
+	after := func(due time.Time, days float64) time.Time {
+		return due.Add(time.Duration(math.Round(days)) * day)
+	}
+	for _, invoice := range invoices {
+		balance := invoice.Balance()
+		if balance < paidTolerance {
+			continue
+		}
+		forecast, ok := byCurrency[invoice.Currency]
+		if !ok {
+			forecast = newCashFlowForecast(invoice.Currency, asOf, weeks)
+			byCurrency[invoice.Currency] = forecast
+			forecasts = append(forecasts, forecast)
+		}
+		delay, ok := delays[invoice.CustomerId]
+		if !ok {
+			delay = overall
+		}
+		forecast.week(after(invoice.Due, delay.Early)).Best += balance
+		expected := forecast.week(after(invoice.Due, delay.Expected))
+		expected.Expected += balance
+		expected.Invoices++
+		forecast.week(after(invoice.Due, delay.Late)).Worst += balance
+	}
+	if len(forecasts) == 0 {
+		forecasts = append(forecasts, newCashFlowForecast("", asOf, weeks))
+	}
+	sort.Slice(forecasts, func(i, j int) bool { return forecasts[i].Currency < forecasts[j].Currency })
+	return forecasts
+}
+
+// title is the heading of the forecast, naming its currency if it has one.
+func (f cashFlowForecast) title() string {
+	if f.Currency == "" {
+		return "Expected receivables as of " + f.AsOf.Format(dateFormat)
+	}
+	return fmt.Sprintf("Expected receivables in %s as of %s", f.Currency, f.AsOf.Format(dateFormat))
+}
+
+func (f cashFlowForecast) writeTable(writer io.Writer) error {
+	fmt.Fprintf(writer, "%s\n\n", f.title())
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(table, "Week of\tInvoices\tBest\tExpected\tWorst\t\n")
+	var total forecastWeek
+	for _, week := range append(f.Weeks, f.Later) {
+		label := week.Start.Format(dateFormat)
+		if week.Start.Equal(f.Later.Start) {
+			label = "Later"
+		}
+		fmt.Fprintf(table, "%s\t%d\t%.2f\t%.2f\t%.2f\t\n", label, week.Invoices,
+			week.Best, week.Expected, week.Worst)
+		total.Invoices += week.Invoices
+		total.Best += week.Best
+		total.Expected += week.Expected
+		total.Worst += week.Worst
+	}
+	fmt.Fprintf(table, "Total\t%d\t%.2f\t%.2f\t%.2f\t\n", total.Invoices,
+		total.Best, total.Expected, total.Worst)
+	return table.Flush()
+}
+
+// writeBars draws the expected inflow of each week with '#', and the band
+// between the best and worst case with '-'.
+func (f cashFlowForecast) writeBars(writer io.Writer) error {
+	fmt.Fprintf(writer, "%s ('#' expected, '-' best to worst)\n\n", f.title())
+	max := 0.0
+	for _, week := range f.Weeks {
+		max = math.Max(max, math.Max(week.Expected, math.Max(week.Best, week.Worst)))
+	}
+	scale := func(value float64) int {
+		if max == 0 {
+			return 0
+		}
+		return int(value/max*barWidth + 0.5)
+	}
+	for _, week := range f.Weeks {
+		low := scale(math.Min(week.Best, week.Worst))
+		high := scale(math.Max(week.Best, week.Worst))
+		expected := scale(week.Expected)
+		bar := []byte(strings.Repeat(" ", barWidth))
+		for i := low; i < high && i < barWidth; i++ {
+			bar[i] = '-'
+		}
+		for i := 0; i < expected && i < barWidth; i++ {
+			bar[i] = '#'
+		}
+		_, err := fmt.Fprintf(writer, "%s |%s %.2f\n", week.Start.Format(dateFormat),
+			bar, week.Expected)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeForecasts writes the forecasts in format, table or bars, with a
+// blank line between them.
+func writeForecasts(writer io.Writer, forecasts []*cashFlowForecast, format string) error {
+	var write func(cashFlowForecast, io.Writer) error
+	switch format {
+	case "table":
+		write = cashFlowForecast.writeTable
+	case "bars":
+		write = cashFlowForecast.writeBars
+	default:
+		return fmt.Errorf("unknown forecast format %q", format)
+	}
+	for i, forecast := range forecasts {
+		if i > 0 {
+			fmt.Fprintln(writer)
+		}
+		if err := write(*forecast, writer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CashFlowForecast asks for invoice files and shows the upcoming weeks.
+func CashFlowForecast() {
+	patterns, err := menu.Ask("Invoice files (patterns allowed)", "*.json*", nil)
+	if err != nil {
+		return
+	}
+	weeksText, err := menu.Ask("Weeks", "12", validateInt)
+	if err != nil {
+		return
+	}
+	weeks, _ := strconv.Atoi(weeksText)
+	if weeks <= 0 {
+		log.Errorln("the number of weeks must be positive")
+		return
+	}
+	invoices, err := loadInvoiceSet(strings.Fields(patterns))
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	currency, err := menu.Ask("Convert into currency (empty for none)", "", validateCurrency)
+	if err != nil {
+		return
+	}
+	if currency != "" {
+		rates, err := menu.Ask("Exchange-rate table", "rates.json", validateRequired)
+		if err != nil {
+			return
+		}
+		if invoices, err = inCurrency(invoices, rates, "EUR", currency); err != nil {
+			log.Errorln(err)
+			return
+		}
+	}
+	forecasts := forecastCashFlow(invoices, today(), weeks)
+	writeForecasts(os.Stdout, forecasts, "bars")
+	fmt.Println()
+	writeForecasts(os.Stdout, forecasts, "table")
+}
+
+// today returns the current date at midnight UTC, like parsed dates.
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func forecastCommand(args []string) error {
+	flags := flag.NewFlagSet("forecast", flag.ContinueOnError)
+	weeks := flags.Int("weeks", 12, "number of weeks")
+	asOf := flags.String("as-of", today().Format(dateFormat), "first day of the forecast")
+	format := flags.String("format", "table", "table or bars")
+	currency := flags.String("currency", "", "convert all amounts into this currency")
+	rates := flags.String("rates", "rates.json", "exchange-rate table for -currency, JSON or CSV")
+	base := flags.String("base", "EUR", "base currency of CSV rate tables")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 || *weeks <= 0 {
+		return errUsage
+	}
+	if *format != "table" && *format != "bars" {
+		return fmt.Errorf("unknown forecast format %q", *format)
+	}
+	start, err := time.Parse(dateFormat, *asOf)
+	if err != nil {
+		return err
+	}
+	invoices, err := loadInvoiceSet(flags.Args())
+	if err != nil {
+		return err
+	}
+	if *currency != "" {
+		if invoices, err = inCurrency(invoices, *rates, *base, *currency); err != nil {
+			return err
+		}
+	}
+	return writeForecasts(os.Stdout, forecastCashFlow(invoices, start, *weeks), *format)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForecastPerCurrency(t *testing.T) {
+	asOf := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	euros, pounds, plain := testInvoice(1, ""), testInvoice(2, ""), testInvoice(3, "")
+	euros.Currency, pounds.Currency = "EUR", "GBP"
+	forecasts := forecastCashFlow([]*Invoice{pounds, plain, euros}, asOf, 8)
+	if len(forecasts) != 3 {
+		t.Fatalf("got %d forecasts, want one per currency", len(forecasts))
+	}
+	for i, currency := range []string{"", "EUR", "GBP"} {
+		forecast := forecasts[i]
+		var expected float64
+		for _, week := range append(forecast.Weeks, forecast.Later) {
+			expected += week.Expected
+		}
+		if forecast.Currency != currency || expected != 10 {
+			t.Errorf("forecast %d: %q expects %.2f, want %q expecting 10.00", i, forecast.Currency, expected, currency)
+		}
+	}
+}
+
+func TestWriteForecasts(t *testing.T) {
+	asOf := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	invoice := testInvoice(1, "")
+	invoice.Currency = "GBP"
+	forecasts := forecastCashFlow([]*Invoice{invoice}, asOf, 4)
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "Expected receivables in GBP as of 2020-01-01"},
+		{"bars", "'#' expected"},
+		{"chart", `unknown forecast format "chart"`},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := writeForecasts(&out, forecasts, test.format)
+		got := out.String()
+		if err != nil {
+			got = err.Error()
+		}
+		if !strings.Contains(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestForecastCommandRejectsUnknownFormat(t *testing.T) {
+	err := forecastCommand([]string{"-format", "chart", "invoices.json"})
+	if err == nil || !strings.Contains(err.Error(), "unknown forecast format") {
+		t.Errorf("got %v, want an unknown format error", err)
+	}
+}
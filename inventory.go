@@ -0,0 +1,210 @@
+/**
+ * The inventory command: stock and reservations of listing variations,
+ * see listing.Inventory.
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/icodebb/go-play-ground/listing"
+	log "github.com/sirupsen/logrus"
+)
+
+func printReservation(verb string, reservation listing.Reservation) {
+	fmt.Printf("%s %s: %d x %s, until %s\n", verb, reservation.Id, reservation.Quantity, reservation.SKU,
+		reservation.Expires.Format(time.RFC3339))
+}
+
+// stressInventory lets buyers reserve units of one SKU at once, and commit
+// or release them, and checks that no more were sold than were in stock.
+func stressInventory(inventory *listing.Inventory, sku string, buyers int, seed int64) error {
+	start, _ := inventory.Stock(sku)
+	var sold, refused, released int64
+	var wg sync.WaitGroup
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(random *rand.Rand) {
+			defer wg.Done()
+			quantity := 1 + random.Intn(3)
+			reservation, err := inventory.Reserve(sku, quantity)
+			var outOfStock *listing.OutOfStockError
+			if errors.As(err, &outOfStock) {
+				atomic.AddInt64(&refused, 1)
+				return
+			} else if err != nil {
+				log.Errorln(err)
+				return
+			}
+			time.Sleep(time.Duration(random.Intn(1000)) * time.Microsecond)
+			if random.Intn(4) == 0 {
+				if _, err = inventory.Release(reservation.Id); err == nil {
+					atomic.AddInt64(&released, 1)
+				}
+			} else if _, err = inventory.Commit(reservation.Id); err == nil {
+				atomic.AddInt64(&sold, int64(quantity))
+			}
+			if err != nil {
+				log.Errorln(err)
+			}
+		}(rand.New(rand.NewSource(seed + int64(i))))
+	}
+	wg.Wait()
+	left, available := inventory.Stock(sku)
+	fmt.Printf("%d buyers: %d of %d units sold, %d left, %d released, %d refused\n",
+		buyers, sold, start, left, released, refused)
+	if left < 0 || available != left || int64(start-left) != sold {
+		return fmt.Errorf("%s: stock %d, %d left, %d available after selling %d", sku, start, left, available, sold)
+	}
+	return nil
+}
+
+// loadInventory takes the stock of the listings of files into the
+// inventory. Variations get a SKU first, which is written back to the
+// listing files.
+func loadInventory(inventory *listing.Inventory, name string, patterns []string) error {
+	filenames, err := expandGlobs(patterns)
+	if err != nil {
+		return err
+	}
+	byFile := make([][]*listing.Listing, len(filenames))
+	repairs := make([][]listing.Repair, len(filenames))
+	unassigned := make([]bool, len(filenames))
+	var listings []*listing.Listing
+	for i, filename := range filenames {
+		if byFile[i], repairs[i], err = readListingFile(filename); err != nil {
+			return err
+		}
+		for _, l := range byFile[i] {
+			for _, variation := range l.Variations {
+				unassigned[i] = unassigned[i] || variation.SKU == ""
+			}
+		}
+		listings = append(listings, byFile[i]...)
+	}
+	assigned, err := listing.AssignSKUs(listings)
+	if err != nil {
+		return err
+	}
+	for i, filename := range filenames {
+		if unassigned[i] {
+			if err = updateListingFile(filename, byFile[i], repairs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if assigned > 0 {
+		log.Infof("%d variations given a SKU", assigned)
+	}
+	added, err := inventory.Load(listings)
+	if err != nil {
+		return err
+	}
+	log.Infof("%s: %d variations added", name, added)
+	return nil
+}
+
+// inventoryCommand runs "load", "update", "show", "reserve", "commit",
+// "release", "expire" and "stress" on an inventory file.
+func inventoryCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	flags := flag.NewFlagSet("inventory "+args[0], flag.ContinueOnError)
+	hold := flags.Duration("hold", listing.DefaultHold, "how long reservations last")
+	buyers := flags.Int("buyers", 1000, "concurrent buyers of the stress test")
+	stock := flags.Int("stock", 500, "units in stock for the stress test")
+	seed := flags.Int64("seed", time.Now().UnixNano(), "random seed of the stress test")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if args[0] == "stress" {
+		if flags.NArg() > 1 || *buyers < 1 || *stock < 0 {
+			return errUsage
+		}
+		inventory := listing.NewInventory()
+		if flags.NArg() == 1 {
+			var err error
+			if inventory, err = listing.OpenInventory(flags.Arg(0)); err != nil {
+				return err
+			}
+			defer inventory.Close()
+		}
+		inventory.Hold = *hold
+		const sku = "stress#1"
+		if err := inventory.SetStock(sku, *stock); err != nil {
+			return err
+		}
+		return stressInventory(inventory, sku, *buyers, *seed)
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	inventory, err := listing.OpenInventory(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer inventory.Close()
+	inventory.Hold = *hold
+	rest := flags.Args()[1:]
+	switch {
+	case args[0] == "load" && len(rest) > 0:
+		return loadInventory(inventory, flags.Arg(0), rest)
+	case args[0] == "update" && len(rest) == 1:
+		listings, repairs, err := readListingFile(rest[0])
+		if err != nil {
+			return err
+		}
+		inventory.Update(listings)
+		return updateListingFile(rest[0], listings, repairs)
+	case args[0] == "show" && len(rest) == 0:
+		for _, sku := range inventory.SKUs() {
+			units, available := inventory.Stock(sku)
+			fmt.Printf("%-40s %6d in stock %6d available\n", sku, units, available)
+		}
+		for _, reservation := range inventory.Reservations() {
+			printReservation("held", reservation)
+		}
+	case args[0] == "reserve" && len(rest) == 2:
+		quantity, err := strconv.Atoi(rest[1])
+		if err != nil {
+			return errUsage
+		}
+		reservation, err := inventory.Reserve(rest[0], quantity)
+		if err != nil {
+			return err
+		}
+		printReservation("reserved", reservation)
+	case args[0] == "commit" && len(rest) == 1:
+		reservation, err := inventory.Commit(rest[0])
+		if err != nil {
+			return err
+		}
+		printReservation("sold", reservation)
+	case args[0] == "release" && len(rest) == 1:
+		reservation, err := inventory.Release(rest[0])
+		if err != nil {
+			return err
+		}
+		printReservation("released", reservation)
+	case args[0] == "expire" && len(rest) == 0:
+		dropped, err := inventory.Expire()
+		if err != nil {
+			return err
+		}
+		for _, reservation := range dropped {
+			printReservation("expired", reservation)
+		}
+	default:
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+/**
+ * Invoice helpers shared by the invoice features.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// paidTolerance absorbs float rounding when comparing amounts of money.
+const paidTolerance = 0.005
+
+// Total returns the sum of price times quantity over the items.
+func (invoice *Invoice) Total() float64 {
+	total := 0.0
+	for _, item := range invoice.Items {
+		total += item.Price * float64(item.Quantity)
+	}
+	return total
+}
+
+// AmountPaid returns the sum of the recorded payments.
+func (invoice *Invoice) AmountPaid() float64 {
+	paid := 0.0
+	for _, payment := range invoice.Payments {
+		paid += payment.Amount
+	}
+	return paid
+}
+
+// Charges returns the dunning fees and interest charged on the invoice.
+func (invoice *Invoice) Charges() float64 {
+	charges := 0.0
+	for _, record := range invoice.Dunning {
+		charges += record.Fee + record.Interest
+	}
+	return charges
+}
+
+// Balance returns what is still owed, dunning charges included. Invoices
+// marked as paid without any recorded payments owe nothing.
+func (invoice *Invoice) Balance() float64 {
+	if invoice.Paid && len(invoice.Payments) == 0 {
+		return 0
+	}
+	return math.Max(0, invoice.Total()+invoice.Charges()-invoice.AmountPaid())
+}
+
+// settled reports whether the recorded payments cover the invoice and its
+// charges.
+func (invoice *Invoice) settled() bool {
+	return len(invoice.Payments) > 0 && invoice.Total()+invoice.Charges()-invoice.AmountPaid() < paidTolerance
+}
+
+// addPayment records a payment and marks the invoice paid once the balance
+// is settled.
+func (invoice *Invoice) addPayment(payment *PaymentRecord) {
+	invoice.Payments = append(invoice.Payments, payment)
+	if invoice.settled() {
+		invoice.Paid = true
+	}
+}
+
+// clone returns a deep copy of the invoice.
+func (invoice *Invoice) clone() *Invoice {
+	copied := *invoice
+	if invoice.Items != nil {
+		copied.Items = make([]*Item, len(invoice.Items))
+		for i, item := range invoice.Items {
+			itemCopy := *item
+			copied.Items[i] = &itemCopy
+		}
+	}
+	if invoice.Payments != nil {
+		copied.Payments = make([]*PaymentRecord, len(invoice.Payments))
+		for i, payment := range invoice.Payments {
+			paymentCopy := *payment
+			copied.Payments[i] = &paymentCopy
+		}
+	}
+	if invoice.Dunning != nil {
+		copied.Dunning = make([]*DunningRecord, len(invoice.Dunning))
+		for i, record := range invoice.Dunning {
+			recordCopy := *record
+			copied.Dunning[i] = &recordCopy
+		}
+	}
+	return &copied
+}
+
+// cloneInvoices returns deep copies of the invoices.
+func cloneInvoices(invoices []*Invoice) []*Invoice {
+	copies := make([]*Invoice, len(invoices))
+	for i, invoice := range invoices {
+		copies[i] = invoice.clone()
+	}
+	return copies
+}
+
+// invoiceIndex maps invoices by Id.
+func invoiceIndex(invoices []*Invoice) map[int]*Invoice {
+	index := make(map[int]*Invoice, len(invoices))
+	for _, invoice := range invoices {
+		index[invoice.Id] = invoice
+	}
+	return index
+}
+
+// validate checks the invariants every stored invoice must keep.
+func (invoice *Invoice) validate() error {
+	if invoice.Id <= 0 {
+		return errors.New("invoice Id must be positive")
+	}
+	if invoice.Raised.IsZero() || invoice.Due.IsZero() {
+		return errors.New("raised and due dates are required")
+	}
+	if invoice.Due.Before(invoice.Raised) {
+		return errors.New("due date is before the raised date")
+	}
+	if err := validateCurrency(invoice.Currency); err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	for _, item := range invoice.Items {
+		switch {
+		case item == nil:
+			return errors.New("items must not be null")
+		case item.Id == "":
+			return errors.New("item Id is required")
+		case seen[item.Id]:
+			return fmt.Errorf("item %s appears twice", item.Id)
+		case item.Price < 0:
+			return fmt.Errorf("item %s: price must not be negative", item.Id)
+		case item.Quantity <= 0:
+			return fmt.Errorf("item %s: quantity must be positive", item.Id)
+		}
+		seen[item.Id] = true
+	}
+	for _, payment := range invoice.Payments {
+		if payment == nil {
+			return errors.New("payments must not be null")
+		}
+	}
+	for _, record := range invoice.Dunning {
+		if record == nil {
+			return errors.New("dunning records must not be null")
+		}
+	}
+	return nil
+}
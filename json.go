@@ -5,6 +5,7 @@
 package main
 
 import (
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/icodebb/go-play-ground/listing"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -33,7 +35,10 @@ type Invoice struct {
 	Due        time.Time
 	Paid       bool
 	Note       string
+	Currency   string // ISO 4217 code such as "GBP", see currency.go
 	Items      []*Item
+	Payments   []*PaymentRecord
+	Dunning    []*DunningRecord
 }
 
 type Item struct {
@@ -43,6 +48,19 @@ type Item struct {
 	Note     string
 }
 
+type PaymentRecord struct {
+	Date      time.Time
+	Amount    float64
+	Reference string
+}
+
+type DunningRecord struct {
+	Level    string
+	Date     time.Time
+	Fee      float64
+	Interest float64
+}
+
 type JSONInvoice struct {
 	Id         int
 	CustomerId int
@@ -50,7 +68,23 @@ type JSONInvoice struct {
 	Due        string // time.Time in Invoice struct
 	Paid       bool
 	Note       string
+	Currency   string `json:",omitempty"`
 	Items      []*Item
+	Payments   []*PaymentRecord `json:",omitempty"`
+	Dunning    []*DunningRecord `json:",omitempty"`
+}
+
+type JSONPaymentRecord struct {
+	Date      string // time.Time in PaymentRecord struct
+	Amount    float64
+	Reference string
+}
+
+type JSONDunningRecord struct {
+	Level    string
+	Date     string // time.Time in DunningRecord struct
+	Fee      float64
+	Interest float64
 }
 
 type UMIQ struct {
@@ -107,26 +141,90 @@ func TestJson0() {
 	fmt.Println(string(jsonData2))
 }
 
-func openInvoiceFile(filename string) (io.ReadCloser, func(), error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, nil, err
+// openInvoiceFile opens filename, or the member of an archive for paths
+// like "archive.tar.gz#member.json", and undoes its encryption and
+// compression.
+func openInvoiceFile(filename string, passphrase []byte) (io.Reader, func(), error) {
+	var source io.Reader
+	var closeSource func()
+	if archive, member, ok := splitArchivePath(filename); ok {
+		reader, closer, err := openArchiveMember(archive, member)
+		if err != nil {
+			return nil, closer, err
+		}
+		source, closeSource = reader, closer
+	} else {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, nil, err
+		}
+		source, closeSource = file, func() { file.Close() }
+	}
+	return unwrapInvoiceFile(source, closeSource, filename, passphrase)
+}
+
+// unwrapInvoiceFile undoes the encryption and compression of the content
+// of filename read from source.
+func unwrapInvoiceFile(source io.Reader, closeSource func(), filename string, passphrase []byte) (io.Reader, func(), error) {
+	closer := closeSource
+	reader := source
+	var err error
+	if strings.HasSuffix(filename, encryptedSuffix) {
+		if reader, err = newDecryptReader(source, passphrase); err != nil {
+			return source, closer, fmt.Errorf("%s: %w", filename, err)
+		}
+		filename = strings.TrimSuffix(filename, encryptedSuffix)
 	}
-	closer := func() { file.Close() }
-	var reader io.ReadCloser = file
 	var decompressor *gzip.Reader
 	if strings.HasSuffix(filename, ".gz") {
-		if decompressor, err = gzip.NewReader(file); err != nil {
-			return file, closer, err
+		if decompressor, err = gzip.NewReader(reader); err != nil {
+			return source, closer, err
 		}
-		closer = func() { decompressor.Close(); file.Close() }
+		closer = func() { decompressor.Close(); closeSource() }
 		reader = decompressor
 	}
 	return reader, closer, nil
 }
 
-func readInvoiceFile(filename string) ([]*Invoice, error) {
-	file, closer, err := openInvoiceFile(filename)
+// readOptions holds the optional checks readInvoiceFile applies.
+type readOptions struct {
+	verifier   InvoiceVerifier
+	passphrase []byte
+}
+
+// readOption changes how readInvoiceFile reads a file.
+type readOption func(*readOptions)
+
+// requireSignature makes readInvoiceFile refuse files whose signature
+// sidecar is missing or does not verify with verifier.
+func requireSignature(verifier InvoiceVerifier) readOption {
+	return func(o *readOptions) { o.verifier = verifier }
+}
+
+// decryptWith gives the passphrase for files ending in ".enc".
+func decryptWith(passphrase []byte) readOption {
+	return func(o *readOptions) { o.passphrase = passphrase }
+}
+
+func readInvoiceFile(filename string, options ...readOption) ([]*Invoice, error) {
+	var opts readOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	var file io.Reader
+	var closer func()
+	var err error
+	if opts.verifier != nil {
+		// What is decoded is the very bytes verified, not the file read
+		// again.
+		var data []byte
+		if data, err = readVerifiedFile(filename, opts.verifier); err != nil {
+			return nil, err
+		}
+		file, closer, err = unwrapInvoiceFile(bytes.NewReader(data), func() {}, filename, opts.passphrase)
+	} else {
+		file, closer, err = openInvoiceFile(filename, opts.passphrase)
+	}
 	if closer != nil {
 		defer closer()
 	}
@@ -148,6 +246,103 @@ func readInvoices(reader io.Reader, suffix string) ([]*Invoice, error) {
 	return nil, fmt.Errorf("unrecognized input suffix: %s", suffix)
 }
 
+// writeOptions holds the optional settings writeInvoiceFile applies.
+type writeOptions struct {
+	passphrase []byte
+}
+
+// writeOption changes how writeInvoiceFile writes a file.
+type writeOption func(*writeOptions)
+
+// encryptWith gives the passphrase for files ending in ".enc".
+func encryptWith(passphrase []byte) writeOption {
+	return func(o *writeOptions) { o.passphrase = passphrase }
+}
+
+// createInvoiceFile returns a writer for filename. The closer flushes and
+// closes the layers in order and must be checked, since the compressor and
+// encrypter only write their last block there.
+func createInvoiceFile(filename string, passphrase []byte) (io.Writer, func() error, error) {
+	// Checked first, since creating the file truncates it.
+	encrypted := strings.HasSuffix(filename, encryptedSuffix)
+	if encrypted && len(passphrase) == 0 {
+		return nil, nil, ErrNoPassphrase
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	closers := []func() error{file.Close}
+	closer := func() error {
+		var err error
+		for i := len(closers) - 1; i >= 0; i-- {
+			if cerr := closers[i](); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+	var writer io.Writer = file
+	if encrypted {
+		encrypter, err := newEncryptWriter(file, passphrase)
+		if err != nil {
+			return nil, closer, err
+		}
+		closers = append(closers, encrypter.Close)
+		writer = encrypter
+		filename = strings.TrimSuffix(filename, encryptedSuffix)
+	}
+	if strings.HasSuffix(filename, ".gz") {
+		compressor := gzip.NewWriter(writer)
+		closers = append(closers, compressor.Close)
+		writer = compressor
+	}
+	return writer, closer, nil
+}
+
+func writeInvoiceFile(filename string, invoices []*Invoice, options ...writeOption) (err error) {
+	var opts writeOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	file, closer, err := createInvoiceFile(filename, opts.passphrase)
+	if closer != nil {
+		defer func() {
+			if cerr := closer(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	if err != nil {
+		return err
+	}
+	return writeInvoices(file, suffixOf(filename), invoices)
+}
+
+// replaceInvoiceFile writes the invoices to a temporary file next to
+// filename and renames it over filename, so readers never see half a file.
+func replaceInvoiceFile(filename string, invoices []*Invoice, options ...writeOption) error {
+	// The temporary file keeps the suffix, so it is written the same way.
+	temp := filepath.Join(filepath.Dir(filename), ".replace-"+filepath.Base(filename))
+	if err := writeInvoiceFile(temp, invoices, options...); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, filename)
+}
+
+func writeInvoices(writer io.Writer, suffix string, invoices []*Invoice) error {
+	var marshaler InvoicesMarshaler
+	switch suffix {
+	case ".jsn", ".json":
+		marshaler = JSONMarshaler{}
+	}
+	if marshaler != nil {
+		return marshaler.MarshalInvoices(writer, invoices)
+	}
+	return fmt.Errorf("unrecognized output suffix: %s", suffix)
+}
+
 func (invoice Invoice) MarshalJSON() ([]byte, error) {
 	jsonInvoice := JSONInvoice{
 		invoice.Id,
@@ -156,11 +351,73 @@ func (invoice Invoice) MarshalJSON() ([]byte, error) {
 		invoice.Due.Format(dateFormat),
 		invoice.Paid,
 		invoice.Note,
+		invoice.Currency,
 		invoice.Items,
+		invoice.Payments,
+		invoice.Dunning,
 	}
 	return json.Marshal(jsonInvoice)
 }
 
+func (invoice *Invoice) UnmarshalJSON(data []byte) (err error) {
+	var jsonInvoice JSONInvoice
+	if err = json.Unmarshal(data, &jsonInvoice); err != nil {
+		return err
+	}
+	var raised, due time.Time
+	if raised, err = time.Parse(dateFormat, jsonInvoice.Raised); err != nil {
+		return err
+	}
+	if due, err = time.Parse(dateFormat, jsonInvoice.Due); err != nil {
+		return err
+	}
+	*invoice = Invoice{
+		jsonInvoice.Id,
+		jsonInvoice.CustomerId,
+		raised,
+		due,
+		jsonInvoice.Paid,
+		jsonInvoice.Note,
+		jsonInvoice.Currency,
+		jsonInvoice.Items,
+		jsonInvoice.Payments,
+		jsonInvoice.Dunning,
+	}
+	return nil
+}
+
+func (payment PaymentRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(JSONPaymentRecord{
+		payment.Date.Format(dateFormat),
+		payment.Amount,
+		payment.Reference,
+	})
+}
+
+func (payment *PaymentRecord) UnmarshalJSON(data []byte) error {
+	var jsonPayment JSONPaymentRecord
+	if err := json.Unmarshal(data, &jsonPayment); err != nil {
+		return err
+	}
+	date, err := time.Parse(dateFormat, jsonPayment.Date)
+	if err != nil {
+		return err
+	}
+	*payment = PaymentRecord{date, jsonPayment.Amount, jsonPayment.Reference}
+	return nil
+}
+
+func (JSONMarshaler) MarshalInvoices(writer io.Writer, invoices []*Invoice) error {
+	encoder := json.NewEncoder(writer)
+	if err := encoder.Encode(fileType); err != nil {
+		return err
+	}
+	if err := encoder.Encode(fileVersion); err != nil {
+		return err
+	}
+	return encoder.Encode(invoices)
+}
+
 func (JSONMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice, error) {
 	decoder := json.NewDecoder(reader)
 	var kind string
@@ -177,12 +434,38 @@ func (JSONMarshaler) UnmarshalInvoices(reader io.Reader) ([]*Invoice, error) {
 	if version > fileVersion {
 		return nil, fmt.Errorf("version %d is too new to read", version)
 	}
+	if version < fileVersion {
+		return decodeMigrated(decoder, version)
+	}
 	var invoices []*Invoice
 	err := decoder.Decode(&invoices)
 	return invoices, err
 }
 
+func (record DunningRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(JSONDunningRecord{
+		record.Level,
+		record.Date.Format(dateFormat),
+		record.Fee,
+		record.Interest,
+	})
+}
+
+func (record *DunningRecord) UnmarshalJSON(data []byte) error {
+	var jsonRecord JSONDunningRecord
+	if err := json.Unmarshal(data, &jsonRecord); err != nil {
+		return err
+	}
+	date, err := time.Parse(dateFormat, jsonRecord.Date)
+	if err != nil {
+		return err
+	}
+	*record = DunningRecord{jsonRecord.Level, date, jsonRecord.Fee, jsonRecord.Interest}
+	return nil
+}
+
 func suffixOf(filename string) string {
+	filename = strings.TrimSuffix(filename, encryptedSuffix)
 	suffix := filepath.Ext(filename)
 	if suffix == ".gz" {
 		suffix = filepath.Ext(filename[:len(filename)-3])
@@ -204,68 +487,6 @@ func TestJson1() {
 }
 
 // Following is from https://play.golang.org/p/w2ZcOzGHKR
-type LocationReadable struct {
-	District string
-	City     string
-	State    string
-}
-
-type Locale struct {
-	Location         string
-	CountryCode      string
-	CurrencyId       string
-	CurrencySymbol   string
-	LocationReadable LocationReadable
-}
-
-type Media struct {
-	Image []string
-	Video []string
-}
-
-type Variations struct {
-	FixedPrice float64
-	Media      Media
-	Quantity   int
-}
-
-type PaymentData struct {
-	PaymentName    string
-	PaymentService string
-}
-
-type Payment struct {
-	Online  []PaymentData
-	Offline []PaymentData
-}
-
-type Shipping struct {
-	ShippingService        string
-	ShippingName           string
-	ShippingCost           float64
-	HandlingTimeMax        int
-	DispatchTimeMin        int
-	DispatchTimeMax        int
-	ShippingAdditionalCost string
-}
-
-type Item2 struct {
-	_version    string
-	CategoryId  []string
-	Title       string
-	Media       Media
-	SellerId    string
-	Locale      Locale
-	ListingType string
-	Payment     Payment
-	StartTime   string
-	EndTime     string
-	Shipping    []Shipping
-	TitleSlug   string
-	Variations  []Variations
-	_fpaiStatus string
-}
-
 func TestJson2() {
 	itemInfoR := `
 {
@@ -403,21 +624,22 @@ func TestJson2() {
 }
 	`
 
-	itemInfoBytes := []byte(itemInfoR)
-	var ItemInfo Item2
-	er := json.Unmarshal(itemInfoBytes, &ItemInfo)
-	if er != nil {
-		panic(er)
-	} else {
-		/**
-		Pretty can only be used to JSON object.
-		prettyJSON, err := json.MarshalIndent(itemInfoR, "", "    ")
-		if err != nil {
-			fmt.Printf("%s\n", string(prettyJSON))
-		} else {
-			fmt.Printf("Failed to generate pretty JSON:%v", err)
-		}*/
-		fmt.Println(ItemInfo)
+	// The listing package decodes the keys exactly and keeps unknown ones.
+	// Importing also repairs the mojibake of the currency symbol.
+	listings, repairs, err := listing.Import(strings.NewReader(itemInfoR))
+	if err != nil {
+		panic(err)
+	}
+	for _, repair := range repairs {
+		fmt.Printf("repaired %s: %q -> %q\n", repair.Path, repair.Before, repair.After)
+	}
+	item := listings[0]
+	fmt.Printf("%s by %s, %s to %s\n", item.Title, item.SellerId,
+		item.StartTime.Format(dateFormat), item.EndTime.Format(dateFormat))
+	for _, variation := range item.Variations {
+		fmt.Printf("  %s: %d at %.2f\n", variation.Brand, variation.Quantity, variation.FixedPrice)
+	}
+	if err = listing.Encode(os.Stdout, listings); err != nil {
+		log.Errorln(err)
 	}
-
 }
@@ -0,0 +1,167 @@
+/**
+ * Category tree.
+ *
+ * CategoryId is the path of the category of a listing from the top, e.g.
+ * ["Root", "Cameras & Photo", "Digital Cameras"]. The tree of all the
+ * paths of a set of listings counts the listings in and under each
+ * category. Renaming or moving a category rewrites the paths of the
+ * listings under it.
+ */
+
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BreadcrumbSeparator joins the names of a category path.
+const BreadcrumbSeparator = " > "
+
+// Category is a node of the category tree.
+type Category struct {
+	Name     string
+	Path     []string
+	Parent   *Category
+	Children []*Category // by name
+	Own      int         // listings in the category itself
+	Count    int         // listings in the category and under it
+}
+
+// Breadcrumb joins the names of a path, e.g. "Root > Cameras & Photo".
+func Breadcrumb(path []string) string {
+	return strings.Join(path, BreadcrumbSeparator)
+}
+
+// Breadcrumb returns the path of the category; the top of the tree has
+// none.
+func (category *Category) Breadcrumb() string {
+	return Breadcrumb(category.Path)
+}
+
+// Child returns the child category with the name, or nil.
+func (category *Category) Child(name string) *Category {
+	i := sort.Search(len(category.Children), func(i int) bool { return category.Children[i].Name >= name })
+	if i < len(category.Children) && category.Children[i].Name == name {
+		return category.Children[i]
+	}
+	return nil
+}
+
+func (category *Category) add(name string) *Category {
+	if child := category.Child(name); child != nil {
+		return child
+	}
+	path := make([]string, len(category.Path)+1)
+	copy(path, category.Path)
+	path[len(path)-1] = name
+	child := &Category{Name: name, Path: path, Parent: category}
+	category.Children = append(category.Children, child)
+	sort.Slice(category.Children, func(i, j int) bool { return category.Children[i].Name < category.Children[j].Name })
+	return child
+}
+
+// Find returns the category of path under this one, or nil.
+func (category *Category) Find(path []string) *Category {
+	for _, name := range path {
+		if category = category.Child(name); category == nil {
+			return nil
+		}
+	}
+	return category
+}
+
+// Walk calls visit for the category and every category under it, parents
+// first, with the depth below this category.
+func (category *Category) Walk(visit func(category *Category, depth int)) {
+	var walk func(*Category, int)
+	walk = func(category *Category, depth int) {
+		visit(category, depth)
+		for _, child := range category.Children {
+			walk(child, depth+1)
+		}
+	}
+	walk(category, 0)
+}
+
+// Categories builds the tree of the categories of listings. The top of
+// the tree has no name and counts the listings without a category.
+func Categories(listings []*Listing) *Category {
+	top := &Category{}
+	for _, listing := range listings {
+		category := top
+		category.Count++
+		for _, name := range listing.CategoryId {
+			category = category.add(name)
+			category.Count++
+		}
+		category.Own++
+	}
+	return top
+}
+
+// InCategory tells whether the listing is in the category of path or
+// under it.
+func InCategory(listing *Listing, path []string) bool {
+	if len(listing.CategoryId) < len(path) {
+		return false
+	}
+	for i, name := range path {
+		if listing.CategoryId[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// Subtree returns the listings in the category of path or under it.
+func Subtree(listings []*Listing, path []string) []*Listing {
+	var found []*Listing
+	for _, listing := range listings {
+		if InCategory(listing, path) {
+			found = append(found, listing)
+		}
+	}
+	return found
+}
+
+// Move moves the category of path, with everything under it, to the path
+// to, e.g. to put it under another parent or rename it, and returns the
+// number of listings rewritten. A category of that path already there is
+// merged with the one moved.
+func Move(listings []*Listing, from, to []string) (int, error) {
+	switch {
+	case len(from) == 0 || len(to) == 0:
+		return 0, errors.New("the top of the category tree cannot be moved")
+	case len(to) > len(from) && InCategory(&Listing{CategoryId: to}, from):
+		return 0, fmt.Errorf("cannot move %s under itself", Breadcrumb(from))
+	}
+	for _, name := range to {
+		if strings.TrimSpace(name) == "" {
+			return 0, errors.New("category names cannot be empty")
+		}
+	}
+	moved := 0
+	for _, listing := range listings {
+		if !InCategory(listing, from) {
+			continue
+		}
+		path := make([]string, 0, len(to)+len(listing.CategoryId)-len(from))
+		path = append(path, to...)
+		listing.CategoryId = append(path, listing.CategoryId[len(from):]...)
+		moved++
+	}
+	return moved, nil
+}
+
+// Rename renames the category of path and returns the number of listings
+// rewritten.
+func Rename(listings []*Listing, path []string, name string) (int, error) {
+	if len(path) == 0 {
+		return 0, errors.New("the top of the category tree has no name")
+	}
+	to := append(append([]string{}, path[:len(path)-1]...), name)
+	return Move(listings, path, to)
+}
@@ -0,0 +1,171 @@
+package listing
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func categoryListings(paths ...string) []*Listing {
+	var listings []*Listing
+	for i, path := range paths {
+		listing := &Listing{Title: fmt.Sprint(i)}
+		if path != "" {
+			listing.CategoryId = strings.Split(path, "/")
+		}
+		listings = append(listings, listing)
+	}
+	return listings
+}
+
+func pathsOf(listings []*Listing) string {
+	var paths []string
+	for _, listing := range listings {
+		paths = append(paths, strings.Join(listing.CategoryId, "/"))
+	}
+	return strings.Join(paths, " ")
+}
+
+func TestCategories(t *testing.T) {
+	top := Categories(categoryListings(
+		"Root/Cameras/Digital",
+		"Root/Cameras/Digital",
+		"Root/Cameras",
+		"Root/Audio",
+		"",
+		"",
+		"Other",
+	))
+	tests := []struct {
+		path       string
+		own, count int
+		children   string
+	}{
+		{"", 2, 7, "Other Root"},
+		{"Root", 0, 4, "Audio Cameras"},
+		{"Root/Cameras", 1, 3, "Digital"},
+		{"Root/Cameras/Digital", 2, 2, ""},
+		{"Root/Audio", 1, 1, ""},
+		{"Other", 1, 1, ""},
+	}
+	for _, test := range tests {
+		var path []string
+		if test.path != "" {
+			path = strings.Split(test.path, "/")
+		}
+		category := top.Find(path)
+		if category == nil {
+			t.Errorf("%q: not found", test.path)
+			continue
+		}
+		var children []string
+		for _, child := range category.Children {
+			children = append(children, child.Name)
+		}
+		if category.Own != test.own || category.Count != test.count || strings.Join(children, " ") != test.children {
+			t.Errorf("%q: got own %d, count %d, children %v, want %d, %d, %s",
+				test.path, category.Own, category.Count, children, test.own, test.count, test.children)
+		}
+		if got := category.Breadcrumb(); got != strings.Replace(test.path, "/", BreadcrumbSeparator, -1) {
+			t.Errorf("%q: breadcrumb %q", test.path, got)
+		}
+	}
+	if top.Find([]string{"Root", "Phones"}) != nil {
+		t.Error("found a category without listings")
+	}
+}
+
+func TestMove(t *testing.T) {
+	listings := func() []*Listing {
+		return categoryListings("Root/Cameras/Digital", "Root/Cameras", "Root/Audio", "Root/Photo/Lenses", "")
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		moved    int
+		want     string
+		err      string
+	}{
+		{"to another parent", "Root/Cameras", "Root/Photo/Cameras", 2, "Root/Photo/Cameras/Digital Root/Photo/Cameras Root/Audio Root/Photo/Lenses ", ""},
+		{"merge into an existing path", "Root/Cameras", "Root/Photo", 2, "Root/Photo/Digital Root/Photo Root/Audio Root/Photo/Lenses ", ""},
+		{"up a level", "Root/Cameras/Digital", "Digital", 1, "Digital Root/Cameras Root/Audio Root/Photo/Lenses ", ""},
+		{"onto itself", "Root/Audio", "Root/Audio", 1, "Root/Cameras/Digital Root/Cameras Root/Audio Root/Photo/Lenses ", ""},
+		{"nothing there", "Root/Phones", "Root/Mobile", 0, "Root/Cameras/Digital Root/Cameras Root/Audio Root/Photo/Lenses ", ""},
+		{"into itself", "Root/Cameras", "Root/Cameras/Old", 0, "", "under itself"},
+		{"the top", "", "Root", 0, "", "top of the category tree"},
+		{"to the top", "Root", "", 0, "", "top of the category tree"},
+		{"empty name", "Root/Audio", "Root/ ", 0, "", "cannot be empty"},
+	}
+	for _, test := range tests {
+		var from, to []string
+		if test.from != "" {
+			from = strings.Split(test.from, "/")
+		}
+		if test.to != "" {
+			to = strings.Split(test.to, "/")
+		}
+		l := listings()
+		moved, err := Move(l, from, to)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want an error with %q", test.name, err, test.err)
+			}
+			if pathsOf(l) != pathsOf(listings()) {
+				t.Errorf("%s: the failed move changed %s", test.name, pathsOf(l))
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case moved != test.moved || pathsOf(l) != test.want:
+			t.Errorf("%s: moved %d to %q, want %d to %q", test.name, moved, pathsOf(l), test.moved, test.want)
+		}
+	}
+}
+
+func TestMergedCategoriesCount(t *testing.T) {
+	l := categoryListings("Root/Cameras/Digital", "Root/Cameras", "Root/Photo", "Root/Photo/Digital")
+	if _, err := Move(l, []string{"Root", "Cameras"}, []string{"Root", "Photo"}); err != nil {
+		t.Fatal(err)
+	}
+	top := Categories(l)
+	photo, digital := top.Find([]string{"Root", "Photo"}), top.Find([]string{"Root", "Photo", "Digital"})
+	if top.Find([]string{"Root", "Cameras"}) != nil || photo.Own != 2 || photo.Count != 4 || digital.Count != 2 {
+		t.Errorf("got Photo %d/%d and Digital %d after the merge, want 2/4 and 2", photo.Own, photo.Count, digital.Count)
+	}
+}
+
+func TestRename(t *testing.T) {
+	tests := []struct {
+		name  string
+		path  string
+		to    string
+		moved int
+		want  string
+		err   string
+	}{
+		{"leaf", "Root/Cameras/Digital", "Compact", 1, "Root/Cameras/Compact Root/Cameras Root/Audio", ""},
+		{"with children", "Root/Cameras", "Photo", 2, "Root/Photo/Digital Root/Photo Root/Audio", ""},
+		{"onto a sibling", "Root/Audio", "Cameras", 1, "Root/Cameras/Digital Root/Cameras Root/Cameras", ""},
+		{"top level", "Root", "All", 3, "All/Cameras/Digital All/Cameras All/Audio", ""},
+		{"the top", "", "All", 0, "", "has no name"},
+		{"empty name", "Root/Audio", "", 0, "", "cannot be empty"},
+	}
+	for _, test := range tests {
+		var path []string
+		if test.path != "" {
+			path = strings.Split(test.path, "/")
+		}
+		l := categoryListings("Root/Cameras/Digital", "Root/Cameras", "Root/Audio")
+		moved, err := Rename(l, path, test.to)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want an error with %q", test.name, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case moved != test.moved || pathsOf(l) != test.want:
+			t.Errorf("%s: moved %d to %q, want %d to %q", test.name, moved, pathsOf(l), test.moved, test.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+/**
+ * Faceted search over listings.
+ *
+ * A Catalog indexes the words of listing titles. A Query matches the
+ * listings having every word of its text, the last one as a prefix since
+ * it may still be typed, and one of the chosen values of every facet. The
+ * counts of a facet are those of the listings matching the query without
+ * the choice for that facet, so picking a brand still shows how many
+ * listings the other brands have.
+ */
+
+package listing
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The facets.
+const (
+	FacetBrand    = "brand"
+	FacetCategory = "category"
+	FacetType     = "type"
+	FacetCurrency = "currency"
+	FacetPrice    = "price"
+)
+
+// Facets returns the facets in the order they are shown.
+func Facets() []string {
+	return []string{FacetCategory, FacetBrand, FacetType, FacetCurrency, FacetPrice}
+}
+
+// priceBuckets are the lower ends of the price buckets.
+var priceBuckets = []float64{0, 10, 25, 50, 100, 250, 500, 1000}
+
+// PriceBucket returns the bucket of a price, e.g. "25-50" or "1000+".
+func PriceBucket(price float64) string {
+	i := sort.SearchFloat64s(priceBuckets, price)
+	if i == len(priceBuckets) || priceBuckets[i] > price {
+		i--
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i == len(priceBuckets)-1 {
+		return fmt.Sprintf("%g+", priceBuckets[i])
+	}
+	return fmt.Sprintf("%g-%g", priceBuckets[i], priceBuckets[i+1])
+}
+
+// bucketStart returns the lower end of a price bucket.
+func bucketStart(bucket string) float64 {
+	start, _ := strconv.ParseFloat(strings.TrimRight(strings.SplitN(bucket, "-", 2)[0], "+"), 64)
+	return start
+}
+
+// Sort orders.
+const (
+	SortRelevance = "relevance"
+	SortPrice     = "price"  // lowest price first
+	SortPriceDesc = "-price" // highest price first
+	SortTitle     = "title"
+	SortNewest    = "newest" // latest StartTime first
+	SortEnding    = "ending" // earliest EndTime first
+)
+
+// Listings without variations have no price, and those without an
+// EndTime do not end; they come last in the orders by price and ending.
+
+// Query is a search of a Catalog. Page counts from 1; PerPage defaults to
+// 20.
+type Query struct {
+	Text    string
+	Filters map[string][]string // chosen values by facet
+	Sort    string
+	Page    int
+	PerPage int
+}
+
+// FacetCount is the number of listings with a value of a facet.
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// Result is a page of the listings matching a query.
+type Result struct {
+	Total    int
+	Page     int
+	Pages    int
+	Listings []*Listing
+	Facets   map[string][]FacetCount // counts by facet, see sortCounts
+}
+
+// entry is an indexed listing.
+type entry struct {
+	listing  *Listing
+	facets   map[string][]string
+	minPrice float64 // +Inf without variations
+}
+
+// Catalog is an index of listings.
+type Catalog struct {
+	entries  []entry
+	postings map[string][]int // word -> entries, in order
+	words    []string         // sorted, for prefix lookups
+}
+
+// words returns the lower case words of a text.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// facetValues returns the values of each facet of a listing. A listing
+// has its category and those above it, and the price buckets of its
+// variations.
+func facetValues(listing *Listing) (map[string][]string, float64) {
+	values := make(map[string][]string)
+	add := func(facet, value string) {
+		if value == "" {
+			return
+		}
+		for _, seen := range values[facet] {
+			if seen == value {
+				return
+			}
+		}
+		values[facet] = append(values[facet], value)
+	}
+	for i := range listing.CategoryId {
+		add(FacetCategory, Breadcrumb(listing.CategoryId[:i+1]))
+	}
+	add(FacetType, listing.ListingType)
+	add(FacetCurrency, listing.Locale.CurrencyId)
+	minPrice := math.Inf(1)
+	for _, variation := range listing.Variations {
+		add(FacetBrand, variation.Brand)
+		add(FacetPrice, PriceBucket(variation.FixedPrice))
+		minPrice = math.Min(minPrice, variation.FixedPrice)
+	}
+	return values, minPrice
+}
+
+// NewCatalog indexes the listings.
+func NewCatalog(listings []*Listing) *Catalog {
+	catalog := &Catalog{postings: make(map[string][]int)}
+	for i, listing := range listings {
+		facets, minPrice := facetValues(listing)
+		seen := make(map[string]bool)
+		for _, word := range words(listing.Title) {
+			if !seen[word] {
+				seen[word] = true
+				catalog.postings[word] = append(catalog.postings[word], i)
+			}
+		}
+		catalog.entries = append(catalog.entries, entry{listing, facets, minPrice})
+	}
+	for word := range catalog.postings {
+		catalog.words = append(catalog.words, word)
+	}
+	sort.Strings(catalog.words)
+	return catalog
+}
+
+// matchText returns the entries having every word of text, the last one
+// as a prefix, with the number of words matched exactly.
+func (catalog *Catalog) matchText(text string) map[int]int {
+	query := words(text)
+	matched := make(map[int]int)
+	if len(query) == 0 {
+		for i := range catalog.entries {
+			matched[i] = 0
+		}
+		return matched
+	}
+	for n, word := range query {
+		found := make(map[int]int) // entries with the word, 1 if exactly
+		for _, i := range catalog.postings[word] {
+			found[i] = 1
+		}
+		if n == len(query)-1 {
+			for k := sort.SearchStrings(catalog.words, word); k < len(catalog.words) && strings.HasPrefix(catalog.words[k], word); k++ {
+				for _, i := range catalog.postings[catalog.words[k]] {
+					if _, ok := found[i]; !ok {
+						found[i] = 0
+					}
+				}
+			}
+		}
+		next := make(map[int]int)
+		for i, exact := range found {
+			if score, ok := matched[i]; ok || n == 0 {
+				next[i] = score + exact
+			}
+		}
+		matched = next
+	}
+	return matched
+}
+
+// hasValue tells whether the entry has one of the values of a facet.
+func (e entry) hasValue(facet string, chosen []string) bool {
+	for _, value := range e.facets[facet] {
+		for _, want := range chosen {
+			if value == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// passes tells whether the entry matches the filters, but for the one of
+// facet skip.
+func (e entry) passes(filters map[string][]string, skip string) bool {
+	for facet, chosen := range filters {
+		if facet != skip && len(chosen) > 0 && !e.hasValue(facet, chosen) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortCounts orders the counts of a facet, most first, but price buckets
+// from the cheapest.
+func sortCounts(facet string, counts map[string]int) []FacetCount {
+	var sorted []FacetCount
+	for value, count := range counts {
+		sorted = append(sorted, FacetCount{value, count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if facet == FacetPrice {
+			return bucketStart(sorted[i].Value) < bucketStart(sorted[j].Value)
+		}
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return sorted
+}
+
+// Search runs the query.
+func (catalog *Catalog) Search(query Query) (Result, error) {
+	for facet := range query.Filters {
+		if !containsFacet(facet) {
+			return Result{}, fmt.Errorf("unknown facet %q", facet)
+		}
+	}
+	if query.PerPage <= 0 {
+		query.PerPage = 20
+	}
+	if query.Page <= 0 {
+		query.Page = 1
+	}
+	matched := catalog.matchText(query.Text)
+	counts := make(map[string]map[string]int)
+	for _, facet := range Facets() {
+		counts[facet] = make(map[string]int)
+	}
+	var hits []int
+	for i := range matched {
+		e := catalog.entries[i]
+		for _, facet := range Facets() {
+			if e.passes(query.Filters, facet) {
+				for _, value := range e.facets[facet] {
+					counts[facet][value]++
+				}
+			}
+		}
+		if e.passes(query.Filters, "") {
+			hits = append(hits, i)
+		}
+	}
+	if err := catalog.sort(hits, matched, query.Sort); err != nil {
+		return Result{}, err
+	}
+
+	result := Result{
+		Total:  len(hits),
+		Page:   query.Page,
+		Pages:  (len(hits) + query.PerPage - 1) / query.PerPage,
+		Facets: make(map[string][]FacetCount),
+	}
+	for facet, values := range counts {
+		result.Facets[facet] = sortCounts(facet, values)
+	}
+	for i := (query.Page - 1) * query.PerPage; i < len(hits) && i < query.Page*query.PerPage; i++ {
+		result.Listings = append(result.Listings, catalog.entries[hits[i]].listing)
+	}
+	return result, nil
+}
+
+func containsFacet(facet string) bool {
+	for _, known := range Facets() {
+		if facet == known {
+			return true
+		}
+	}
+	return false
+}
+
+// sort orders the hits; ties keep the order the listings were indexed in.
+func (catalog *Catalog) sort(hits []int, matched map[int]int, order string) error {
+	var less func(a, b entry) bool
+	switch order {
+	case "", SortRelevance:
+		less = func(a, b entry) bool { return false }
+	case SortPrice:
+		less = func(a, b entry) bool { return a.minPrice < b.minPrice }
+	case SortPriceDesc:
+		less = func(a, b entry) bool {
+			return !math.IsInf(a.minPrice, 1) && (math.IsInf(b.minPrice, 1) || a.minPrice > b.minPrice)
+		}
+	case SortTitle:
+		less = func(a, b entry) bool { return strings.ToLower(a.listing.Title) < strings.ToLower(b.listing.Title) }
+	case SortNewest:
+		less = func(a, b entry) bool { return a.listing.StartTime.After(b.listing.StartTime) }
+	case SortEnding:
+		less = func(a, b entry) bool {
+			ends, other := a.listing.EndTime, b.listing.EndTime
+			return !ends.IsZero() && (other.IsZero() || ends.Before(other))
+		}
+	default:
+		return fmt.Errorf("unknown sort order %q", order)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := catalog.entries[hits[i]], catalog.entries[hits[j]]
+		switch {
+		case less(a, b):
+			return true
+		case less(b, a):
+			return false
+		case order == "" || order == SortRelevance:
+			if matched[hits[i]] != matched[hits[j]] {
+				return matched[hits[i]] > matched[hits[j]]
+			}
+		}
+		return hits[i] < hits[j]
+	})
+	return nil
+}
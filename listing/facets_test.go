@@ -0,0 +1,107 @@
+package listing
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCatalog() *Catalog {
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	priced := func(brand string, prices ...float64) []*Variation {
+		var variations []*Variation
+		for _, price := range prices {
+			variations = append(variations, &Variation{FixedPrice: price, Brand: brand})
+		}
+		return variations
+	}
+	return NewCatalog([]*Listing{
+		{Title: "Red running shoe", CategoryId: []string{"Shoes", "Running"}, StartTime: day(1), EndTime: day(20),
+			Variations: priced("Acme", 60, 45)},
+		{Title: "Blue shoe", CategoryId: []string{"Shoes"}, StartTime: day(3),
+			Variations: priced("Zed", 20)},
+		{Title: "Shoe horn", CategoryId: []string{"Tools"}, StartTime: day(2), EndTime: day(10)},
+		{Title: "Running socks", CategoryId: []string{"Clothes"}, StartTime: day(4), EndTime: day(15),
+			Variations: priced("Acme", 5)},
+	})
+}
+
+func titles(listings []*Listing) string {
+	var titles []string
+	for _, listing := range listings {
+		titles = append(titles, listing.Title)
+	}
+	return strings.Join(titles, ", ")
+}
+
+func TestSearch(t *testing.T) {
+	catalog := testCatalog()
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"all", Query{}, "Red running shoe, Blue shoe, Shoe horn, Running socks"},
+		{"word", Query{Text: "shoe"}, "Red running shoe, Blue shoe, Shoe horn"},
+		{"prefix of the last word", Query{Text: "run"}, "Red running shoe, Running socks"},
+		{"exact words first", Query{Text: "running s"}, "Red running shoe, Running socks"},
+		{"every word", Query{Text: "blue shoe"}, "Blue shoe"},
+		{"brand", Query{Filters: map[string][]string{FacetBrand: {"Acme"}}}, "Red running shoe, Running socks"},
+		{"category and below", Query{Filters: map[string][]string{FacetCategory: {"Shoes"}}}, "Red running shoe, Blue shoe"},
+		{"price bucket", Query{Filters: map[string][]string{FacetPrice: {"0-10", "10-25"}}}, "Blue shoe, Running socks"},
+		{"price", Query{Sort: SortPrice}, "Running socks, Blue shoe, Red running shoe, Shoe horn"},
+		{"-price", Query{Sort: SortPriceDesc}, "Red running shoe, Blue shoe, Running socks, Shoe horn"},
+		{"title", Query{Sort: SortTitle}, "Blue shoe, Red running shoe, Running socks, Shoe horn"},
+		{"newest", Query{Sort: SortNewest}, "Running socks, Blue shoe, Shoe horn, Red running shoe"},
+		{"ending", Query{Sort: SortEnding}, "Shoe horn, Running socks, Red running shoe, Blue shoe"},
+		{"page", Query{Sort: SortTitle, Page: 2, PerPage: 3}, "Shoe horn"},
+		{"past the last page", Query{Page: 3, PerPage: 3}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := catalog.Search(test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := titles(result.Listings); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSearchFacetCounts(t *testing.T) {
+	result, err := testCatalog().Search(Query{Filters: map[string][]string{FacetBrand: {"Zed"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 || result.Pages != 1 {
+		t.Errorf("total %d in %d pages, want 1 in 1", result.Total, result.Pages)
+	}
+	// The brand counts leave out the brand filter, the others do not.
+	if got := fmt.Sprint(result.Facets[FacetBrand]); got != "[{Acme 2} {Zed 1}]" {
+		t.Errorf("brands %s", got)
+	}
+	if got := fmt.Sprint(result.Facets[FacetCategory]); got != "[{Shoes 1}]" {
+		t.Errorf("categories %s", got)
+	}
+}
+
+func TestSearchErrors(t *testing.T) {
+	catalog := testCatalog()
+	if _, err := catalog.Search(Query{Filters: map[string][]string{"colour": {"red"}}}); err == nil {
+		t.Error("unknown facet accepted")
+	}
+	if _, err := catalog.Search(Query{Sort: "cheapest"}); err == nil {
+		t.Error("unknown sort order accepted")
+	}
+}
+
+func TestPriceBucket(t *testing.T) {
+	for price, want := range map[float64]string{0: "0-10", 9.99: "0-10", 10: "10-25", 999: "500-1000", 1000: "1000+", 5000: "1000+"} {
+		if got := PriceBucket(price); got != want {
+			t.Errorf("%g: got %s, want %s", price, got, want)
+		}
+	}
+}
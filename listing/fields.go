@@ -0,0 +1,178 @@
+/**
+ * Strict decoding with unknown fields kept.
+ *
+ * encoding/json matches keys case-insensitively and drops keys it has no
+ * field for. The types of this package instead only take keys spelled
+ * exactly as their tags and keep every other key, raw, in an Extra map
+ * which is written back when encoding. They also note the keys of the
+ * fields the object did not have, which are left out again when encoding
+ * while the field is still zero.
+ */
+
+package listing
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Extra holds the members of a JSON object which have no field.
+type Extra map[string]json.RawMessage
+
+var knownKeys sync.Map // reflect.Type -> map[string]bool
+
+// keysOf returns the JSON keys of the fields of struct type t.
+func keysOf(t reflect.Type) map[string]bool {
+	if keys, ok := knownKeys.Load(t); ok {
+		return keys.(map[string]bool)
+	}
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch {
+		case field.Anonymous && field.Type.Kind() == reflect.Struct && name == "":
+			for key := range keysOf(field.Type) {
+				keys[key] = true
+			}
+			continue
+		case field.PkgPath != "" || name == "-":
+			continue // unexported or skipped
+		case name == "":
+			name = field.Name
+		}
+		keys[name] = true
+	}
+	knownKeys.Store(t, keys)
+	return keys
+}
+
+// decodeStrict decodes the object in data into v, a pointer to a struct
+// without UnmarshalJSON of its own, and returns the members it has no
+// field for and the keys of the fields it has no member for.
+func decodeStrict(data []byte, v interface{}) (Extra, []string, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, nil, err
+	}
+	keys := keysOf(reflect.TypeOf(v).Elem())
+	var extra Extra
+	for key, value := range members {
+		if !keys[key] {
+			if extra == nil {
+				extra = make(Extra)
+			}
+			extra[key] = value
+			delete(members, key)
+		}
+	}
+	var absent []string
+	for key := range keys {
+		if _, ok := members[key]; !ok {
+			absent = append(absent, key)
+		}
+	}
+	sort.Strings(absent)
+	known, err := json.Marshal(members)
+	if err != nil {
+		return nil, nil, err
+	}
+	return extra, absent, json.Unmarshal(known, v)
+}
+
+// isZeroField tells whether the field of struct value v with the JSON key
+// holds its zero value. Fields of embedded structs come after the others,
+// as encoding/json has them.
+func isZeroField(v reflect.Value, key string) (zero, found bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous || field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == key {
+			return v.Field(i).IsZero(), true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if field := t.Field(i); field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if zero, found = isZeroField(v.Field(i), key); found {
+				return zero, true
+			}
+		}
+	}
+	return false, false
+}
+
+// encodeWithExtra encodes v, a struct without MarshalJSON of its own, and
+// adds the members of extra, sorted by key. Fields win over extra members
+// of the same name. The fields of the absent keys are left out while they
+// are zero.
+func encodeWithExtra(v interface{}, extra Extra, absent []string) ([]byte, error) {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false) // "&" stays as it was
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	data := bytes.TrimSuffix(buffer.Bytes(), []byte("\n"))
+	omit := make(map[string]bool)
+	for _, key := range absent {
+		if zero, _ := isZeroField(reflect.ValueOf(v), key); zero {
+			omit[key] = true
+		}
+	}
+	if len(extra) == 0 && len(omit) == 0 {
+		return data, nil
+	}
+	var b bytes.Buffer
+	b.WriteByte('{')
+	empty := true
+	member := func(name []byte, value json.RawMessage) {
+		if !empty {
+			b.WriteByte(',')
+		}
+		empty = false
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.Token() // the opening brace
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		var value json.RawMessage
+		if err = decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+		if key := token.(string); !omit[key] {
+			name, _ := json.Marshal(key)
+			member(name, value)
+		}
+	}
+	keys := make([]string, 0, len(extra))
+	for key := range extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	fields := keysOf(reflect.TypeOf(v))
+	for _, key := range keys {
+		if !fields[key] {
+			name, _ := json.Marshal(key)
+			member(name, extra[key])
+		}
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
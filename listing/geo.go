@@ -0,0 +1,181 @@
+/**
+ * Listing locations and proximity search.
+ *
+ * Locale.Location holds "latitude,longitude" in degrees. GeoIndex puts
+ * listings in a grid of cells a degree wide, so a search only measures the
+ * listings of the cells the search circle touches. Distances are great
+ * circle distances by the haversine formula.
+ */
+
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// earthRadius is the mean radius of the earth in kilometres.
+const earthRadius = 6371.0088
+
+// kmPerDegree is the length of a degree of latitude.
+const kmPerDegree = earthRadius * math.Pi / 180
+
+// ErrNoLocation is returned for listings without a location.
+var ErrNoLocation = errors.New("no location")
+
+// Point is a position in degrees.
+type Point struct {
+	Lat, Lng float64
+}
+
+func (p Point) String() string {
+	return strconv.FormatFloat(p.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(p.Lng, 'f', -1, 64)
+}
+
+// ParsePoint parses "latitude,longitude".
+func ParsePoint(text string) (Point, error) {
+	if strings.TrimSpace(text) == "" {
+		return Point{}, ErrNoLocation
+	}
+	parts := strings.Split(text, ",")
+	if len(parts) != 2 {
+		return Point{}, fmt.Errorf("location %q is not latitude,longitude", text)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return Point{}, fmt.Errorf("location %q: latitude must be between -90 and 90", text)
+	}
+	lng, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || math.IsNaN(lng) || lng < -180 || lng > 180 {
+		return Point{}, fmt.Errorf("location %q: longitude must be between -180 and 180", text)
+	}
+	return Point{lat, lng}, nil
+}
+
+// Point returns the location of the listing.
+func (listing *Listing) Point() (Point, error) {
+	return ParsePoint(listing.Locale.Location)
+}
+
+// String joins the parts of the place which are set, e.g. "City of
+// Westminster, London, Greater London".
+func (location LocationReadable) String() string {
+	var parts []string
+	for _, part := range []string{location.District, location.City, location.State} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Distance returns the great circle distance between a and b in km.
+func Distance(a, b Point) float64 {
+	dLat := radians(b.Lat - a.Lat)
+	dLng := radians(b.Lng - a.Lng)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(radians(a.Lat))*math.Cos(radians(b.Lat))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+type cell struct {
+	lat, lng int // degrees rounded down
+}
+
+func cellOf(p Point) cell {
+	c := cell{int(math.Floor(p.Lat)), int(math.Floor(p.Lng))}
+	// The poles and the antimeridian belong to the cells below them.
+	if c.lat == 90 {
+		c.lat = 89
+	}
+	if c.lng == 180 {
+		c.lng = -180
+	}
+	return c
+}
+
+type located struct {
+	listing *Listing
+	point   Point
+}
+
+// GeoIndex finds listings near a point.
+type GeoIndex struct {
+	cells map[cell][]located
+	size  int
+}
+
+// NewGeoIndex indexes the listings with a valid location and returns the
+// errors of the others by index.
+func NewGeoIndex(listings []*Listing) (*GeoIndex, map[int]error) {
+	index := &GeoIndex{cells: make(map[cell][]located)}
+	errs := make(map[int]error)
+	for i, listing := range listings {
+		point, err := listing.Point()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		index.Add(listing, point)
+	}
+	return index, errs
+}
+
+// Add indexes listing at point.
+func (index *GeoIndex) Add(listing *Listing, point Point) {
+	c := cellOf(point)
+	index.cells[c] = append(index.cells[c], located{listing, point})
+	index.size++
+}
+
+// Len returns the number of listings indexed.
+func (index *GeoIndex) Len() int {
+	return index.size
+}
+
+// Hit is a listing found near a point.
+type Hit struct {
+	Listing  *Listing
+	Point    Point
+	Distance float64 // km
+}
+
+// Within returns the listings within km of center, nearest first.
+func (index *GeoIndex) Within(center Point, km float64) []Hit {
+	// The bounding box of the circle, see
+	// http://janmatuschek.de/LatitudeLongitudeBoundingCoordinates
+	latSpan := km / kmPerDegree
+	minLat := int(math.Floor(math.Max(-90, center.Lat-latSpan)))
+	maxLat := cellOf(Point{math.Min(90, center.Lat+latSpan), 0}).lat
+	firstLng, lngCells := -180, 360
+	// Around a pole, or for large radii, every longitude is searched.
+	if x := math.Sin(km/earthRadius) / math.Cos(radians(center.Lat)); x < 1 && km/earthRadius < math.Pi/2 {
+		lngSpan := math.Asin(x) * 180 / math.Pi
+		firstLng = int(math.Floor(center.Lng - lngSpan))
+		if n := int(math.Floor(center.Lng+lngSpan)) - firstLng + 1; n < 360 {
+			lngCells = n
+		}
+	}
+	var hits []Hit
+	for lat := minLat; lat <= maxLat; lat++ {
+		for i := 0; i < lngCells; i++ {
+			// Wrap around the antimeridian into -180..179.
+			lng := ((firstLng+i)%360+540)%360 - 180
+			for _, entry := range index.cells[cell{lat, lng}] {
+				if d := Distance(center, entry.point); d <= km {
+					hits = append(hits, Hit{entry.listing, entry.point, d})
+				}
+			}
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Distance < hits[j].Distance })
+	return hits
+}
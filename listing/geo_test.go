@@ -0,0 +1,131 @@
+package listing
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParsePoint(t *testing.T) {
+	tests := []struct {
+		text string
+		want Point
+		err  string
+	}{
+		{"51.5074,-0.1278", Point{51.5074, -0.1278}, ""},
+		{" -33.8688 , 151.2093 ", Point{-33.8688, 151.2093}, ""},
+		{"90,180", Point{90, 180}, ""},
+		{"-90,-180", Point{-90, -180}, ""},
+		{"", Point{}, "no location"},
+		{"  ", Point{}, "no location"},
+		{"51.5", Point{}, "not latitude,longitude"},
+		{"1,2,3", Point{}, "not latitude,longitude"},
+		{"90.01,0", Point{}, "latitude must be between"},
+		{"-91,0", Point{}, "latitude must be between"},
+		{"NaN,0", Point{}, "latitude must be between"},
+		{"north,0", Point{}, "latitude must be between"},
+		{"0,180.5", Point{}, "longitude must be between"},
+		{"0,-181", Point{}, "longitude must be between"},
+		{"0,NaN", Point{}, "longitude must be between"},
+		{"0,", Point{}, "longitude must be between"},
+	}
+	for _, test := range tests {
+		got, err := ParsePoint(test.text)
+		switch {
+		case test.err == "" && (err != nil || got != test.want):
+			t.Errorf("%q: got %v, %v, want %v", test.text, got, err, test.want)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%q: got %v, want an error with %q", test.text, err, test.err)
+		}
+	}
+	if _, err := ParsePoint(""); err != ErrNoLocation {
+		t.Errorf("got %v, want ErrNoLocation", err)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		want float64 // km
+	}{
+		{"same point", Point{51.5, -0.1}, Point{51.5, -0.1}, 0},
+		{"a degree of latitude", Point{10, 20}, Point{11, 20}, kmPerDegree},
+		{"equator to pole", Point{0, 0}, Point{90, 0}, earthRadius * math.Pi / 2},
+		{"antipodes", Point{0, 0}, Point{0, 180}, earthRadius * math.Pi},
+		{"pole to pole", Point{90, 0}, Point{-90, 0}, earthRadius * math.Pi},
+		{"across the antimeridian", Point{0, 179.5}, Point{0, -179.5}, kmPerDegree},
+		{"London to Paris", Point{51.5074, -0.1278}, Point{48.8566, 2.3522}, 344},
+		{"New York to London", Point{40.7128, -74.0060}, Point{51.5074, -0.1278}, 5570},
+		{"Sydney to Auckland", Point{-33.8688, 151.2093}, Point{-36.8485, 174.7633}, 2156},
+	}
+	for _, test := range tests {
+		if got := Distance(test.a, test.b); math.Abs(got-test.want) > 1 {
+			t.Errorf("%s: got %.1f km, want %.1f", test.name, got, test.want)
+		}
+		if got, back := Distance(test.a, test.b), Distance(test.b, test.a); math.Abs(got-back) > 1e-9 {
+			t.Errorf("%s: %.6f one way, %.6f back", test.name, got, back)
+		}
+	}
+}
+
+func TestWithinMatchesEveryDistance(t *testing.T) {
+	var points []Point
+	for lat := -10.0; lat <= 10; lat += 2.5 {
+		for _, lng := range []float64{170, 175, 179, 179.9, 180, -180, -179.9, -179, -175, -170} {
+			points = append(points, Point{lat, lng})
+		}
+	}
+	for _, lat := range []float64{85, 88, 89.5, 89.99, 90} {
+		for lng := -180.0; lng < 180; lng += 30 {
+			points = append(points, Point{lat, lng}, Point{-lat, lng})
+		}
+	}
+	index := &GeoIndex{cells: make(map[cell][]located)}
+	names := make(map[*Listing]string)
+	for _, point := range points {
+		listing := &Listing{Title: point.String()}
+		names[listing] = listing.Title
+		index.Add(listing, point)
+	}
+
+	tests := []struct {
+		center Point
+		km     float64
+	}{
+		{Point{0, 179.9}, 30},
+		{Point{0, 180}, 300},
+		{Point{0, -180}, 600},
+		{Point{5, -179.99}, 1200},
+		{Point{89.5, 0}, 100},
+		{Point{90, 0}, 600},
+		{Point{-89.9, 45}, 300},
+		{Point{-88, -179}, 500},
+		{Point{0, 0}, 15000},
+	}
+	for _, test := range tests {
+		var want []string
+		for _, point := range points {
+			if Distance(test.center, point) <= test.km {
+				want = append(want, point.String())
+			}
+		}
+		var got []string
+		hits := index.Within(test.center, test.km)
+		for i, hit := range hits {
+			got = append(got, names[hit.Listing])
+			if i > 0 && hit.Distance < hits[i-1].Distance {
+				t.Errorf("%v within %v km: hits are not nearest first", test.center, test.km)
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want)
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%v within %v km: got %v, want %v", test.center, test.km, got, want)
+		}
+		if len(want) == 0 {
+			t.Errorf("%v within %v km finds nothing, the test is too weak", test.center, test.km)
+		}
+	}
+}
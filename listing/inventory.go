@@ -0,0 +1,536 @@
+/**
+ * Stock of listing variations.
+ *
+ * Variation.Quantity only says how many were listed. An Inventory keeps
+ * the stock of each variation and lets buyers reserve units while they
+ * pay: a reservation holds its units until it is committed, which sells
+ * them, released, or it expires. Units are only reserved while enough are
+ * neither sold nor held, under one mutex, so concurrent buyers cannot
+ * oversell.
+ *
+ * Stock is kept by SKU. AssignSKUs gives variations a SKU of their own,
+ * which is saved with the listing, so the stock stays with the variation
+ * when the listing is renamed or its variations are reordered.
+ *
+ * An inventory opened from a file writes every change to it before the
+ * change is made in memory, and holds a lock file while it is open so no
+ * second process sells the same units. The lock file names the process
+ * holding it, and a lock left behind by a process which is gone is taken
+ * over.
+ */
+
+package listing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultHold is how long reservations last unless Inventory.Hold is set.
+const DefaultHold = 15 * time.Minute
+
+var (
+	ErrNoReservation   = errors.New("no such reservation")
+	ErrInventoryLocked = errors.New("inventory is locked by another process")
+	ErrDuplicateSKU    = errors.New("SKU used by more than one variation")
+)
+
+// OutOfStockError is returned when fewer units are available than wanted.
+type OutOfStockError struct {
+	SKU               string
+	Wanted, Available int
+}
+
+func (e *OutOfStockError) Error() string {
+	return fmt.Sprintf("%s: %d wanted, %d available", e.SKU, e.Wanted, e.Available)
+}
+
+// SKU returns the SKU of variation i, counting from 0, of the listing. A
+// variation without one is named by the title slug, or the title without
+// one, and its position counting from 1, until AssignSKUs is used.
+func SKU(listing *Listing, i int) string {
+	if sku := listing.Variations[i].SKU; sku != "" {
+		return sku
+	}
+	name := listing.TitleSlug
+	if name == "" {
+		name = listing.Title
+	}
+	return name + "#" + strconv.Itoa(i+1)
+}
+
+// checkSKUs returns an error if two variations of the listings have the
+// same SKU.
+func checkSKUs(listings []*Listing) error {
+	seen := make(map[string]bool)
+	for _, listing := range listings {
+		for i := range listing.Variations {
+			sku := SKU(listing, i)
+			if seen[sku] {
+				return fmt.Errorf("%s: %w", sku, ErrDuplicateSKU)
+			}
+			seen[sku] = true
+		}
+	}
+	return nil
+}
+
+// AssignSKUs stores the SKU of every variation of the listings which has
+// none, and returns how many it stored. The listings must be saved for
+// the SKUs to stay. Two variations with the same SKU are an error, and
+// nothing is stored then.
+func AssignSKUs(listings []*Listing) (int, error) {
+	if err := checkSKUs(listings); err != nil {
+		return 0, err
+	}
+	assigned := 0
+	for _, listing := range listings {
+		for i, variation := range listing.Variations {
+			if variation.SKU == "" {
+				variation.SKU = SKU(listing, i)
+				assigned++
+			}
+		}
+	}
+	return assigned, nil
+}
+
+// Reservation holds units of a SKU for a buyer.
+type Reservation struct {
+	Id       string
+	SKU      string
+	Quantity int
+	Expires  time.Time
+}
+
+// inventoryState is what is written to the file.
+type inventoryState struct {
+	Stock        map[string]int // units not sold, held ones included
+	Reservations map[string]Reservation
+	LastId       int
+}
+
+// Inventory keeps the stock of variations. Now defaults to time.Now and
+// Hold to DefaultHold.
+type Inventory struct {
+	Now  func() time.Time
+	Hold time.Duration
+
+	mutex    sync.Mutex
+	state    inventoryState
+	held     map[string]int // units reserved by SKU
+	filename string
+}
+
+// NewInventory returns an empty inventory kept in memory only.
+func NewInventory() *Inventory {
+	inventory := &Inventory{}
+	inventory.reset(inventoryState{})
+	return inventory
+}
+
+// OpenInventory opens the inventory stored in filename, which need not
+// exist yet. It must be closed to remove its lock file.
+func OpenInventory(filename string) (*Inventory, error) {
+	if err := lockInventory(filename + ".lock"); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var state inventoryState
+	data, err := ioutil.ReadFile(filename)
+	switch {
+	case err == nil:
+		err = json.Unmarshal(data, &state)
+	case os.IsNotExist(err):
+		err = nil
+	}
+	if err != nil {
+		os.Remove(filename + ".lock")
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	inventory := &Inventory{filename: filename}
+	inventory.reset(state)
+	return inventory, nil
+}
+
+// lockInventory creates the lock file holding the process Id. A lock file
+// of a process which no longer runs is taken over, see takeOverLock.
+func lockInventory(lockname string) error {
+	for attempt := 0; ; attempt++ {
+		lock, err := os.OpenFile(lockname, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintln(lock, os.Getpid())
+			return lock.Close()
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		pid, err := readLock(lockname)
+		if os.IsNotExist(err) && attempt == 0 {
+			continue // unlocked meanwhile
+		}
+		if attempt > 0 || err != nil || processRuns(pid) {
+			return lockedError(lockname)
+		}
+		if err = takeOverLock(lockname, pid); err != nil {
+			return err
+		}
+	}
+}
+
+func lockedError(lockname string) error {
+	return fmt.Errorf("%w (remove %s if no program uses it)", ErrInventoryLocked, lockname)
+}
+
+// readLock returns the process Id a lock file holds.
+func readLock(lockname string) (int, error) {
+	data, err := ioutil.ReadFile(lockname)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// takeOverLock removes the lock file left by process pid, which is gone.
+// Between reading the lock and removing it another process may have taken
+// it over and locked again, so it is only removed while it still names
+// pid, and a second lock file, LOCK.takeover, keeps the processes taking
+// over from doing so at the same time.
+func takeOverLock(lockname string, pid int) error {
+	takeover := lockname + ".takeover"
+	guard, err := os.OpenFile(takeover, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return lockedError(takeover)
+	} else if err != nil {
+		return err
+	}
+	guard.Close()
+	defer os.Remove(takeover)
+	current, err := readLock(lockname)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil || current != pid:
+		return lockedError(lockname)
+	}
+	return os.Remove(lockname)
+}
+
+// processRuns tells whether a process runs. Where signals cannot tell, it
+// is taken to run.
+func processRuns(pid int) bool {
+	if pid <= 0 {
+		return true
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || !processGone(err)
+}
+
+// processGone tells whether an error of signalling a process says that it
+// no longer exists. os.Process.Signal returns an unexported error for
+// processes it waited for, os.ErrProcessDone from Go 1.16 on.
+func processGone(err error) bool {
+	return errors.Is(err, syscall.ESRCH) || err.Error() == "os: process already finished"
+}
+
+// Close removes the lock of an inventory opened from a file.
+func (inventory *Inventory) Close() error {
+	if inventory.filename == "" {
+		return nil
+	}
+	return os.Remove(inventory.filename + ".lock")
+}
+
+func (inventory *Inventory) reset(state inventoryState) {
+	if state.Stock == nil {
+		state.Stock = make(map[string]int)
+	}
+	if state.Reservations == nil {
+		state.Reservations = make(map[string]Reservation)
+	}
+	inventory.state = state
+	inventory.held = make(map[string]int)
+	for _, reservation := range state.Reservations {
+		inventory.held[reservation.SKU] += reservation.Quantity
+	}
+}
+
+func (inventory *Inventory) now() time.Time {
+	if inventory.Now == nil {
+		return time.Now()
+	}
+	return inventory.Now()
+}
+
+// change makes a change to a copy of the state, saves the copy and only
+// then uses it, so a failed write changes nothing.
+func (inventory *Inventory) change(change func(state *inventoryState) error) error {
+	state := inventoryState{
+		Stock:        make(map[string]int, len(inventory.state.Stock)),
+		Reservations: make(map[string]Reservation, len(inventory.state.Reservations)),
+		LastId:       inventory.state.LastId,
+	}
+	for sku, units := range inventory.state.Stock {
+		state.Stock[sku] = units
+	}
+	for id, reservation := range inventory.state.Reservations {
+		state.Reservations[id] = reservation
+	}
+	if err := change(&state); err != nil {
+		return err
+	}
+	if err := inventory.save(state); err != nil {
+		return err
+	}
+	inventory.reset(state)
+	return nil
+}
+
+func (inventory *Inventory) save(state inventoryState) error {
+	if inventory.filename == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	temp := inventory.filename + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0644); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, inventory.filename)
+}
+
+// expired drops the reservations which have expired from state and
+// returns them.
+func expired(state *inventoryState, now time.Time) []Reservation {
+	var dropped []Reservation
+	for id, reservation := range state.Reservations {
+		if !reservation.Expires.After(now) {
+			dropped = append(dropped, reservation)
+			delete(state.Reservations, id)
+		}
+	}
+	sort.Slice(dropped, func(i, j int) bool { return dropped[i].Id < dropped[j].Id })
+	return dropped
+}
+
+// hasExpired tells whether a reservation is due to expire, without
+// changing anything.
+func (inventory *Inventory) hasExpired(now time.Time) bool {
+	for _, reservation := range inventory.state.Reservations {
+		if !reservation.Expires.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expire releases the reservations which have expired and returns them.
+func (inventory *Inventory) Expire() ([]Reservation, error) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	now := inventory.now()
+	if !inventory.hasExpired(now) {
+		return nil, nil
+	}
+	var dropped []Reservation
+	err := inventory.change(func(state *inventoryState) error {
+		dropped = expired(state, now)
+		return nil
+	})
+	return dropped, err
+}
+
+// Load takes the stock of the variations of listings which are not in the
+// inventory yet from their Quantity, and returns how many it took. Two
+// variations with the same SKU are an error.
+func (inventory *Inventory) Load(listings []*Listing) (int, error) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	if err := checkSKUs(listings); err != nil {
+		return 0, err
+	}
+	added := 0
+	err := inventory.change(func(state *inventoryState) error {
+		for _, listing := range listings {
+			for i, variation := range listing.Variations {
+				sku := SKU(listing, i)
+				if _, ok := state.Stock[sku]; ok {
+					continue
+				}
+				if variation.Quantity < 0 {
+					return fmt.Errorf("%s: negative quantity", sku)
+				}
+				state.Stock[sku] = variation.Quantity
+				added++
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// Update sets the Quantity of the variations of listings to their units
+// available.
+func (inventory *Inventory) Update(listings []*Listing) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	now := inventory.now()
+	for _, listing := range listings {
+		for i, variation := range listing.Variations {
+			if units, ok := inventory.state.Stock[SKU(listing, i)]; ok {
+				variation.Quantity = units - inventory.heldAt(SKU(listing, i), now)
+			}
+		}
+	}
+}
+
+// SetStock sets the units of a SKU which are not sold, the held ones
+// included. It cannot go below the units held.
+func (inventory *Inventory) SetStock(sku string, units int) error {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	if held := inventory.heldAt(sku, inventory.now()); units < held {
+		return fmt.Errorf("%s: %d units are reserved", sku, held)
+	}
+	return inventory.change(func(state *inventoryState) error {
+		state.Stock[sku] = units
+		return nil
+	})
+}
+
+// heldAt returns the units of a SKU held by reservations which have not
+// expired at now.
+func (inventory *Inventory) heldAt(sku string, now time.Time) int {
+	held := inventory.held[sku]
+	for _, reservation := range inventory.state.Reservations {
+		if reservation.SKU == sku && !reservation.Expires.After(now) {
+			held -= reservation.Quantity
+		}
+	}
+	return held
+}
+
+// Stock returns the units of a SKU which are not sold and those of them
+// which are available, i.e. not held.
+func (inventory *Inventory) Stock(sku string) (units, available int) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	units = inventory.state.Stock[sku]
+	return units, units - inventory.heldAt(sku, inventory.now())
+}
+
+// SKUs returns the SKUs of the inventory in order.
+func (inventory *Inventory) SKUs() []string {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	skus := make([]string, 0, len(inventory.state.Stock))
+	for sku := range inventory.state.Stock {
+		skus = append(skus, sku)
+	}
+	sort.Strings(skus)
+	return skus
+}
+
+// Reservations returns the reservations which have not expired, oldest
+// first.
+func (inventory *Inventory) Reservations() []Reservation {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	now := inventory.now()
+	var reservations []Reservation
+	for _, reservation := range inventory.state.Reservations {
+		if reservation.Expires.After(now) {
+			reservations = append(reservations, reservation)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		return reservations[i].Expires.Before(reservations[j].Expires)
+	})
+	return reservations
+}
+
+// Reserve holds quantity units of a SKU until Hold has passed.
+func (inventory *Inventory) Reserve(sku string, quantity int) (Reservation, error) {
+	if quantity < 1 {
+		return Reservation{}, errors.New("quantity must be positive")
+	}
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	now := inventory.now()
+	units, ok := inventory.state.Stock[sku]
+	if !ok {
+		return Reservation{}, fmt.Errorf("%s: unknown SKU", sku)
+	}
+	if available := units - inventory.heldAt(sku, now); available < quantity {
+		return Reservation{}, &OutOfStockError{sku, quantity, available}
+	}
+	hold := inventory.Hold
+	if hold <= 0 {
+		hold = DefaultHold
+	}
+	var reservation Reservation
+	err := inventory.change(func(state *inventoryState) error {
+		expired(state, now)
+		state.LastId++
+		reservation = Reservation{"r" + strconv.Itoa(state.LastId), sku, quantity, now.Add(hold)}
+		state.Reservations[reservation.Id] = reservation
+		return nil
+	})
+	return reservation, err
+}
+
+// take removes the reservation id from state. Expired reservations are
+// gone.
+func take(state *inventoryState, id string, now time.Time) (Reservation, error) {
+	reservation, ok := state.Reservations[id]
+	if !ok || !reservation.Expires.After(now) {
+		return Reservation{}, fmt.Errorf("%s: %w", id, ErrNoReservation)
+	}
+	delete(state.Reservations, id)
+	return reservation, nil
+}
+
+// Commit sells the units of a reservation.
+func (inventory *Inventory) Commit(id string) (Reservation, error) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	now := inventory.now()
+	var reservation Reservation
+	err := inventory.change(func(state *inventoryState) (err error) {
+		if reservation, err = take(state, id, now); err != nil {
+			return err
+		}
+		state.Stock[reservation.SKU] -= reservation.Quantity
+		return nil
+	})
+	return reservation, err
+}
+
+// Release gives the units of a reservation back.
+func (inventory *Inventory) Release(id string) (Reservation, error) {
+	inventory.mutex.Lock()
+	defer inventory.mutex.Unlock()
+	now := inventory.now()
+	var reservation Reservation
+	err := inventory.change(func(state *inventoryState) (err error) {
+		reservation, err = take(state, id, now)
+		return err
+	})
+	return reservation, err
+}
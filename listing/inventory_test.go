@@ -0,0 +1,230 @@
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInventoryDoesNotOversell(t *testing.T) {
+	const (
+		sku    = "stress#1"
+		stock  = 50
+		buyers = 200
+	)
+	inventory := NewInventory()
+	if err := inventory.SetStock(sku, stock); err != nil {
+		t.Fatal(err)
+	}
+	var sold, refused int64
+	failures := make(chan error, buyers)
+	var wg sync.WaitGroup
+	for i := 0; i < buyers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			quantity := 1 + i%3
+			reservation, err := inventory.Reserve(sku, quantity)
+			var outOfStock *OutOfStockError
+			if errors.As(err, &outOfStock) {
+				atomic.AddInt64(&refused, 1)
+				return
+			} else if err != nil {
+				failures <- err
+				return
+			}
+			if i%4 == 0 {
+				_, err = inventory.Release(reservation.Id)
+			} else if _, err = inventory.Commit(reservation.Id); err == nil {
+				atomic.AddInt64(&sold, int64(quantity))
+			}
+			if err != nil {
+				failures <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(failures)
+	for err := range failures {
+		t.Error(err)
+	}
+	left, available := inventory.Stock(sku)
+	if sold > stock || int64(left) != stock-sold || available != left || left < 0 {
+		t.Errorf("sold %d of %d, %d left, %d available", sold, stock, left, available)
+	}
+	if refused == 0 {
+		t.Errorf("no buyer was refused, the test sells too little")
+	}
+}
+
+func TestInventoryReservationsExpire(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	inventory := NewInventory()
+	inventory.Now = func() time.Time { return now }
+	inventory.SetStock("a#1", 3)
+	reservation, err := inventory.Reserve("a#1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = inventory.Reserve("a#1", 1); err == nil {
+		t.Fatal("reserved more than in stock")
+	}
+	now = now.Add(DefaultHold)
+	if _, available := inventory.Stock("a#1"); available != 3 {
+		t.Errorf("%d available after the reservation expired, want 3", available)
+	}
+	if _, err = inventory.Commit(reservation.Id); !errors.Is(err, ErrNoReservation) {
+		t.Errorf("committed an expired reservation: %v", err)
+	}
+}
+
+func TestSKUs(t *testing.T) {
+	variations := func(n int) []*Variation {
+		var variations []*Variation
+		for i := 0; i < n; i++ {
+			variations = append(variations, &Variation{Quantity: 5})
+		}
+		return variations
+	}
+	tests := []struct {
+		name     string
+		listings []*Listing
+		want     []string
+		err      error
+	}{
+		{"by slug", []*Listing{{Title: "Red Shoe", TitleSlug: "red-shoe", Variations: variations(2)}},
+			[]string{"red-shoe#1", "red-shoe#2"}, nil},
+		{"by title", []*Listing{{Title: "Red Shoe", Variations: variations(1)}}, []string{"Red Shoe#1"}, nil},
+		{"same title", []*Listing{{Title: "Shoe", Variations: variations(1)}, {Title: "Shoe", Variations: variations(1)}},
+			nil, ErrDuplicateSKU},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := AssignSKUs(test.listings)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got %v, want %v", err, test.err)
+			}
+			var got []string
+			for _, listing := range test.listings {
+				for _, variation := range listing.Variations {
+					if variation.SKU != "" {
+						got = append(got, variation.SKU)
+					}
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSKUStaysWithVariation(t *testing.T) {
+	listing := &Listing{Title: "Shoe", TitleSlug: "shoe", Variations: []*Variation{{Quantity: 1}, {Quantity: 2}}}
+	inventory := NewInventory()
+	if _, err := AssignSKUs([]*Listing{listing}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inventory.Load([]*Listing{listing}); err != nil {
+		t.Fatal(err)
+	}
+	listing.TitleSlug = "red-shoe"
+	listing.Variations[0], listing.Variations[1] = listing.Variations[1], listing.Variations[0]
+	inventory.Update([]*Listing{listing})
+	if listing.Variations[0].Quantity != 2 || listing.Variations[1].Quantity != 1 {
+		t.Errorf("quantities %d and %d, want 2 and 1", listing.Variations[0].Quantity, listing.Variations[1].Quantity)
+	}
+}
+
+func TestOpenInventoryLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "stock.json")
+
+	inventory, err := OpenInventory(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenInventory(filename); !errors.Is(err, ErrInventoryLocked) {
+		t.Errorf("opened twice: %v", err)
+	}
+	inventory.Close()
+
+	// A lock left by a process which is gone is taken over.
+	if err = ioutil.WriteFile(filename+".lock", []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if inventory, err = OpenInventory(filename); err != nil {
+		t.Fatalf("stale lock: %v", err)
+	}
+	inventory.Close()
+}
+
+func TestTakeOverLockKeepsFreshLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockname := filepath.Join(dir, "stock.json.lock")
+
+	// Another process took the stale lock of 999999999 over and locked
+	// again after this one read it.
+	if err = ioutil.WriteFile(lockname, []byte(fmt.Sprintln(os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = takeOverLock(lockname, 999999999); !errors.Is(err, ErrInventoryLocked) {
+		t.Errorf("took over a fresh lock: %v", err)
+	}
+	if pid, err := readLock(lockname); err != nil || pid != os.Getpid() {
+		t.Errorf("the fresh lock now holds %d, %v", pid, err)
+	}
+
+	// Another process is taking the stale lock over right now.
+	if err = ioutil.WriteFile(lockname, []byte("999999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(lockname+".takeover", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenInventory(filepath.Join(dir, "stock.json")); !errors.Is(err, ErrInventoryLocked) {
+		t.Errorf("took over while another process does: %v", err)
+	}
+	os.Remove(lockname + ".takeover")
+	if err = takeOverLock(lockname, 999999999); err != nil {
+		t.Errorf("stale lock: %v", err)
+	}
+	if _, err = os.Stat(lockname); !os.IsNotExist(err) {
+		t.Errorf("the stale lock is still there: %v", err)
+	}
+}
+
+func TestProcessGone(t *testing.T) {
+	tests := []struct {
+		err  error
+		gone bool
+	}{
+		{syscall.ESRCH, true},
+		{fmt.Errorf("signal: %w", syscall.ESRCH), true},
+		{errors.New("os: process already finished"), true},
+		{syscall.EPERM, false},
+	}
+	for _, test := range tests {
+		if got := processGone(test.err); got != test.gone {
+			t.Errorf("%v: got %v, want %v", test.err, got, test.gone)
+		}
+	}
+	if !processRuns(os.Getpid()) {
+		t.Error("this process does not run")
+	}
+}
@@ -0,0 +1,161 @@
+/**
+ * Listing lifecycle.
+ *
+ * A listing is a draft until it is scheduled or published. Scheduled
+ * listings are published by the clock at StartTime and published or
+ * paused ones end at EndTime. Ended listings can be archived, which is
+ * final. Every change of status is appended to Events, which are stored
+ * with the listing, and passed to the handlers of the Lifecycle.
+ */
+
+package listing
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is stored in the _fpaiStatus member.
+type Status string
+
+const (
+	Draft     Status = "draft"
+	Scheduled Status = "scheduled"
+	Published Status = "published"
+	Paused    Status = "paused"
+	Ended     Status = "ended"
+	Archived  Status = "archived"
+)
+
+// transitions are the statuses each status may change to.
+var transitions = map[Status][]Status{
+	Draft:     {Scheduled, Published, Archived},
+	Scheduled: {Draft, Published, Archived},
+	Published: {Paused, Ended},
+	Paused:    {Published, Ended},
+	Ended:     {Archived},
+	Archived:  nil,
+}
+
+// Statuses returns every status in lifecycle order.
+func Statuses() []Status {
+	return []Status{Draft, Scheduled, Published, Paused, Ended, Archived}
+}
+
+// CanChange tells whether a listing may go from one status to another.
+func CanChange(from, to Status) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Event records a change of status.
+type Event struct {
+	From   Status    `json:"from"`
+	To     Status    `json:"to"`
+	At     time.Time `json:"at"`
+	Reason string    `json:"reason,omitempty"`
+	Auto   bool      `json:"auto,omitempty"` // made by the clock
+}
+
+// TransitionError is returned for a change of status which is not allowed.
+type TransitionError struct {
+	From, To Status
+	Reason   string
+}
+
+func (e *TransitionError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("cannot change a listing from %s to %s: %s", e.From, e.To, e.Reason)
+	}
+	return fmt.Sprintf("cannot change a listing from %s to %s", e.From, e.To)
+}
+
+// Status returns the status of the listing; listings without one are
+// drafts.
+func (listing *Listing) Status() Status {
+	if listing.FpaiStatus == "" {
+		return Draft
+	}
+	return Status(listing.FpaiStatus)
+}
+
+// Lifecycle changes the status of listings. Now defaults to time.Now.
+type Lifecycle struct {
+	Now      func() time.Time
+	handlers []func(*Listing, Event)
+}
+
+// OnTransition adds a handler called after every change of status.
+func (lifecycle *Lifecycle) OnTransition(handler func(*Listing, Event)) {
+	lifecycle.handlers = append(lifecycle.handlers, handler)
+}
+
+func (lifecycle *Lifecycle) now() time.Time {
+	if lifecycle.Now == nil {
+		return time.Now()
+	}
+	return lifecycle.Now()
+}
+
+// check returns why the listing cannot change to status, or nil.
+func check(listing *Listing, to Status, at time.Time) error {
+	from := listing.Status()
+	if _, ok := transitions[from]; !ok {
+		return &TransitionError{from, to, "unknown status"}
+	}
+	if !CanChange(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+	switch {
+	case to == Scheduled && !listing.StartTime.After(at):
+		return &TransitionError{from, to, "the start time must be in the future"}
+	case to == Scheduled && !listing.EndTime.IsZero() && !listing.EndTime.After(listing.StartTime):
+		return &TransitionError{from, to, "the end time must be after the start time"}
+	case to == Published && !listing.EndTime.IsZero() && !listing.EndTime.After(at):
+		return &TransitionError{from, to, "the end time has passed"}
+	}
+	return nil
+}
+
+func (lifecycle *Lifecycle) apply(listing *Listing, to Status, at time.Time, reason string, auto bool) Event {
+	event := Event{listing.Status(), to, at, reason, auto}
+	listing.FpaiStatus = string(to)
+	listing.Events = append(listing.Events, event)
+	for _, handler := range lifecycle.handlers {
+		handler(listing, event)
+	}
+	return event
+}
+
+// Change moves the listing to status by hand, after the changes the clock
+// calls for, so an expired listing cannot be paused.
+func (lifecycle *Lifecycle) Change(listing *Listing, to Status, reason string) (Event, error) {
+	lifecycle.Advance(listing)
+	at := lifecycle.now()
+	if err := check(listing, to, at); err != nil {
+		return Event{}, err
+	}
+	return lifecycle.apply(listing, to, at, reason, false), nil
+}
+
+// Advance makes the changes the clock calls for: scheduled listings are
+// published at StartTime and published or paused ones end at EndTime. The
+// events are dated when they were due.
+func (lifecycle *Lifecycle) Advance(listing *Listing) []Event {
+	now := lifecycle.now()
+	var events []Event
+	for {
+		switch status := listing.Status(); {
+		case status == Scheduled && !listing.StartTime.After(now):
+			events = append(events, lifecycle.apply(listing, Published, listing.StartTime, "start time reached", true))
+			continue
+		case (status == Published || status == Paused) && !listing.EndTime.IsZero() && !listing.EndTime.After(now):
+			events = append(events, lifecycle.apply(listing, Ended, listing.EndTime, "end time reached", true))
+		}
+		return events
+	}
+}
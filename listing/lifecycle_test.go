@@ -0,0 +1,132 @@
+package listing
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanChange(t *testing.T) {
+	allowed := []string{
+		"draft>scheduled", "draft>published", "draft>archived",
+		"scheduled>draft", "scheduled>published", "scheduled>archived",
+		"published>paused", "published>ended",
+		"paused>published", "paused>ended",
+		"ended>archived",
+	}
+	var got []string
+	for _, from := range Statuses() {
+		for _, to := range Statuses() {
+			if CanChange(from, to) {
+				got = append(got, fmt.Sprintf("%s>%s", from, to))
+			}
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(allowed)
+	if strings.Join(got, " ") != strings.Join(allowed, " ") {
+		t.Errorf("got changes %v, want %v", got, allowed)
+	}
+	if CanChange("sold", Ended) {
+		t.Error("an unknown status may change")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	later, earlier := now.Add(time.Hour), now.Add(-time.Hour)
+	tests := []struct {
+		name       string
+		status     Status
+		start, end time.Time
+		to         Status
+		err        string
+	}{
+		{"schedule", Draft, later, later.Add(time.Hour), Scheduled, ""},
+		{"schedule without end", Draft, later, time.Time{}, Scheduled, ""},
+		{"schedule in the past", Draft, earlier, time.Time{}, Scheduled, "start time must be in the future"},
+		{"schedule now", Draft, now, time.Time{}, Scheduled, "start time must be in the future"},
+		{"end before start", Draft, later.Add(time.Hour), later, Scheduled, "end time must be after the start time"},
+		{"end at start", Draft, later, later, Scheduled, "end time must be after the start time"},
+		{"publish", Draft, time.Time{}, later, Published, ""},
+		{"publish after the end", Paused, time.Time{}, earlier, Published, "end time has passed"},
+		{"not allowed", Archived, time.Time{}, time.Time{}, Draft, "from archived to draft"},
+		{"unknown status", "sold", time.Time{}, time.Time{}, Ended, "unknown status"},
+	}
+	for _, test := range tests {
+		listing := &Listing{FpaiStatus: string(test.status), StartTime: test.start, EndTime: test.end}
+		err := check(listing, test.to, now)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, want an error with %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	start := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 7)
+	tests := []struct {
+		name   string
+		status Status
+		now    time.Time
+		want   string // statuses changed to, in order
+	}{
+		{"before the start", Scheduled, start.Add(-time.Second), ""},
+		{"at the start", Scheduled, start, "published@start"},
+		{"start and end passed", Scheduled, end.Add(time.Hour), "published@start ended@end"},
+		{"published at the end", Published, end, "ended@end"},
+		{"paused after the end", Paused, end.AddDate(0, 0, 1), "ended@end"},
+		{"ended", Ended, end.AddDate(0, 0, 1), ""},
+		{"draft", Draft, end.AddDate(0, 0, 1), ""},
+	}
+	for _, test := range tests {
+		listing := &Listing{FpaiStatus: string(test.status), StartTime: start, EndTime: end}
+		var handled []Event
+		lifecycle := &Lifecycle{Now: func() time.Time { return test.now }}
+		lifecycle.OnTransition(func(_ *Listing, event Event) { handled = append(handled, event) })
+
+		events := lifecycle.Advance(listing)
+		var got []string
+		for _, event := range events {
+			at := "start"
+			if event.At.Equal(end) {
+				at = "end"
+			}
+			if !event.Auto {
+				t.Errorf("%s: %v is not marked as made by the clock", test.name, event)
+			}
+			got = append(got, fmt.Sprintf("%s@%s", event.To, at))
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s: got %v, want %s", test.name, got, test.want)
+		}
+		if len(handled) != len(events) || len(listing.Events) != len(events) {
+			t.Errorf("%s: %d events, %d handled and %d stored", test.name, len(events), len(handled), len(listing.Events))
+		}
+	}
+}
+
+func TestChangeAdvancesFirst(t *testing.T) {
+	end := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	listing := &Listing{FpaiStatus: string(Published), EndTime: end}
+	lifecycle := &Lifecycle{Now: func() time.Time { return end.Add(time.Minute) }}
+
+	if _, err := lifecycle.Change(listing, Paused, "stock count"); err == nil {
+		t.Fatal("an expired listing was paused")
+	}
+	if listing.Status() != Ended {
+		t.Errorf("got %s, want %s", listing.Status(), Ended)
+	}
+	event, err := lifecycle.Change(listing, Archived, "done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.From != Ended || event.Auto || event.Reason != "done" {
+		t.Errorf("got %+v", event)
+	}
+}
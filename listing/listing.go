@@ -0,0 +1,329 @@
+/**
+ * Marketplace listings.
+ *
+ * The JSON is the listing document of the marketplace, see TestJson2 in
+ * the main package for an example. Keys are matched exactly, members
+ * without a field are kept in Extra, and StartTime and EndTime keep their
+ * original text unless they are changed, so decoding and encoding again
+ * gives the same document.
+ */
+
+package listing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"time"
+)
+
+type Listing struct {
+	Version     string       `json:"_version,omitempty"`
+	CategoryId  []string     `json:"categoryId"`
+	Title       string       `json:"title"`
+	Media       Media        `json:"media"`
+	SellerId    string       `json:"sellerId"`
+	Locale      Locale       `json:"locale"`
+	ListingType string       `json:"listingType"`
+	Payment     Payment      `json:"payment"`
+	StartTime   time.Time    `json:"startTime"`
+	EndTime     time.Time    `json:"endTime"`
+	Shipping    []Shipping   `json:"shipping"`
+	TitleSlug   string       `json:"titleSlug"`
+	Variations  []*Variation `json:"variations"`
+	FpaiStatus  string       `json:"_fpaiStatus,omitempty"` // see Status
+	Events      []Event      `json:"events,omitempty"`      // see Lifecycle
+	Extra       Extra        `json:"-"`
+
+	// The times as they were decoded, written back while unchanged.
+	startText, endText string
+
+	absent []string // see decodeStrict
+	single bool     // decoded from a document of its own, see Encode
+}
+
+type Locale struct {
+	Location         string           `json:"location"` // "latitude,longitude"
+	CountryCode      string           `json:"countryCode"`
+	CurrencyId       string           `json:"currencyId"`
+	CurrencySymbol   string           `json:"currencySymbol"`
+	LocationReadable LocationReadable `json:"locationReadable"`
+	Extra            Extra            `json:"-"`
+
+	absent []string // see decodeStrict
+}
+
+type LocationReadable struct {
+	District string `json:"district"`
+	City     string `json:"city"`
+	State    string `json:"state"`
+	Extra    Extra  `json:"-"`
+
+	absent []string // see decodeStrict
+}
+
+type Media struct {
+	Image []string `json:"image"`
+	Video []string `json:"video"`
+	Extra Extra    `json:"-"`
+
+	absent []string // see decodeStrict
+}
+
+type Variation struct {
+	FixedPrice float64 `json:"fixedPrice"`
+	Media      Media   `json:"media"`
+	Quantity   int     `json:"quantity"`
+	Brand      string  `json:"Brand,omitempty"`
+	SKU        string  `json:"sku,omitempty"` // stock keeping unit, see AssignSKUs
+	Extra      Extra   `json:"-"`
+
+	absent []string // see decodeStrict
+}
+
+type PaymentMethod struct {
+	PaymentName    string `json:"paymentName"`
+	PaymentService string `json:"paymentService"`
+	Extra          Extra  `json:"-"`
+
+	absent []string // see decodeStrict
+}
+
+type Payment struct {
+	Online  []PaymentMethod `json:"online"`
+	Offline []PaymentMethod `json:"offline"`
+	Extra   Extra           `json:"-"`
+
+	absent []string // see decodeStrict
+}
+
+type Shipping struct {
+	ShippingService        string  `json:"shippingService"`
+	ShippingName           string  `json:"shippingName"`
+	ShippingCost           float64 `json:"shippingCost"`
+	HandlingTimeMax        int     `json:"handlingTimeMax"` // days
+	DispatchTimeMin        int     `json:"dispatchTimeMin"` // days
+	DispatchTimeMax        int     `json:"dispatchTimeMax"` // days
+	ShippingAdditionalCost string  `json:"shippingAdditionalCost"`
+	Extra                  Extra   `json:"-"`
+
+	absent []string // see decodeStrict
+}
+
+// listingFields has the fields of Listing without its methods.
+type listingFields Listing
+
+// jsonListing replaces the times of Listing by their text.
+type jsonListing struct {
+	listingFields
+	StartTime string `json:"startTime,omitempty"`
+	EndTime   string `json:"endTime,omitempty"`
+}
+
+func parseTime(text string) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, text)
+}
+
+// formatTime returns text while it still stands for t.
+func formatTime(t time.Time, text string) string {
+	if t.IsZero() {
+		return ""
+	}
+	if parsed, err := parseTime(text); err == nil && parsed.Equal(t) {
+		if _, offset := parsed.Zone(); offset == zoneOffset(t) {
+			return text
+		}
+	}
+	return t.Format(time.RFC3339)
+}
+
+func zoneOffset(t time.Time) int {
+	_, offset := t.Zone()
+	return offset
+}
+
+func (listing *Listing) UnmarshalJSON(data []byte) (err error) {
+	var jsonListing jsonListing
+	extra, absent, err := decodeStrict(data, &jsonListing)
+	if err != nil {
+		return err
+	}
+	*listing = Listing(jsonListing.listingFields)
+	if listing.StartTime, err = parseTime(jsonListing.StartTime); err != nil {
+		return fmt.Errorf("startTime: %v", err)
+	}
+	if listing.EndTime, err = parseTime(jsonListing.EndTime); err != nil {
+		return fmt.Errorf("endTime: %v", err)
+	}
+	listing.startText, listing.endText = jsonListing.StartTime, jsonListing.EndTime
+	listing.Extra, listing.absent = extra, absent
+	return nil
+}
+
+func (listing Listing) MarshalJSON() ([]byte, error) {
+	return encodeWithExtra(jsonListing{
+		listingFields(listing),
+		formatTime(listing.StartTime, listing.startText),
+		formatTime(listing.EndTime, listing.endText),
+	}, listing.Extra, listing.absent)
+}
+
+// The other types only need their members kept, through a copy of the
+// type without methods.
+
+func (locale *Locale) UnmarshalJSON(data []byte) (err error) {
+	type fields Locale
+	locale.Extra, locale.absent, err = decodeStrict(data, (*fields)(locale))
+	return err
+}
+
+func (locale Locale) MarshalJSON() ([]byte, error) {
+	type fields Locale
+	return encodeWithExtra(fields(locale), locale.Extra, locale.absent)
+}
+
+func (location *LocationReadable) UnmarshalJSON(data []byte) (err error) {
+	type fields LocationReadable
+	location.Extra, location.absent, err = decodeStrict(data, (*fields)(location))
+	return err
+}
+
+func (location LocationReadable) MarshalJSON() ([]byte, error) {
+	type fields LocationReadable
+	return encodeWithExtra(fields(location), location.Extra, location.absent)
+}
+
+func (media *Media) UnmarshalJSON(data []byte) (err error) {
+	type fields Media
+	media.Extra, media.absent, err = decodeStrict(data, (*fields)(media))
+	return err
+}
+
+func (media Media) MarshalJSON() ([]byte, error) {
+	type fields Media
+	return encodeWithExtra(fields(media), media.Extra, media.absent)
+}
+
+func (variation *Variation) UnmarshalJSON(data []byte) (err error) {
+	type fields Variation
+	variation.Extra, variation.absent, err = decodeStrict(data, (*fields)(variation))
+	return err
+}
+
+func (variation Variation) MarshalJSON() ([]byte, error) {
+	type fields Variation
+	return encodeWithExtra(fields(variation), variation.Extra, variation.absent)
+}
+
+func (method *PaymentMethod) UnmarshalJSON(data []byte) (err error) {
+	type fields PaymentMethod
+	method.Extra, method.absent, err = decodeStrict(data, (*fields)(method))
+	return err
+}
+
+func (method PaymentMethod) MarshalJSON() ([]byte, error) {
+	type fields PaymentMethod
+	return encodeWithExtra(fields(method), method.Extra, method.absent)
+}
+
+func (payment *Payment) UnmarshalJSON(data []byte) (err error) {
+	type fields Payment
+	payment.Extra, payment.absent, err = decodeStrict(data, (*fields)(payment))
+	return err
+}
+
+func (payment Payment) MarshalJSON() ([]byte, error) {
+	type fields Payment
+	return encodeWithExtra(fields(payment), payment.Extra, payment.absent)
+}
+
+func (shipping *Shipping) UnmarshalJSON(data []byte) (err error) {
+	type fields Shipping
+	shipping.Extra, shipping.absent, err = decodeStrict(data, (*fields)(shipping))
+	return err
+}
+
+func (shipping Shipping) MarshalJSON() ([]byte, error) {
+	type fields Shipping
+	return encodeWithExtra(fields(shipping), shipping.Extra, shipping.absent)
+}
+
+// Decode reads a listing or an array of listings.
+func Decode(reader io.Reader) ([]*Listing, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		listing := new(Listing)
+		if err = json.Unmarshal(data, listing); err != nil {
+			return nil, err
+		}
+		listing.single = true
+		return []*Listing{listing}, nil
+	}
+	var listings []*Listing
+	err = json.Unmarshal(data, &listings)
+	return listings, err
+}
+
+// Encode writes the listings as an indented JSON array, or a listing
+// decoded from a document holding only it as an object again.
+func Encode(writer io.Writer, listings []*Listing) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	if len(listings) == 1 && listings[0].single {
+		return encoder.Encode(listings[0])
+	}
+	return encoder.Encode(listings)
+}
+
+// ReadFile reads the listings of a file.
+func ReadFile(filename string) ([]*Listing, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	listings, err := Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return listings, nil
+}
+
+// WriteFile writes the listings to a file.
+func WriteFile(filename string, listings []*Listing) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = Encode(file, listings); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Equivalent tells whether two JSON documents hold the same values,
+// whatever their layout and member order. It checks that a decode and
+// encode lost nothing.
+func Equivalent(a, b []byte) (bool, error) {
+	var x, y interface{}
+	if err := json.Unmarshal(a, &x); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &y); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(x, y), nil
+}
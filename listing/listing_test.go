@@ -0,0 +1,70 @@
+package listing
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeEncodeLosesNothing(t *testing.T) {
+	tests := []struct {
+		name, document string
+	}{
+		{"empty object", `{}`},
+		{"absent keys", `{"title":"Shoe","locale":{"currencyId":"GBP"},"variations":[{"fixedPrice":5}]}`},
+		{"unknown keys", `{"title":"Shoe","colour":"red","media":{"image":["a.jpg"],"alt":"x"}}`},
+		{"null and empty", `{"title":"","categoryId":null,"shipping":[]}`},
+		{"times", `{"startTime":"2020-01-01T10:00:00+01:00","endTime":"2020-02-01T00:00:00Z"}`},
+		{"array", `[{"title":"A"},{"title":"B","titleSlug":"b"}]`},
+		{"array of one", `[{"title":"A"}]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listings, err := Decode(strings.NewReader(test.document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var encoded bytes.Buffer
+			if err = Encode(&encoded, listings); err != nil {
+				t.Fatal(err)
+			}
+			same, err := Equivalent([]byte(test.document), encoded.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !same {
+				t.Errorf("got\n%s", encoded.Bytes())
+			}
+		})
+	}
+}
+
+func TestEncodeAddsChangedFields(t *testing.T) {
+	listings, err := Decode(strings.NewReader(`{"title":"Shoe","variations":[{"fixedPrice":5}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listings[0].TitleSlug = "shoe"
+	listings[0].Variations[0].Quantity = 3
+	var encoded bytes.Buffer
+	if err = Encode(&encoded, listings); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"title":"Shoe","titleSlug":"shoe","variations":[{"fixedPrice":5,"quantity":3}]}`
+	if same, _ := Equivalent([]byte(want), encoded.Bytes()); !same {
+		t.Errorf("got\n%s", encoded.Bytes())
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	listings, err := Decode(strings.NewReader(`[{"Title":"wrong case","title":"Shoe"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listings[0].Title != "Shoe" || string(listings[0].Extra["Title"]) != `"wrong case"` {
+		t.Errorf("title %q, extra %v", listings[0].Title, listings[0].Extra)
+	}
+	if _, err = Decode(strings.NewReader(`{"startTime":"yesterday"}`)); err == nil {
+		t.Error("invalid time accepted")
+	}
+}
@@ -0,0 +1,311 @@
+/**
+ * Repair of mojibake in imported listings.
+ *
+ * Text written as UTF-8 and read as Windows-1252 or Latin-1 turns every
+ * non-ASCII character into two or three, e.g. "£" into "Â£" and "’" into
+ * "â€™". Since UTF-8 only uses bytes above 0x7F for such characters, every
+ * run of non-ASCII characters which maps back to bytes making valid UTF-8
+ * is taken for mojibake and replaced by what it decodes to, repeatedly for
+ * text garbled more than once. Each repair is reported with the path of
+ * the string and its text before, so it can be reverted.
+ */
+
+package listing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// cp1252 holds the characters Windows-1252 has for bytes 0x80 to 0x9F
+// where Latin-1 has control characters.
+var cp1252 = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91,
+	'’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98,
+	'™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// maxRepairRounds bounds how many times text is taken as garbled.
+const maxRepairRounds = 3
+
+// misreadByte returns the byte which read as Windows-1252 or Latin-1 gives
+// r.
+func misreadByte(r rune) (byte, bool) {
+	if r >= 0x80 && r <= 0xFF {
+		return byte(r), true
+	}
+	b, ok := cp1252[r]
+	return b, ok
+}
+
+// repairRun returns what a run of non-ASCII characters was before it was
+// garbled, or false if it is not mojibake.
+func repairRun(run string) (string, bool) {
+	raw := make([]byte, 0, len(run))
+	for _, r := range run {
+		b, ok := misreadByte(r)
+		if !ok {
+			return "", false
+		}
+		raw = append(raw, b)
+	}
+	if !utf8.Valid(raw) {
+		return "", false
+	}
+	for _, r := range string(raw) {
+		if r >= 0x80 && r <= 0x9F {
+			return "", false // control characters are no text
+		}
+	}
+	return string(raw), true
+}
+
+// repairOnce repairs the runs of non-ASCII characters of text which are
+// mojibake.
+func repairOnce(text string) string {
+	var repaired strings.Builder
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		if fixed, ok := repairRun(text[start:end]); ok {
+			repaired.WriteString(fixed)
+		} else {
+			repaired.WriteString(text[start:end])
+		}
+		start = -1
+	}
+	for i, r := range text {
+		if r < utf8.RuneSelf {
+			flush(i)
+			repaired.WriteRune(r)
+		} else if start < 0 {
+			start = i
+		}
+	}
+	flush(len(text))
+	return repaired.String()
+}
+
+// RepairText returns text with its mojibake repaired.
+func RepairText(text string) string {
+	for round := 0; round < maxRepairRounds; round++ {
+		repaired := repairOnce(text)
+		if repaired == text {
+			break
+		}
+		text = repaired
+	}
+	return text
+}
+
+// Repair is a string changed by RepairJSON.
+type Repair struct {
+	Path   string // e.g. "[0].locale.currencySymbol", see memberPath
+	Before string
+	After  string
+}
+
+// stringPatcher walks a valid JSON document and replaces the strings
+// rewrite changes where they are, so the rest of the document keeps its
+// layout, key order and escapes.
+type stringPatcher struct {
+	data    []byte
+	pos     int
+	rewrite func(path, text string) string
+	patched bytes.Buffer
+	copied  int // how much of data is in patched
+}
+
+func (p *stringPatcher) skipSpace() {
+	for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// text reads the string at pos.
+func (p *stringPatcher) text() string {
+	start := p.pos
+	for p.pos++; p.data[p.pos] != '"'; p.pos++ {
+		if p.data[p.pos] == '\\' {
+			p.pos++
+		}
+	}
+	p.pos++
+	var text string
+	json.Unmarshal(p.data[start:p.pos], &text)
+	return text
+}
+
+// memberPath returns the path of the member key of the object at path.
+// Keys which are empty or hold a character of the path syntax are quoted,
+// e.g. `[0]["a.b"]`, so no two members share a path.
+func memberPath(path, key string) string {
+	switch {
+	case key == "" || strings.ContainsAny(key, `.[]"\`):
+		return path + "[" + strconv.Quote(key) + "]"
+	case path == "":
+		return key
+	}
+	return path + "." + key
+}
+
+// value walks the value at pos, whose path is path.
+func (p *stringPatcher) value(path string) error {
+	p.skipSpace()
+	switch p.data[p.pos] {
+	case '{':
+		for p.pos++; ; {
+			p.skipSpace()
+			if p.data[p.pos] == '}' {
+				p.pos++
+				return nil
+			}
+			if p.data[p.pos] == ',' {
+				p.pos++
+				p.skipSpace()
+			}
+			key := p.text()
+			p.skipSpace()
+			p.pos++ // the colon
+			if err := p.value(memberPath(path, key)); err != nil {
+				return err
+			}
+		}
+	case '[':
+		p.pos++
+		for i := 0; ; i++ {
+			p.skipSpace()
+			if p.data[p.pos] == ']' {
+				p.pos++
+				return nil
+			}
+			if p.data[p.pos] == ',' {
+				p.pos++
+			}
+			if err := p.value(path + "[" + strconv.Itoa(i) + "]"); err != nil {
+				return err
+			}
+		}
+	case '"':
+		start := p.pos
+		text := p.text()
+		rewritten := p.rewrite(path, text)
+		if rewritten == text {
+			return nil
+		}
+		p.patched.Write(p.data[p.copied:start])
+		encoder := json.NewEncoder(&p.patched)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(rewritten); err != nil {
+			return err
+		}
+		p.patched.Truncate(p.patched.Len() - 1) // the newline
+		p.copied = p.pos
+	default:
+		for p.pos < len(p.data) && strings.IndexByte(",]} \t\r\n", p.data[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+	return nil
+}
+
+// rewriteJSON applies rewrite to the strings of a JSON document, but not
+// to its keys. Only the strings it changes are written anew; the document
+// is otherwise returned as it was.
+func rewriteJSON(data []byte, rewrite func(path, text string) string) ([]byte, error) {
+	// The patcher expects a valid document; decoding it tells what is
+	// wrong with an invalid one.
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	p := &stringPatcher{data: data, rewrite: rewrite}
+	if err := p.value(""); err != nil {
+		return nil, err
+	}
+	if p.copied == 0 {
+		return data, nil
+	}
+	p.patched.Write(data[p.copied:])
+	return p.patched.Bytes(), nil
+}
+
+// RepairJSON repairs the mojibake of the strings of a JSON document and
+// returns the repairs by path.
+func RepairJSON(data []byte) ([]byte, []Repair, error) {
+	var repairs []Repair
+	repaired, err := rewriteJSON(data, func(path, text string) string {
+		fixed := RepairText(text)
+		if fixed != text {
+			repairs = append(repairs, Repair{path, text, fixed})
+		}
+		return fixed
+	})
+	sort.Slice(repairs, func(i, j int) bool { return repairs[i].Path < repairs[j].Path })
+	return repaired, repairs, err
+}
+
+// RevertJSON undoes repairs of RepairJSON. Strings changed since are left
+// alone and reported as an error.
+func RevertJSON(data []byte, repairs []Repair) ([]byte, error) {
+	byPath := make(map[string]Repair, len(repairs))
+	for _, repair := range repairs {
+		byPath[repair.Path] = repair
+	}
+	var changed []string
+	reverted, err := rewriteJSON(data, func(path, text string) string {
+		repair, ok := byPath[path]
+		if !ok {
+			return text
+		}
+		if text != repair.After {
+			changed = append(changed, path)
+			return text
+		}
+		return repair.Before
+	})
+	if err == nil && len(changed) > 0 {
+		sort.Strings(changed)
+		err = fmt.Errorf("changed since repaired, not reverted: %s", strings.Join(changed, ", "))
+	}
+	return reverted, err
+}
+
+// Import reads listings like Decode, repairing their mojibake first.
+func Import(reader io.Reader) ([]*Listing, []Repair, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, repairs, err := RepairJSON(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	listings, err := Decode(bytes.NewReader(data))
+	return listings, repairs, err
+}
+
+// ImportFile imports the listings of a file.
+func ImportFile(filename string) ([]*Listing, []Repair, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	listings, repairs, err := Import(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return listings, repairs, nil
+}
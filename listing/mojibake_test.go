@@ -0,0 +1,112 @@
+package listing
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRepairText(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"plain ASCII", "plain ASCII"},
+		{"Â£", "£"},
+		{"donâ€™t", "don’t"},
+		{"CafÃ©", "Café"},
+		{"CafÃƒÂ©", "Café"}, // garbled twice
+		{"Café", "Café"},    // already right
+		{"£5 – €6", "£5 – €6"},
+		{"Ã", "Ã"}, // no valid UTF-8 behind it
+		{"æ—¥æœ¬", "日本"},
+	}
+	for _, test := range tests {
+		if got := RepairText(test.text); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestRepairJSONKeepsLayout(t *testing.T) {
+	document := "[\n  {\"title\": \"CafÃ© \\u003cmenu\\u003e\",\n   \"locale\": {\"currencySymbol\": \"Â£\", \"z\": 1, \"a\": [true, null, \"ok\"]}}\n]\n"
+	repaired, repairs, err := RepairJSON([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[\n  {\"title\": \"Café <menu>\",\n   \"locale\": {\"currencySymbol\": \"£\", \"z\": 1, \"a\": [true, null, \"ok\"]}}\n]\n"
+	if string(repaired) != want {
+		t.Errorf("got\n%s\nwant\n%s", repaired, want)
+	}
+	if got := fmt.Sprint(repairs); got != "[{[0].locale.currencySymbol Â£ £} {[0].title CafÃ© <menu> Café <menu>}]" {
+		t.Errorf("repairs %s", got)
+	}
+
+	reverted, err := RevertJSON(repaired, repairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reverted) != "[\n  {\"title\": \"CafÃ© <menu>\",\n   \"locale\": {\"currencySymbol\": \"Â£\", \"z\": 1, \"a\": [true, null, \"ok\"]}}\n]\n" {
+		t.Errorf("reverted to\n%s", reverted)
+	}
+
+	unchanged := []byte(`{"a": "fine"}`)
+	if got, repairs, _ := RepairJSON(unchanged); string(got) != string(unchanged) || len(repairs) != 0 {
+		t.Errorf("a clean document became %s", got)
+	}
+	if _, _, err = RepairJSON([]byte(`{"a": `)); err == nil {
+		t.Error("invalid JSON was accepted")
+	}
+}
+
+func TestRevertJSONLeavesChangedStrings(t *testing.T) {
+	repairs := []Repair{{Path: "a", Before: "Â£", After: "£"}}
+	reverted, err := RevertJSON([]byte(`{"a": "$"}`), repairs)
+	if err == nil || string(reverted) != `{"a": "$"}` {
+		t.Errorf("got %s, %v", reverted, err)
+	}
+}
+
+func TestRepairJSONPathsOfOddKeys(t *testing.T) {
+	document := `{"a.b": "Â£1", "a": {"b": "Â£2"}, "c[0]": "Â£3", "c": ["Â£4"], "": {"q\"": "Â£5"}}`
+	repaired, repairs, err := RepairJSON([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, repair := range repairs {
+		paths = append(paths, repair.Path)
+	}
+	if got, want := strings.Join(paths, " "), `[""]["q\""] ["a.b"] ["c[0]"] a.b c[0]`; got != want {
+		t.Errorf("got paths %s, want %s", got, want)
+	}
+
+	// Only the string repaired as "a" > "b" is reverted.
+	reverted, err := RevertJSON(repaired, []Repair{repairs[3]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"a.b": "£1", "a": {"b": "Â£2"}, "c[0]": "£3", "c": ["£4"], "": {"q\"": "£5"}}`; string(reverted) != want {
+		t.Errorf("got %s, want %s", reverted, want)
+	}
+}
+
+func TestMemberPath(t *testing.T) {
+	tests := []struct {
+		path, key, want string
+	}{
+		{"", "title", "title"},
+		{"[0]", "locale", "[0].locale"},
+		{"[0].locale", "currencySymbol", "[0].locale.currencySymbol"},
+		{"", "a.b", `["a.b"]`},
+		{"[0]", "x[1]", `[0]["x[1]"]`},
+		{"a", `say "hi"`, `a["say \"hi\""]`},
+		{"a", `back\slash`, `a["back\\slash"]`},
+		{"a", "", `a[""]`},
+		{"a", "£", "a.£"},
+	}
+	for _, test := range tests {
+		if got := memberPath(test.path, test.key); got != test.want {
+			t.Errorf("%q, %q: got %s, want %s", test.path, test.key, got, test.want)
+		}
+	}
+}
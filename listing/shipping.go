@@ -0,0 +1,148 @@
+/**
+ * Shipping quotes and delivery estimates.
+ *
+ * An option costs ShippingCost for the first unit and the additional cost
+ * for every further one. The parcel leaves within HandlingTimeMax working
+ * days of the order and takes DispatchTimeMin to DispatchTimeMax working
+ * days to arrive, so the earliest delivery assumes it leaves the day it is
+ * ordered. Nothing is delivered on Saturdays and Sundays.
+ */
+
+package listing
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/icodebb/go-play-ground/num"
+)
+
+// ParseAdditionalCost parses ShippingAdditionalCost, which is text such as
+// "2", "2.50", "£2.50", "2,50" or "1.234,50". The separators follow
+// num.ParseAmount, so "2,500" is ambiguous and refused. Empty text costs
+// nothing.
+func ParseAdditionalCost(text string) (float64, error) {
+	cleaned := strings.TrimFunc(text, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.' && r != ',' && r != '-'
+	})
+	if cleaned == "" {
+		if strings.TrimSpace(text) == "" {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("invalid additional cost %q", text)
+	}
+	cost, err := num.ParseAmount(cleaned)
+	if err != nil {
+		return 0, fmt.Errorf("additional cost %q: %v", text, err)
+	}
+	if cost < 0 {
+		return 0, fmt.Errorf("invalid additional cost %q", text)
+	}
+	return cost, nil
+}
+
+// Cost returns what sending quantity units costs.
+func (shipping Shipping) Cost(quantity int) (float64, error) {
+	if quantity < 1 {
+		return 0, errors.New("quantity must be positive")
+	}
+	if shipping.ShippingCost < 0 {
+		return 0, fmt.Errorf("%s: negative shipping cost", shipping.ShippingService)
+	}
+	additional, err := ParseAdditionalCost(shipping.ShippingAdditionalCost)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", shipping.ShippingService, err)
+	}
+	return shipping.ShippingCost + float64(quantity-1)*additional, nil
+}
+
+func isWeekend(t time.Time) bool {
+	return t.Weekday() == time.Saturday || t.Weekday() == time.Sunday
+}
+
+// AddWorkingDays returns the date n working days after t. A t on a weekend
+// counts from the Monday after.
+func AddWorkingDays(t time.Time, n int) time.Time {
+	for isWeekend(t) {
+		t = t.AddDate(0, 0, 1)
+	}
+	for n > 0 {
+		t = t.AddDate(0, 0, 1)
+		if !isWeekend(t) {
+			n--
+		}
+	}
+	return t
+}
+
+// Quote is what an option costs and when it delivers.
+type Quote struct {
+	Option           Shipping
+	Quantity         int
+	Cost             float64
+	Earliest, Latest time.Time
+}
+
+// Quote prices the option for quantity units ordered at ordered.
+func (shipping Shipping) Quote(quantity int, ordered time.Time) (Quote, error) {
+	cost, err := shipping.Cost(quantity)
+	if err != nil {
+		return Quote{}, err
+	}
+	if shipping.DispatchTimeMin < 0 || shipping.DispatchTimeMax < shipping.DispatchTimeMin ||
+		shipping.HandlingTimeMax < 0 {
+		return Quote{}, fmt.Errorf("%s: invalid handling or dispatch times", shipping.ShippingService)
+	}
+	return Quote{
+		Option:   shipping,
+		Quantity: quantity,
+		Cost:     cost,
+		Earliest: AddWorkingDays(ordered, shipping.DispatchTimeMin),
+		Latest:   AddWorkingDays(ordered, shipping.HandlingTimeMax+shipping.DispatchTimeMax),
+	}, nil
+}
+
+// Quotes prices every shipping option of the listing. Options which
+// cannot be priced are left out and their errors returned.
+func (listing *Listing) Quotes(quantity int, ordered time.Time) ([]Quote, []error) {
+	var quotes []Quote
+	var errs []error
+	for _, option := range listing.Shipping {
+		quote, err := option.Quote(quantity, ordered)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		quotes = append(quotes, quote)
+	}
+	return quotes, errs
+}
+
+// Cheapest orders the quotes by cost, then by latest delivery.
+func Cheapest(quotes []Quote) {
+	sort.SliceStable(quotes, func(i, j int) bool {
+		if quotes[i].Cost != quotes[j].Cost {
+			return quotes[i].Cost < quotes[j].Cost
+		}
+		return quotes[i].Latest.Before(quotes[j].Latest)
+	})
+}
+
+// Fastest orders the quotes by latest delivery, then by earliest
+// delivery and cost.
+func Fastest(quotes []Quote) {
+	sort.SliceStable(quotes, func(i, j int) bool {
+		a, b := quotes[i], quotes[j]
+		switch {
+		case !a.Latest.Equal(b.Latest):
+			return a.Latest.Before(b.Latest)
+		case !a.Earliest.Equal(b.Earliest):
+			return a.Earliest.Before(b.Earliest)
+		}
+		return a.Cost < b.Cost
+	})
+}
@@ -0,0 +1,138 @@
+package listing
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAdditionalCost(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+		err  string
+	}{
+		{"", 0, ""},
+		{"  ", 0, ""},
+		{"2", 2, ""},
+		{"2.50", 2.5, ""},
+		{"£2.50", 2.5, ""},
+		{"2,50 EUR", 2.5, ""},
+		{"1.234,50", 1234.5, ""},
+		{"1,234.50", 1234.5, ""},
+		{"$1,234,567", 1234567, ""},
+		{"2,500", 0, "ambiguous"},
+		{"free", 0, "invalid"},
+		{"1,2,3", 0, "invalid"},
+		{"-2", 0, "invalid"},
+	}
+	for _, test := range tests {
+		got, err := ParseAdditionalCost(test.text)
+		switch {
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%q: got %v, %v, want an error with %q", test.text, got, err, test.err)
+		case test.err == "" && (err != nil || got != test.want):
+			t.Errorf("%q: got %v, %v, want %v", test.text, got, err, test.want)
+		}
+	}
+}
+
+func day(date string) time.Time {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestAddWorkingDays(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		days int
+		want string
+	}{
+		{"zero days on a weekday", "2020-01-03", 0, "2020-01-03"},
+		{"zero days on a Saturday", "2020-01-04", 0, "2020-01-06"},
+		{"Friday to Monday", "2020-01-03", 1, "2020-01-06"},
+		{"over a weekend", "2020-01-02", 2, "2020-01-06"},
+		{"from a Sunday", "2020-01-05", 1, "2020-01-07"},
+		{"a whole week", "2020-01-06", 5, "2020-01-13"},
+	}
+	for _, test := range tests {
+		got := AddWorkingDays(day(test.from), test.days)
+		if !got.Equal(day(test.want)) {
+			t.Errorf("%s: got %s, want %s", test.name, got.Format("2006-01-02"), test.want)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	option := Shipping{
+		ShippingService:        "post",
+		ShippingCost:           5,
+		HandlingTimeMax:        1,
+		DispatchTimeMin:        2,
+		DispatchTimeMax:        3,
+		ShippingAdditionalCost: "1.50",
+	}
+	quote, err := option.Quote(3, day("2020-01-03"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Cost != 8 || !quote.Earliest.Equal(day("2020-01-07")) || !quote.Latest.Equal(day("2020-01-09")) {
+		t.Errorf("got cost %v, %s to %s, want 8, 2020-01-07 to 2020-01-09",
+			quote.Cost, quote.Earliest.Format("2006-01-02"), quote.Latest.Format("2006-01-02"))
+	}
+
+	tests := []struct {
+		name     string
+		change   func(*Shipping)
+		quantity int
+		err      string
+	}{
+		{"no units", func(*Shipping) {}, 0, "quantity"},
+		{"negative cost", func(s *Shipping) { s.ShippingCost = -1 }, 1, "negative shipping cost"},
+		{"ambiguous additional cost", func(s *Shipping) { s.ShippingAdditionalCost = "2,500" }, 1, "ambiguous"},
+		{"dispatch max below min", func(s *Shipping) { s.DispatchTimeMax = 1 }, 1, "dispatch times"},
+		{"negative handling", func(s *Shipping) { s.HandlingTimeMax = -1 }, 1, "dispatch times"},
+	}
+	for _, test := range tests {
+		broken := option
+		test.change(&broken)
+		if _, err := broken.Quote(test.quantity, day("2020-01-03")); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got %v, want an error with %q", test.name, err, test.err)
+		}
+	}
+}
+
+func services(quotes []Quote) string {
+	var names []string
+	for _, quote := range quotes {
+		names = append(names, quote.Option.ShippingService)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestCheapestAndFastest(t *testing.T) {
+	quote := func(service string, cost float64, earliest, latest string) Quote {
+		return Quote{Option: Shipping{ShippingService: service}, Cost: cost,
+			Earliest: day(earliest), Latest: day(latest)}
+	}
+	quotes := []Quote{
+		quote("courier", 12, "2020-01-06", "2020-01-06"),
+		quote("post", 3, "2020-01-07", "2020-01-10"),
+		quote("tracked", 3, "2020-01-07", "2020-01-08"),
+		quote("express", 8, "2020-01-06", "2020-01-06"),
+		quote("economy", 2, "2020-01-08", "2020-01-15"),
+	}
+
+	Cheapest(quotes)
+	if got, want := services(quotes), "economy,tracked,post,express,courier"; got != want {
+		t.Errorf("Cheapest: got %s, want %s", got, want)
+	}
+	Fastest(quotes)
+	if got, want := services(quotes), "express,courier,tracked,post,economy"; got != want {
+		t.Errorf("Fastest: got %s, want %s", got, want)
+	}
+}
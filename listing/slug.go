@@ -0,0 +1,260 @@
+/**
+ * Slugs for TitleSlug.
+ *
+ * Slug lower cases a title and spells it in ASCII where it can: accents
+ * are dropped, letters such as ß and æ are spelt out, fullwidth forms are
+ * narrowed, and Cyrillic, Japanese kana and Korean hangul are romanized.
+ * Letters with no such spelling, Chinese characters among them, are kept.
+ * Words are joined by hyphens without the stop words, and the slug is cut
+ * at a hyphen to fit the maximum length.
+ */
+
+package listing
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxSlugLength is the default maximum length of slugs, in bytes.
+const MaxSlugLength = 60
+
+// StopWords are left out of slugs unless the title has nothing else.
+var StopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"by": true, "for": true, "from": true, "in": true, "is": true, "of": true,
+	"on": true, "or": true, "the": true, "to": true, "with": true,
+}
+
+// latin spells the lower case letters from U+00C0 to U+024F without their
+// accents; '.' marks letters spelt by special or not at all.
+const latin = "aaaaaa.ceeeeiiii.nooooo..uuuuy..aaaaaa.ceeeeiiii.nooooo..uuuuy.y" +
+	"aaaaaaccccccccdd..eeeeeeeeeegggggggghh..iiiiiiiii...jjkk.llllll." +
+	"...nnnnnn...oooooo..rrrrrrsssssssstttt..uuuuuuuuuuuuwwyyyzzzzzzs" +
+	"................................oo.............uu..............." +
+	".............aaiioouuuuuuuuuu.aaaa....ggkkoooo..j...gg..nnaa...." +
+	"aaaaeeeeiiiioooorrrruuuusstt..hh......aaeeooooooooyy............" +
+	"................"
+
+// latinExtended does the same for U+1E00 to U+1EFF, e.g. Vietnamese.
+const latinExtended = "aabbbbbbccddddddddddeeeeeeeeeeffgghhhhhhhhhhiiiikkkkkkllllllllmm" +
+	"mmmmnnnnnnnnoooooooopppprrrrrrrrssssssssssttttttttuuuuuuuuuuvvvv" +
+	"wwwwwwwwwwxxxxyyzzzzzzhtwy.s....aaaaaaaaaaaaaaaaaaaaaaaaeeeeeeee" +
+	"eeeeeeeeiiiioooooooooooooooooooooooouuuuuuuuuuuuuuyyyyyyyy......"
+
+var special = map[rune]string{
+	'æ': "ae", 'ð': "d", 'ø': "o", 'þ': "th", 'ß': "ss", 'đ': "d", 'ħ': "h",
+	'ı': "i", 'ĳ': "ij", 'ł': "l", 'ŋ': "ng", 'œ': "oe", 'ŧ': "t", 'ſ': "s",
+	'ﬀ': "ff", 'ﬁ': "fi", 'ﬂ': "fl", 'ﬃ': "ffi", 'ﬄ': "ffl", 'ﬅ': "st", 'ﬆ': "st",
+}
+
+// cyrillic romanizes Russian, Ukrainian and Belarusian letters.
+var cyrillic = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", 'є': "ye", 'і': "i",
+	'ї': "yi", 'ґ': "g", 'ў': "u",
+}
+
+// kana romanizes hiragana from U+3041 (Hepburn); katakana are 0x60
+// further on. "-" is the small tsu, which doubles the next consonant.
+var kana = strings.Fields("a a i i u u e e o o ka ga ki gi ku gu " +
+	"ke ge ko go sa za shi ji su zu se ze so zo ta da " +
+	"chi ji - tsu zu te de to do na ni nu ne no ha ba " +
+	"pa hi bi pi fu bu pu he be pe ho bo po ma mi mu " +
+	"me mo ya ya yu yu yo yo ra ri ru re ro wa wa i " +
+	"e o n vu ka ke")
+
+// The small ya, yu and yo, which join the kana before them, e.g. kya.
+const (
+	smallYa = 'ゃ'
+	smallYu = 'ゅ'
+	smallYo = 'ょ'
+)
+
+// The parts of hangul syllables (Revised Romanization), finals as
+// pronounced at the end of a syllable.
+var (
+	hangulInitials = strings.Split("g,kk,n,d,tt,r,m,b,pp,s,ss,,j,jj,ch,k,t,p,h", ",")
+	hangulMedials  = strings.Split("a,ae,ya,yae,eo,e,yeo,ye,o,wa,wae,oe,yo,u,wo,we,wi,yu,eu,ui,i", ",")
+	hangulFinals   = strings.Split(",k,k,k,n,n,n,t,l,k,m,l,l,l,p,l,m,p,p,t,t,ng,t,t,k,t,p,t", ",")
+)
+
+// slugWriter spells the words of a title.
+type slugWriter struct {
+	words  []string
+	word   strings.Builder
+	double bool // after a small tsu
+}
+
+func (w *slugWriter) end() {
+	if w.word.Len() > 0 {
+		w.words = append(w.words, w.word.String())
+		w.word.Reset()
+	}
+	w.double = false
+}
+
+func (w *slugWriter) write(text string) {
+	if w.double && text != "" && !strings.ContainsAny(text[:1], "aeiou") {
+		if strings.HasPrefix(text, "ch") {
+			w.word.WriteByte('t')
+		} else {
+			w.word.WriteByte(text[0])
+		}
+	}
+	w.double = false
+	w.word.WriteString(text)
+}
+
+// writeKana romanizes a kana, katakana taken as hiragana.
+func (w *slugWriter) writeKana(r rune) {
+	if r >= 'ァ' {
+		r -= 0x60
+	}
+	switch spelt := w.word.String(); {
+	case kana[r-'ぁ'] == "-":
+		w.double = true
+	case (r == smallYa || r == smallYu || r == smallYo) && strings.HasSuffix(spelt, "i") && len(spelt) > 1:
+		// ki + small ya is kya, shi + small ya is sha.
+		spelt = spelt[:len(spelt)-1]
+		if !strings.HasSuffix(spelt, "sh") && !strings.HasSuffix(spelt, "ch") && !strings.HasSuffix(spelt, "j") {
+			spelt += "y"
+		}
+		w.word.Reset()
+		w.word.WriteString(spelt + kana[r-'ぁ'][1:])
+	default:
+		w.write(kana[r-'ぁ'])
+	}
+}
+
+func (w *slugWriter) writeRune(r rune) {
+	if r >= '！' && r <= '～' {
+		r -= 0xFEE0 // fullwidth forms
+	}
+	if spelt, ok := special[r]; ok {
+		w.write(spelt)
+		return
+	}
+	if spelt, ok := cyrillic[r]; ok {
+		w.write(spelt)
+		return
+	}
+	switch {
+	case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+		w.write(string(r))
+	case r == '\'' || r == '’' || unicode.Is(unicode.Mn, r):
+		// Apostrophes join, "men's" is "mens", and marks are dropped.
+	case r >= 0xC0 && r < 0xC0+rune(len(latin)) && latin[r-0xC0] != '.':
+		w.write(latin[r-0xC0 : r-0xC0+1])
+	case r >= 0x1E00 && r < 0x1E00+rune(len(latinExtended)) && latinExtended[r-0x1E00] != '.':
+		w.write(latinExtended[r-0x1E00 : r-0x1E00+1])
+	case r >= 'ぁ' && r <= 'ゖ' || r >= 'ァ' && r <= 'ヶ':
+		w.writeKana(r)
+	case r == 'ー':
+		// The long vowel mark is left out.
+	case r >= 0xAC00 && r <= 0xD7A3:
+		s := int(r - 0xAC00)
+		w.write(hangulInitials[s/588] + hangulMedials[s%588/28] + hangulFinals[s%28])
+	case unicode.IsLetter(r) || unicode.IsDigit(r):
+		w.write(string(r))
+	default:
+		w.end()
+	}
+}
+
+// Slug returns the slug of a title, at most maxLength bytes long; 0 means
+// MaxSlugLength. A single word too long is cut at a letter.
+func Slug(title string, maxLength int) string {
+	if maxLength <= 0 {
+		maxLength = MaxSlugLength
+	}
+	var w slugWriter
+	for _, r := range strings.ToLower(title) {
+		w.writeRune(r)
+	}
+	w.end()
+	var kept []string
+	for _, word := range w.words {
+		if !StopWords[word] {
+			kept = append(kept, word)
+		}
+	}
+	if len(kept) == 0 {
+		kept = w.words
+	}
+	return cutSlug(strings.Join(kept, "-"), maxLength)
+}
+
+// cutSlug cuts slug to maxLength bytes, at the last hyphen that fits if
+// there is one.
+func cutSlug(slug string, maxLength int) string {
+	switch {
+	case len(slug) <= maxLength:
+		return slug
+	case maxLength <= 0:
+		return ""
+	}
+	if i := strings.LastIndexByte(slug[:maxLength+1], '-'); i > 0 {
+		return slug[:i]
+	}
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(slug[cut]) {
+		cut--
+	}
+	return slug[:cut]
+}
+
+// UniqueSlug returns slug, or slug with the lowest suffix "-2", "-3", ...
+// which is not taken, cut to fit maxLength.
+func UniqueSlug(slug string, maxLength int, taken func(string) bool) string {
+	if maxLength <= 0 {
+		maxLength = MaxSlugLength
+	}
+	if !taken(slug) {
+		return slug
+	}
+	for n := 2; ; n++ {
+		suffix := "-" + strconv.Itoa(n)
+		base := slug
+		if len(base)+len(suffix) > maxLength {
+			base = strings.TrimRight(cutSlug(base, maxLength-len(suffix)), "-")
+		}
+		if candidate := base + suffix; !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// AssignSlugs gives the listings without a TitleSlug, those whose slug an
+// earlier listing has, or all of them with regenerate, the slug of their
+// title, unique among the listings, and returns how many were changed.
+func AssignSlugs(listings []*Listing, maxLength int, regenerate bool) int {
+	taken := make(map[string]bool)
+	var pending []*Listing
+	for _, listing := range listings {
+		if regenerate || listing.TitleSlug == "" || taken[listing.TitleSlug] {
+			pending = append(pending, listing)
+			continue
+		}
+		taken[listing.TitleSlug] = true
+	}
+	changed := 0
+	for _, listing := range pending {
+		slug := Slug(listing.Title, maxLength)
+		if slug == "" {
+			slug = "listing"
+		}
+		slug = UniqueSlug(slug, maxLength, func(slug string) bool { return taken[slug] })
+		taken[slug] = true
+		if slug != listing.TitleSlug {
+			listing.TitleSlug = slug
+			changed++
+		}
+	}
+	return changed
+}
@@ -0,0 +1,99 @@
+package listing
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSlug(t *testing.T) {
+	tests := []struct {
+		title     string
+		maxLength int
+		want      string
+	}{
+		{"The Red Shoe", 0, "red-shoe"},
+		{"iPhone 12 Pro", 0, "iphone-12-pro"},
+		{"Men's Shoes & Socks", 0, "mens-shoes-socks"},
+		{"Crème Brûlée", 0, "creme-brulee"},
+		{"Straße Æsir", 0, "strasse-aesir"},
+		{"Tiếng Việt", 0, "tieng-viet"},
+		{"ＦＵＬＬ width", 0, "full-width"},
+		{"Привет мир", 0, "privet-mir"},
+		{"ひらがな カタカナ", 0, "hiragana-katakana"},
+		{"きゃりー ぱみゅぱみゅ", 0, "kyari-pamyupamyu"},
+		{"ちょっと", 0, "chotto"},
+		{"한국어", 0, "hangukeo"},
+		{"中文 标题", 0, "中文-标题"},
+		{"The and of", 0, "the-and-of"},
+		{"!!!", 0, ""},
+		{"alpha beta gamma delta", 15, "alpha-beta"},
+		{"supercalifragilistic", 10, "supercalif"},
+		{"日本語", 7, "日本"},
+	}
+	for _, test := range tests {
+		if got := Slug(test.title, test.maxLength); got != test.want {
+			t.Errorf("Slug(%q, %d) = %q, want %q", test.title, test.maxLength, got, test.want)
+		}
+	}
+}
+
+func TestUniqueSlug(t *testing.T) {
+	tests := []struct {
+		slug      string
+		maxLength int
+		taken     []string
+		want      string
+	}{
+		{"shoe", 0, nil, "shoe"},
+		{"shoe", 0, []string{"shoe"}, "shoe-2"},
+		{"shoe", 0, []string{"shoe", "shoe-2", "shoe-3"}, "shoe-4"},
+		{"alpha-beta", 10, []string{"alpha-beta"}, "alpha-2"},
+		{"abcdefghij", 10, []string{"abcdefghij"}, "abcdefgh-2"},
+	}
+	for _, test := range tests {
+		taken := make(map[string]bool)
+		for _, slug := range test.taken {
+			taken[slug] = true
+		}
+		got := UniqueSlug(test.slug, test.maxLength, func(slug string) bool { return taken[slug] })
+		if got != test.want {
+			t.Errorf("UniqueSlug(%q, %d) with %v taken = %q, want %q", test.slug, test.maxLength, test.taken, got, test.want)
+		}
+	}
+}
+
+func TestAssignSlugs(t *testing.T) {
+	tests := []struct {
+		name       string
+		titles     []string
+		slugs      []string
+		regenerate bool
+		want       []string
+		changed    int
+	}{
+		{"new", []string{"Red Shoe", "Red Shoe", "!!!"}, []string{"", "", ""}, false,
+			[]string{"red-shoe", "red-shoe-2", "listing"}, 3},
+		{"kept", []string{"Red Shoe", "Blue Shoe"}, []string{"shoe", ""}, false,
+			[]string{"shoe", "blue-shoe"}, 1},
+		{"duplicate", []string{"Red Shoe", "Blue Shoe"}, []string{"shoe", "shoe"}, false,
+			[]string{"shoe", "blue-shoe"}, 1},
+		{"regenerate", []string{"Red Shoe", "Blue Shoe"}, []string{"shoe", "blue-shoe"}, true,
+			[]string{"red-shoe", "blue-shoe"}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var listings []*Listing
+			for i, title := range test.titles {
+				listings = append(listings, &Listing{Title: title, TitleSlug: test.slugs[i]})
+			}
+			changed := AssignSlugs(listings, 0, test.regenerate)
+			var got []string
+			for _, listing := range listings {
+				got = append(got, listing.TitleSlug)
+			}
+			if changed != test.changed || fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("changed %d to %v, want %d to %v", changed, got, test.changed, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+/**
+ * Commands on marketplace listing files, see the listing package.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icodebb/go-play-ground/listing"
+	log "github.com/sirupsen/logrus"
+)
+
+// unknownKeys returns the keys the listing kept in Extra maps, with the
+// path of the object holding them.
+func unknownKeys(l *listing.Listing) []string {
+	var keys []string
+	add := func(path string, extra listing.Extra) {
+		for key := range extra {
+			keys = append(keys, path+key)
+		}
+	}
+	add("", l.Extra)
+	add("locale.", l.Locale.Extra)
+	add("locale.locationReadable.", l.Locale.LocationReadable.Extra)
+	add("media.", l.Media.Extra)
+	add("payment.", l.Payment.Extra)
+	for i, method := range l.Payment.Online {
+		add(fmt.Sprintf("payment.online[%d].", i), method.Extra)
+	}
+	for i, method := range l.Payment.Offline {
+		add(fmt.Sprintf("payment.offline[%d].", i), method.Extra)
+	}
+	for i, shipping := range l.Shipping {
+		add(fmt.Sprintf("shipping[%d].", i), shipping.Extra)
+	}
+	for i, variation := range l.Variations {
+		add(fmt.Sprintf("variations[%d].", i), variation.Extra)
+		add(fmt.Sprintf("variations[%d].media.", i), variation.Media.Extra)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkRoundTrip encodes each listing again and compares it with the
+// document it was decoded from.
+func checkRoundTrip(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var documents []json.RawMessage
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		documents = []json.RawMessage{trimmed}
+	} else if err = json.Unmarshal(data, &documents); err != nil {
+		return err
+	}
+	for i, document := range documents {
+		var l listing.Listing
+		if err = json.Unmarshal(document, &l); err != nil {
+			return fmt.Errorf("listing %d: %v", i, err)
+		}
+		encoded, err := json.Marshal(l)
+		if err != nil {
+			return fmt.Errorf("listing %d: %v", i, err)
+		}
+		same, err := listing.Equivalent(document, encoded)
+		if err != nil {
+			return fmt.Errorf("listing %d: %v", i, err)
+		}
+		if !same {
+			return fmt.Errorf("listing %d changes when encoded again", i)
+		}
+	}
+	return nil
+}
+
+func listingsCommand(args []string) error {
+	flags := flag.NewFlagSet("listings", flag.ContinueOnError)
+	check := flags.Bool("check", false, "check that encoding again loses nothing")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	for _, filename := range flags.Args() {
+		listings, _, err := readListingFile(filename)
+		if err != nil {
+			return err
+		}
+		for _, l := range listings {
+			fmt.Printf("%s: %q by %s, %s, %s, %d variations, %s to %s\n", filename, l.Title, l.SellerId,
+				l.Status(), l.ListingType, len(l.Variations), l.StartTime.Format(dateFormat), l.EndTime.Format(dateFormat))
+			if keys := unknownKeys(l); len(keys) > 0 {
+				fmt.Printf("    unknown fields kept: %s\n", strings.Join(keys, ", "))
+			}
+		}
+		if *check {
+			if err = checkRoundTrip(filename); err != nil {
+				return fmt.Errorf("%s: %v", filename, err)
+			}
+			log.Infof("%s: encodes again without loss", filename)
+		}
+	}
+	return nil
+}
+
+// replaceListingFile writes the listings next to filename and renames the
+// file over it, like replaceInvoiceFile.
+func replaceListingFile(filename string, listings []*listing.Listing) error {
+	temp := filename + ".tmp"
+	if err := listing.WriteFile(temp, listings); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, filename)
+}
+
+func printEvent(l *listing.Listing, event listing.Event) {
+	how := "by hand"
+	if event.Auto {
+		how = "by the clock"
+	}
+	fmt.Printf("%s  %q  %s -> %s %s", event.At.Format(time.RFC3339), l.Title, event.From, event.To, how)
+	if event.Reason != "" {
+		fmt.Printf(" (%s)", event.Reason)
+	}
+	fmt.Println()
+}
+
+// lifecycleCommand runs "advance", "set" and "history" on a listing file.
+// Changes are written back to the file.
+func lifecycleCommand(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	flags := flag.NewFlagSet("lifecycle "+args[0], flag.ContinueOnError)
+	now := flags.String("now", "", "RFC 3339 time to use instead of the clock")
+	reason := flags.String("reason", "", "why the status is changed")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	lifecycle := &listing.Lifecycle{}
+	if *now != "" {
+		at, err := time.Parse(time.RFC3339, *now)
+		if err != nil {
+			return err
+		}
+		lifecycle.Now = func() time.Time { return at }
+	}
+	lifecycle.OnTransition(printEvent)
+	filename := flags.Arg(0)
+	listings, repairs, err := readListingFile(filename)
+	if err != nil {
+		return err
+	}
+	// nth returns the listing of the argument, counting from 1.
+	nth := func(arg string) (*listing.Listing, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(listings) {
+			return nil, fmt.Errorf("listing %q not in 1..%d", arg, len(listings))
+		}
+		return listings[n-1], nil
+	}
+	switch {
+	case args[0] == "advance" && flags.NArg() == 1:
+		changed := 0
+		for _, l := range listings {
+			changed += len(lifecycle.Advance(l))
+		}
+		if changed == 0 {
+			log.Infof("%s: nothing to change", filename)
+			return nil
+		}
+	case args[0] == "set" && flags.NArg() == 3:
+		l, err := nth(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		if _, err = lifecycle.Change(l, listing.Status(flags.Arg(2)), *reason); err != nil {
+			return err
+		}
+	case args[0] == "history" && flags.NArg() == 2:
+		l, err := nth(flags.Arg(1))
+		if err != nil {
+			return err
+		}
+		for _, event := range l.Events {
+			printEvent(l, event)
+		}
+		fmt.Printf("now %s\n", l.Status())
+		return nil
+	default:
+		return errUsage
+	}
+	return updateListingFile(filename, listings, repairs)
+}
+
+// readListingFiles imports the listings of the files or patterns, with
+// their mojibake repaired.
+func readListingFiles(patterns []string) ([]*listing.Listing, error) {
+	filenames, err := expandGlobs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	var listings []*listing.Listing
+	for _, filename := range filenames {
+		read, _, err := readListingFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		listings = append(listings, read...)
+	}
+	return listings, nil
+}
+
+// readListingFile imports the listings of a file, repairing their
+// mojibake. The repairs are kept by updateListingFile if the listings are
+// written back.
+func readListingFile(filename string) ([]*listing.Listing, []listing.Repair, error) {
+	listings, repairs, err := listing.ImportFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(repairs) > 0 {
+		log.Warnf("%s: %d strings with mojibake repaired, see the repair command", filename, len(repairs))
+	}
+	return listings, repairs, nil
+}
+
+// updateListingFile writes back the listings read by readListingFile. Its
+// repairs are saved first, as the repair command does, so they can still
+// be undone.
+func updateListingFile(filename string, listings []*listing.Listing, repairs []listing.Repair) error {
+	if err := saveRepairs(filename, repairs); err != nil {
+		return err
+	}
+	return replaceListingFile(filename, listings)
+}
+
+func nearCommand(args []string) error {
+	flags := flag.NewFlagSet("near", flag.ContinueOnError)
+	km := flags.Float64("km", 10, "search radius in kilometres")
+	limit := flags.Int("limit", 20, "show at most N listings, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 || *km <= 0 {
+		return errUsage
+	}
+	center, err := listing.ParsePoint(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	listings, err := readListingFiles(flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	index, errs := listing.NewGeoIndex(listings)
+	for i, err := range errs {
+		log.Warnf("listing %q: %v", listings[i].Title, err)
+	}
+	hits := index.Within(center, *km)
+	for i, hit := range hits {
+		if *limit > 0 && i == *limit {
+			fmt.Printf("... and %d more\n", len(hits)-*limit)
+			break
+		}
+		place := hit.Listing.Locale.LocationReadable.String()
+		if place == "" {
+			place = hit.Point.String()
+		}
+		fmt.Printf("%8.2f km  %s  (%s)\n", hit.Distance, hit.Listing.Title, place)
+	}
+	log.Infof("%d of %d listings within %g km of %s", len(hits), index.Len(), *km, center)
+	return nil
+}
+
+func quoteCommand(args []string) error {
+	flags := flag.NewFlagSet("quote", flag.ContinueOnError)
+	quantity := flags.Int("qty", 1, "number of units")
+	ordered := flags.String("date", today().Format(dateFormat), "order date")
+	pick := flags.String("pick", "cheapest", "cheapest or fastest first")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	date, err := time.Parse(dateFormat, *ordered)
+	if err != nil {
+		return err
+	}
+	listings, _, err := readListingFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	n, err := strconv.Atoi(flags.Arg(1))
+	if err != nil || n < 1 || n > len(listings) {
+		return fmt.Errorf("listing %q not in 1..%d", flags.Arg(1), len(listings))
+	}
+	quotes, errs := listings[n-1].Quotes(*quantity, date)
+	for _, err := range errs {
+		log.Warnln(err)
+	}
+	switch *pick {
+	case "cheapest":
+		listing.Cheapest(quotes)
+	case "fastest":
+		listing.Fastest(quotes)
+	default:
+		return errUsage
+	}
+	for _, quote := range quotes {
+		fmt.Printf("%-16s %8.2f  %s to %s\n", quote.Option.ShippingName, quote.Cost,
+			quote.Earliest.Format("Mon 2006-01-02"), quote.Latest.Format("Mon 2006-01-02"))
+	}
+	if len(quotes) == 0 {
+		return errors.New("no shipping option can be quoted")
+	}
+	return nil
+}
+
+// slugsCommand gives listings without a unique title slug one, and prints
+// the slugs of titles given with -title.
+func slugsCommand(args []string) error {
+	flags := flag.NewFlagSet("slugs", flag.ContinueOnError)
+	maxLength := flags.Int("max", listing.MaxSlugLength, "maximum length of slugs in bytes")
+	all := flags.Bool("all", false, "replace the slugs of every listing")
+	dryRun := flags.Bool("n", false, "show the slugs without writing the file")
+	title := flags.Bool("title", false, "the arguments are titles to print the slugs of")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *title {
+		for _, arg := range flags.Args() {
+			fmt.Println(listing.Slug(arg, *maxLength))
+		}
+		return nil
+	}
+	if flags.NArg() != 1 || *maxLength < 1 {
+		return errUsage
+	}
+	filename := flags.Arg(0)
+	listings, repairs, err := readListingFile(filename)
+	if err != nil {
+		return err
+	}
+	old := make([]string, len(listings))
+	for i, l := range listings {
+		old[i] = l.TitleSlug
+	}
+	changed := listing.AssignSlugs(listings, *maxLength, *all)
+	for i, l := range listings {
+		if l.TitleSlug != old[i] {
+			fmt.Printf("%q: %q -> %q\n", l.Title, old[i], l.TitleSlug)
+		}
+	}
+	if changed == 0 || *dryRun {
+		log.Infof("%s: %d slugs to change", filename, changed)
+		return nil
+	}
+	log.Infof("%s: %d slugs changed", filename, changed)
+	return updateListingFile(filename, listings, repairs)
+}
+
+// repairsFile is where the repair command keeps the repairs of a file,
+// to undo them.
+func repairsFile(filename string) string {
+	return filename + ".repairs.json"
+}
+
+// saveRepairs adds repairs to those kept for a file.
+func saveRepairs(filename string, repairs []listing.Repair) error {
+	if len(repairs) == 0 {
+		return nil
+	}
+	var saved []listing.Repair
+	data, err := ioutil.ReadFile(repairsFile(filename))
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("%s: %v", repairsFile(filename), err)
+		}
+	case !os.IsNotExist(err):
+		return err
+	}
+	if data, err = json.MarshalIndent(append(saved, repairs...), "", "\t"); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(repairsFile(filename), data, 0644)
+}
+
+// repairCommand repairs the mojibake of a listing file, or undoes the
+// repairs with -undo.
+func repairCommand(args []string) error {
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	dryRun := flags.Bool("n", false, "report the repairs without writing the file")
+	undo := flags.Bool("undo", false, "undo the repairs made before")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	filename := flags.Arg(0)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var repairs []listing.Repair
+	if *undo {
+		saved, err := ioutil.ReadFile(repairsFile(filename))
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(saved, &repairs); err != nil {
+			return fmt.Errorf("%s: %v", repairsFile(filename), err)
+		}
+		if data, err = listing.RevertJSON(data, repairs); err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+	} else if data, repairs, err = listing.RepairJSON(data); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, repair := range repairs {
+		if *undo {
+			fmt.Printf("%s: %q -> %q\n", repair.Path, repair.After, repair.Before)
+		} else {
+			fmt.Printf("%s: %q -> %q\n", repair.Path, repair.Before, repair.After)
+		}
+	}
+	if len(repairs) == 0 || *dryRun {
+		log.Infof("%s: %d strings to change", filename, len(repairs))
+		return nil
+	}
+	if _, err = listing.Decode(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	// The repairs are saved before the file is replaced, so they can
+	// always be undone.
+	if !*undo {
+		if err = saveRepairs(filename, repairs); err != nil {
+			return err
+		}
+	}
+	temp := filename + ".tmp"
+	if err = ioutil.WriteFile(temp, data, 0644); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err = os.Rename(temp, filename); err != nil {
+		return err
+	}
+	if *undo {
+		log.Infof("%s: %d repairs undone", filename, len(repairs))
+		return os.Remove(repairsFile(filename))
+	}
+	log.Infof("%s: %d strings repaired, undo with -undo", filename, len(repairs))
+	return nil
+}
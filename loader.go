@@ -0,0 +1,192 @@
+/**
+ * Concurrent loading of many invoice files.
+ *
+ * Like ch.multiplyByTwo, a fixed number of workers read from an in channel
+ * and send to an out channel, and like gen in ctx.go the filenames are fed
+ * by a goroutine which returns once the context is cancelled.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// invoiceLoader reads invoice files in parallel.
+type invoiceLoader struct {
+	Workers     int  // defaults to the number of CPUs
+	StopOnError bool // cancel the remaining files after the first error
+	Options     []readOption
+}
+
+// LoadResult holds the invoices of all files read, in file order, and the
+// error of every file which failed. An invoice Id belongs to the first file
+// which has it; a later file with the same Id fails and adds nothing.
+type LoadResult struct {
+	Invoices []*Invoice
+	Errors   map[string]error
+	Loaded   int
+}
+
+type loadJob struct {
+	index    int
+	filename string
+}
+
+type loadOutcome struct {
+	loadJob
+	invoices []*Invoice
+	err      error
+}
+
+// feed sends the jobs to the returned channel until all are sent or ctx is
+// cancelled.
+func feed(ctx context.Context, filenames []string) <-chan loadJob {
+	jobs := make(chan loadJob)
+	go func() {
+		defer close(jobs)
+		for i, filename := range filenames {
+			select {
+			case <-ctx.Done():
+				return // returning not to leak the goroutine
+			case jobs <- loadJob{i, filename}:
+			}
+		}
+	}()
+	return jobs
+}
+
+func (l invoiceLoader) read(in <-chan loadJob, out chan<- loadOutcome) {
+	for job := range in {
+		invoices, err := readInvoiceFile(job.filename, l.Options...)
+		out <- loadOutcome{job, invoices, err}
+	}
+}
+
+// Load reads the files and merges their invoices. The error is ctx.Err()
+// when the context was cancelled, or the first file error when StopOnError
+// is set; per file errors are in the result either way.
+func (l invoiceLoader) Load(ctx context.Context, filenames []string) (*LoadResult, error) {
+	workers := l.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > len(filenames) {
+		workers = len(filenames)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	in := feed(ctx, filenames)
+	out := make(chan loadOutcome)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			l.read(in, out)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	result := &LoadResult{Errors: make(map[string]error)}
+	perFile := make([][]*Invoice, len(filenames))
+	var firstErr error
+	for outcome := range out {
+		if outcome.err != nil {
+			result.Errors[outcome.filename] = outcome.err
+			if l.StopOnError && firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", outcome.filename, outcome.err)
+				cancel()
+			}
+			continue
+		}
+		perFile[outcome.index] = outcome.invoices
+		result.Loaded++
+	}
+	owners := make(map[int]string) // file of every invoice Id merged
+merge:
+	for i, invoices := range perFile {
+		for _, invoice := range invoices {
+			if owner, ok := owners[invoice.Id]; ok {
+				err := fmt.Errorf("invoice %d is also in %s", invoice.Id, owner)
+				result.Errors[filenames[i]] = err
+				result.Loaded--
+				if l.StopOnError && firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", filenames[i], err)
+				}
+				continue merge
+			}
+		}
+		for _, invoice := range invoices {
+			owners[invoice.Id] = filenames[i]
+		}
+		result.Invoices = append(result.Invoices, invoices...)
+	}
+	if firstErr != nil {
+		return result, firstErr
+	}
+	return result, ctx.Err()
+}
+
+// expandGlobs replaces shell patterns by the files they match, for shells
+// which do not expand them.
+func expandGlobs(patterns []string) ([]string, error) {
+	var filenames []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if matches == nil {
+			matches = []string{pattern}
+		}
+		filenames = append(filenames, matches...)
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+func loadCommand(args []string) error {
+	flags := flag.NewFlagSet("load", flag.ContinueOnError)
+	workers := flags.Int("workers", runtime.NumCPU(), "files read in parallel")
+	failFast := flags.Bool("fail-fast", false, "stop at the first file which fails")
+	timeout := flags.Duration("timeout", 0, "give up after this long, e.g. 30s")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	filenames, err := expandGlobs(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(filenames) == 0 {
+		return errUsage
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	loader := invoiceLoader{Workers: *workers, StopOnError: *failFast}
+	result, err := loader.Load(ctx, filenames)
+	for filename, fileErr := range result.Errors {
+		log.Errorf("%s: %v", filename, fileErr)
+	}
+	log.Infof("Loaded %d invoices from %d of %d files in %v", len(result.Invoices),
+		result.Loaded, len(filenames), time.Since(start))
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeLoaderFiles writes a file per list of Ids and returns their names.
+func writeLoaderFiles(t *testing.T, dir string, ids ...[]int) []string {
+	var filenames []string
+	for i, fileIds := range ids {
+		var invoices []*Invoice
+		for _, id := range fileIds {
+			invoices = append(invoices, testInvoice(id, ""))
+		}
+		filename := filepath.Join(dir, fmt.Sprintf("%02d.json", i))
+		if err := writeInvoiceFile(filename, invoices); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	return filenames
+}
+
+func idsOf(invoices []*Invoice) string {
+	var ids []string
+	for _, invoice := range invoices {
+		ids = append(ids, fmt.Sprint(invoice.Id))
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestLoadKeepsFileOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var ids [][]int
+	var want []string
+	for i := 0; i < 20; i++ {
+		ids = append(ids, []int{2*i + 1, 2*i + 2})
+		want = append(want, fmt.Sprintf("%d,%d", 2*i+1, 2*i+2))
+	}
+	filenames := writeLoaderFiles(t, dir, ids...)
+
+	result, err := invoiceLoader{Workers: 4}.Load(context.Background(), filenames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idsOf(result.Invoices); got != strings.Join(want, ",") {
+		t.Errorf("got Ids %s, want %s", got, strings.Join(want, ","))
+	}
+	if result.Loaded != len(filenames) || len(result.Errors) != 0 {
+		t.Errorf("loaded %d files with %v, want %d without errors", result.Loaded, result.Errors, len(filenames))
+	}
+}
+
+func TestLoadErrorsBelongToTheirFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filenames := writeLoaderFiles(t, dir, []int{1}, []int{2}, []int{3})
+	broken := filepath.Join(dir, "01b.json")
+	if err := ioutil.WriteFile(broken, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.json")
+	filenames = append(filenames, broken, missing)
+
+	result, err := invoiceLoader{Workers: 2}.Load(context.Background(), filenames)
+	if err != nil {
+		t.Fatalf("got %v, want the file errors in the result only", err)
+	}
+	if len(result.Errors) != 2 || result.Errors[broken] == nil || result.Errors[missing] == nil {
+		t.Errorf("got errors %v, want one for %s and one for %s", result.Errors, broken, missing)
+	}
+	if got := idsOf(result.Invoices); got != "1,2,3" || result.Loaded != 3 {
+		t.Errorf("got Ids %s from %d files, want 1,2,3 from 3", got, result.Loaded)
+	}
+
+	result, err = invoiceLoader{Workers: 1, StopOnError: true}.Load(context.Background(), filenames)
+	if err == nil || !strings.Contains(err.Error(), broken) {
+		t.Fatalf("got %v, want the error of %s", err, broken)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the file error rather than the cancellation", err)
+	}
+	if result.Errors[broken] == nil {
+		t.Errorf("the error of %s is missing from %v", broken, result.Errors)
+	}
+}
+
+func TestLoadCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var ids [][]int
+	for i := 1; i <= 50; i++ {
+		ids = append(ids, []int{i})
+	}
+	filenames := writeLoaderFiles(t, dir, ids...)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := invoiceLoader{Workers: 2}.Load(ctx, filenames)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	if result.Loaded == len(filenames) {
+		t.Errorf("all %d files were read after the cancellation", result.Loaded)
+	}
+}
+
+func TestLoadRefusesIdsOfEarlierFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filenames := writeLoaderFiles(t, dir, []int{1, 2}, []int{3, 1}, []int{4})
+
+	result, err := invoiceLoader{}.Load(context.Background(), filenames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idsOf(result.Invoices); got != "1,2,4" || result.Loaded != 2 {
+		t.Errorf("got Ids %s from %d files, want 1,2,4 from 2", got, result.Loaded)
+	}
+	if fileErr := result.Errors[filenames[1]]; fileErr == nil || !strings.Contains(fileErr.Error(), "invoice 1 is also in "+filenames[0]) {
+		t.Errorf("got %v for %s, want invoice 1 refused", fileErr, filenames[1])
+	}
+
+	_, err = invoiceLoader{StopOnError: true}.Load(context.Background(), filenames)
+	if err == nil || !strings.Contains(err.Error(), filenames[1]) {
+		t.Errorf("got %v, want the duplicate of %s", err, filenames[1])
+	}
+}
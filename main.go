@@ -5,6 +5,7 @@
 package main
 
 import (
+	"os"
 	"sync"
 
 	"github.com/icodebb/go-play-ground/ch"
@@ -26,11 +27,29 @@ func main() {
 	// fmt.Printf("⌘ and %v 世界\n", "\U00002714")
 
 	m := map[int]fn{
-		0: utils.MyVersion,
-		1: SimpleTest,
-		2: num.NumTest,
-		3: dt.TestTime,
-		4: ch.TestChennel,
+		0:  utils.MyVersion,
+		1:  SimpleTest,
+		2:  num.NumTest,
+		3:  dt.TestTime,
+		4:  ch.TestChennel,
+		10: AuditHistory,
+		11: DiffInvoices,
+		12: MergeInvoices,
+		13: Reports,
+		14: CashFlowForecast,
+		15: Dunning,
+		16: Reconcile,
+		17: InvoiceEditor,
+		18: SearchNotes,
+		19: BrowseCategories,
+	}
+
+	// Run a single command when one is given, see cmd.go.
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
 	}
 
 	log.Infoln("Start")
@@ -39,11 +58,10 @@ func main() {
 	for {
 		r := menu.PrintMenu()
 
-		if r != 99 {
-			m[r]()
-		} else {
-			return
+		if r == 99 {
+			break
 		}
+		m[r]()
 	}
 
 	// wg.Add(1)
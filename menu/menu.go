@@ -36,6 +36,16 @@ func PrintMenu() int {
 		{Target: "Habanero", Description: "100000", Index: 7},
 		{Target: "Red Savina Habanero", Description: "350000", Index: 8},
 		{Target: "Dragon’s Breath", Description: "855000", Index: 9},
+		{Target: "Audit History", Description: "Show the changes of an invoice from a journal.", Index: 10},
+		{Target: "Diff Invoices", Description: "Compare two invoice files.", Index: 11},
+		{Target: "Merge Invoices", Description: "Three-way merge of two edited invoice files.", Index: 12},
+		{Target: "Reports", Description: "Revenue, top customers and products, days to pay.", Index: 13},
+		{Target: "Cash-flow Forecast", Description: "Expected receivables for the coming weeks.", Index: 14},
+		{Target: "Dunning", Description: "Reminders and late fees for overdue invoices.", Index: 15},
+		{Target: "Reconcile", Description: "Match bank statement credits with open invoices.", Index: 16},
+		{Target: "Invoice Editor", Description: "Create, view, edit and delete invoices of a file.", Index: 17},
+		{Target: "Search Notes", Description: "Full-text search over invoice and item notes.", Index: 18},
+		{Target: "Categories", Description: "Browse, rename and move the categories of listings.", Index: 19},
 		{Target: "Exit", Description: "Exit the program.", Index: 99},
 	}
 
@@ -78,3 +88,34 @@ func PrintMenu() int {
 	log.Infof("You chose %s with index: %d\n", choices[i].Target, choices[i].Index)
 	return choices[i].Index
 }
+
+// Ask prompts for a line of input. validate may be nil.
+func Ask(label, defaultValue string, validate func(string) error) (string, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		Default:   defaultValue,
+		AllowEdit: true,
+		Validate:  validate,
+	}
+	return prompt.Run()
+}
+
+// AskPassword prompts for a secret without echoing it.
+func AskPassword(label string) (string, error) {
+	prompt := promptui.Prompt{
+		Label: label,
+		Mask:  '*',
+	}
+	return prompt.Run()
+}
+
+// Choose lets the user pick one of items and returns its index.
+func Choose(label string, items []string) (int, error) {
+	prompt := promptui.Select{
+		Label: label,
+		Items: items,
+		Size:  10,
+	}
+	i, _, err := prompt.Run()
+	return i, err
+}
@@ -0,0 +1,255 @@
+/**
+ * Invoice file schema migrations.
+ *
+ * Documents older than fileVersion are decoded into plain JSON values and
+ * upgraded one registered step at a time, e.g. 90 -> 100, before they are
+ * decoded into Invoices. A new version of Invoice only needs a migration
+ * from the previous fileVersion.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rawInvoice is an invoice as plain JSON values, numbers kept as
+// json.Number so nothing is lost between steps.
+type rawInvoice = map[string]interface{}
+
+// migration upgrades documents of version From to version To.
+type migration struct {
+	From, To    int
+	Description string
+	Apply       func(invoices []rawInvoice) error
+}
+
+var migrations = make(map[int]migration)
+
+func registerMigration(m migration) {
+	if m.To <= m.From {
+		panic(fmt.Sprintf("migration from %d must go to a newer version, not %d", m.From, m.To))
+	}
+	if _, ok := migrations[m.From]; ok {
+		panic(fmt.Sprintf("migration from version %d registered twice", m.From))
+	}
+	migrations[m.From] = m
+}
+
+func init() {
+	registerMigration(migration{
+		From:        90,
+		To:          100,
+		Description: "item prices are numbers instead of strings",
+		Apply:       migratePricesToNumbers,
+	})
+}
+
+// migrationPath returns the steps from version to fileVersion.
+func migrationPath(version int) ([]migration, error) {
+	var path []migration
+	for version < fileVersion {
+		m, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from version %d", version)
+		}
+		path = append(path, m)
+		version = m.To
+	}
+	if version != fileVersion {
+		return nil, fmt.Errorf("migrations end at version %d, not %d", version, fileVersion)
+	}
+	return path, nil
+}
+
+// decodeMigrated decodes the invoice array of a version document and
+// upgrades it to the current Invoice.
+func decodeMigrated(decoder *json.Decoder, version int) ([]*Invoice, error) {
+	path, err := migrationPath(version)
+	if err != nil {
+		return nil, err
+	}
+	decoder.UseNumber()
+	var raw []rawInvoice
+	if err = decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+	for _, m := range path {
+		if err = m.Apply(raw); err != nil {
+			return nil, fmt.Errorf("migrating version %d to %d: %v", m.From, m.To, err)
+		}
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	var invoices []*Invoice
+	err = json.Unmarshal(data, &invoices)
+	return invoices, err
+}
+
+// migratePricesToNumbers turns "Price": "415.80" into "Price": 415.8.
+func migratePricesToNumbers(invoices []rawInvoice) error {
+	for _, invoice := range invoices {
+		items, _ := invoice["Items"].([]interface{})
+		for _, value := range items {
+			item, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("invoice %v: malformed item", invoice["Id"])
+			}
+			text, ok := item["Price"].(string)
+			if !ok {
+				continue
+			}
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return fmt.Errorf("invoice %v: item %v: invalid price %q",
+					invoice["Id"], item["Id"], text)
+			}
+			item["Price"] = json.Number(text)
+		}
+	}
+	return nil
+}
+
+// readFileVersion returns the version stored in an invoice file.
+func readFileVersion(filename string, passphrase []byte) (int, error) {
+	reader, closer, err := openInvoiceFile(filename, passphrase)
+	if closer != nil {
+		defer closer()
+	}
+	if err != nil {
+		return 0, err
+	}
+	decoder := json.NewDecoder(reader)
+	var kind string
+	if err = decoder.Decode(&kind); err != nil {
+		return 0, err
+	}
+	if kind != fileType {
+		return 0, errors.New("cannot read non-invoices json file")
+	}
+	var version int
+	err = decoder.Decode(&version)
+	return version, err
+}
+
+func copyFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// sameContents reports whether two files hold the same bytes.
+func sameContents(a, b string) (bool, error) {
+	dataA, err := ioutil.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	dataB, err := ioutil.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(dataA, dataB), nil
+}
+
+// backupInvoiceFile copies a version file to FILE.vN.bak and returns the
+// name of the copy. An existing backup with the same contents is kept; one
+// with other contents is kept too, and the copy gets a timestamped name,
+// FILE.vN.20060102T150405.bak, instead.
+func backupInvoiceFile(filename string, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d.bak", filename, version)
+	err := copyFile(filename, backup)
+	if !os.IsExist(err) {
+		return backup, err
+	}
+	if same, err := sameContents(filename, backup); err != nil || same {
+		return backup, err
+	}
+	backup = fmt.Sprintf("%s.v%d.%s.bak", filename, version, time.Now().Format("20060102T150405"))
+	return backup, copyFile(filename, backup)
+}
+
+// upgradeInvoiceFile rewrites an older invoice file at fileVersion. The
+// original is kept as a backup, see backupInvoiceFile, and the new file
+// replaces it atomically. It returns the version the file had.
+func upgradeInvoiceFile(filename string, passphrase []byte) (int, error) {
+	version, err := readFileVersion(filename, passphrase)
+	if err != nil || version >= fileVersion {
+		return version, err
+	}
+	invoices, err := readInvoiceFile(filename, decryptWith(passphrase))
+	if err != nil {
+		return version, err
+	}
+	if _, err = backupInvoiceFile(filename, version); err != nil {
+		return version, fmt.Errorf("backing up: %v", err)
+	}
+	return version, replaceInvoiceFile(filename, invoices, encryptWith(passphrase))
+}
+
+func upgradeCommand(args []string) error {
+	flags := flag.NewFlagSet("upgrade", flag.ContinueOnError)
+	passphrase := flags.String("passphrase", "", "passphrase for .enc files")
+	list := flags.Bool("list", false, "list the registered migrations")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *list {
+		versions := make([]int, 0, len(migrations))
+		for from := range migrations {
+			versions = append(versions, from)
+		}
+		sort.Ints(versions)
+		for _, from := range versions {
+			m := migrations[from]
+			fmt.Printf("%d -> %d: %s\n", m.From, m.To, m.Description)
+		}
+		return nil
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	failed := 0
+	for _, filename := range flags.Args() {
+		version, err := upgradeInvoiceFile(filename, []byte(*passphrase))
+		switch {
+		case err != nil:
+			log.Errorf("%s: %v", filename, err)
+			failed++
+		case version >= fileVersion:
+			log.Infof("%s: already at version %d", filename, version)
+		default:
+			log.Infof("%s: upgraded from version %d to %d", filename, version, fileVersion)
+			if _, err = os.Stat(signatureFileOf(filename)); err == nil {
+				log.Warnf("%s: the signature no longer matches, sign the file again", filename)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to upgrade", failed, flags.NArg())
+	}
+	return nil
+}
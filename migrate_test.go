@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMigratePricesToNumbers(t *testing.T) {
+	tests := []struct {
+		name, items string
+		want        string
+		err         string
+	}{
+		{"string price", `[{"Id":"A","Price":"415.80"}]`, `[{"Id":"A","Price":415.80}]`, ""},
+		{"number price", `[{"Id":"A","Price":2.5}]`, `[{"Id":"A","Price":2.5}]`, ""},
+		{"no items", `null`, `null`, ""},
+		{"invalid price", `[{"Id":"A","Price":"ten"}]`, "", `invalid price "ten"`},
+		{"malformed item", `["A"]`, "", "malformed item"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoder := json.NewDecoder(strings.NewReader(`[{"Id":1,"Items":` + test.items + `}]`))
+			decoder.UseNumber()
+			var raw []rawInvoice
+			if err := decoder.Decode(&raw); err != nil {
+				t.Fatal(err)
+			}
+			err := migratePricesToNumbers(raw)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(raw[0]["Items"])
+			if string(got) != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMigrationPath(t *testing.T) {
+	tests := []struct {
+		version int
+		steps   int
+		err     bool
+	}{
+		{90, 1, false},
+		{fileVersion, 0, false},
+		{80, 0, true},
+		{95, 0, true},
+	}
+	for _, test := range tests {
+		path, err := migrationPath(test.version)
+		if len(path) != test.steps || (err != nil) != test.err {
+			t.Errorf("version %d: %d steps, %v, want %d steps", test.version, len(path), err, test.steps)
+		}
+	}
+}
+
+const version90File = `"INVOICES"
+90
+[{"Id":1,"CustomerId":7,"Raised":"2020-01-01","Due":"2020-01-31","Items":[{"Id":"A","Price":"10.50","Quantity":2}]}]
+`
+
+func TestUpgradeInvoiceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "invoices.json")
+	backups := func() []string {
+		matches, _ := filepath.Glob(filename + ".v90*.bak")
+		return matches
+	}
+
+	// upgrade writes a version 90 file and upgrades it. Doing it again is
+	// what happens after restoring a backup or a failed replace.
+	upgrade := func(contents string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		version, err := upgradeInvoiceFile(filename, nil)
+		if err != nil || version != 90 {
+			t.Fatalf("got version %d, %v", version, err)
+		}
+	}
+	upgrade(version90File)
+	invoices, err := readInvoiceFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices) != 1 || invoices[0].Total() != 21 {
+		t.Fatalf("upgraded to %d invoices", len(invoices))
+	}
+	if version, err := upgradeInvoiceFile(filename, nil); err != nil || version != fileVersion {
+		t.Errorf("upgrading a current file: version %d, %v", version, err)
+	}
+
+	upgrade(version90File)
+	if got := backups(); len(got) != 1 {
+		t.Errorf("the same file again made backups %v, want one", got)
+	}
+	upgrade(strings.Replace(version90File, `"10.50"`, `"11.50"`, 1))
+	if got := backups(); len(got) != 2 {
+		t.Errorf("a changed file made backups %v, want two", got)
+	}
+	if data, _ := ioutil.ReadFile(filename + ".v90.bak"); string(data) != version90File {
+		t.Errorf("the first backup was overwritten with %q", data)
+	}
+}
@@ -0,0 +1,51 @@
+/**
+ * Amounts written with a decimal point or a decimal comma.
+ */
+
+package num
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	pointAmount        = regexp.MustCompile(`^\d+(\.\d+)?$`)              // 1234.56
+	commaAmount        = regexp.MustCompile(`^\d+,\d+$`)                  // 1234,56
+	commaGroupedAmount = regexp.MustCompile(`^\d{1,3}(,\d{3})+(\.\d+)?$`) // 1,234.56
+	pointGroupedAmount = regexp.MustCompile(`^\d{1,3}(\.\d{3})+(,\d+)?$`) // 1.234,56
+	ambiguousAmount    = regexp.MustCompile(`^\d{1,3},\d{3}$`)            // 1,234
+)
+
+// ParseAmount reads an amount with either a decimal point or a decimal
+// comma, and optionally thousands separated by the other. A single point
+// is a decimal point. A single comma followed by three digits may be
+// either and is refused.
+func ParseAmount(text string) (float64, error) {
+	digits := strings.TrimLeft(text, "+-")
+	switch {
+	case len(text)-len(digits) > 1:
+		return 0, fmt.Errorf("invalid amount %q", text)
+	case pointAmount.MatchString(digits):
+	case ambiguousAmount.MatchString(digits):
+		return 0, fmt.Errorf("ambiguous amount %q, write it with a decimal separator", text)
+	case commaGroupedAmount.MatchString(digits):
+		digits = strings.Replace(digits, ",", "", -1)
+	case pointGroupedAmount.MatchString(digits):
+		digits = strings.Replace(strings.Replace(digits, ".", "", -1), ",", ".", 1)
+	case commaAmount.MatchString(digits):
+		digits = strings.Replace(digits, ",", ".", 1)
+	default:
+		return 0, fmt.Errorf("invalid amount %q", text)
+	}
+	amount, err := strconv.ParseFloat(digits, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", text)
+	}
+	if strings.HasPrefix(text, "-") {
+		amount = -amount
+	}
+	return amount, nil
+}
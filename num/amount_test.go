@@ -0,0 +1,39 @@
+package num
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+		err  string
+	}{
+		{"123.45", 123.45, ""},
+		{"123", 123, ""},
+		{"123,45", 123.45, ""},
+		{"12,5", 12.5, ""},
+		{"1,234.56", 1234.56, ""},
+		{"1,234,567", 1234567, ""},
+		{"1.234,56", 1234.56, ""},
+		{"1.234.567", 1234567, ""},
+		{"-1.234,56", -1234.56, ""},
+		{"+10", 10, ""},
+		{"1,234", 0, "ambiguous"},
+		{"12,34,56", 0, "invalid"},
+		{"1,2345.6", 0, "invalid"},
+		{"--5", 0, "invalid"},
+		{"abc", 0, "invalid"},
+	}
+	for _, test := range tests {
+		got, err := ParseAmount(test.text)
+		switch {
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%q: got %v, %v, want an error with %q", test.text, got, err, test.err)
+		case test.err == "" && (err != nil || got != test.want):
+			t.Errorf("%q: got %v, %v, want %v", test.text, got, err, test.want)
+		}
+	}
+}
@@ -27,13 +27,39 @@ func RandomInt(min, max int) int {
 // RandomString generates a random string of A-Z chars with len = l
 func RandomString(len int) string {
 	// rand.Seed(time.Now().UnixNano())
+	return randomString(RandomInt, len)
+}
+
+func randomString(randomInt func(min, max int) int, len int) string {
 	bytes := make([]byte, len)
 	for i := 0; i < len; i++ {
-		bytes[i] = byte(RandomInt(65, 90))
+		bytes[i] = byte(randomInt(65, 90))
 	}
 	return string(bytes)
 }
 
+// Random has the same functions as the package but its own source, so the
+// same seed always gives the same sequence. It is not safe for concurrent
+// use.
+type Random struct {
+	source *rand.Rand
+}
+
+// NewRandom returns a Random seeded with seed.
+func NewRandom(seed int64) *Random {
+	return &Random{rand.New(rand.NewSource(seed))}
+}
+
+// RandomInt returns an int >= min, < max
+func (r *Random) RandomInt(min, max int) int {
+	return min + r.source.Intn(max-min)
+}
+
+// RandomString generates a random string of A-Z chars with len = l
+func (r *Random) RandomString(len int) string {
+	return randomString(r.RandomInt, len)
+}
+
 func NumTest() {
 	log.Infof("Random int:%v, string:%s", RandomInt(1, 10), RandomString(8))
 }
@@ -0,0 +1,376 @@
+/**
+ * Bank statement reconciliation.
+ *
+ * Credits of a bank statement CSV are matched against the open invoices.
+ * An invoice scores for a reference text containing its Id, for an amount
+ * equal to its balance and for a credit close to its Due date. A credit
+ * whose best invoice has both the Id and the amount, and no rival of the
+ * same score, is matched on its own; every other credit with candidates
+ * is ambiguous and reviewed by hand. Confirmed matches become payments.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/icodebb/go-play-ground/menu"
+	"github.com/icodebb/go-play-ground/num"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	referenceScore = 4 // the reference names the invoice
+	amountScore    = 3 // the credit settles the balance
+	dateScore      = 2 // the most a credit on the Due date gets
+)
+
+// bankCredit is an incoming payment of a bank statement.
+type bankCredit struct {
+	Line      int // in the CSV file, for messages
+	Date      time.Time
+	Amount    float64
+	Reference string
+}
+
+// matchCandidate is an invoice a credit may pay.
+type matchCandidate struct {
+	Invoice *Invoice
+	Score   float64
+	Reasons []string
+}
+
+// creditMatch holds the candidates of a credit, best first.
+type creditMatch struct {
+	Credit     bankCredit
+	Candidates []matchCandidate
+}
+
+// statementColumns are the header names accepted for each field.
+var statementColumns = map[string][]string{
+	"date":      {"date", "booking date", "value date"},
+	"amount":    {"amount", "credit"},
+	"reference": {"reference", "description", "text", "memo", "purpose"},
+}
+
+// readBankStatement reads the credits of a CSV statement. The first row
+// names the columns; debits and empty amounts are skipped.
+func readBankStatement(reader io.Reader) ([]bankCredit, error) {
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header: %v", err)
+	}
+	columns := make(map[string]int)
+	for field, names := range statementColumns {
+		for i, name := range header {
+			if containsString(names, strings.ToLower(strings.TrimSpace(name))) {
+				columns[field] = i
+				break
+			}
+		}
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("no %s column in the statement", field)
+		}
+	}
+	var credits []bankCredit
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return credits, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if field("amount") == "" {
+			continue
+		}
+		amount, err := num.ParseAmount(field("amount"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if amount <= 0 {
+			continue
+		}
+		date, err := time.Parse(dateFormat, field("date"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid date %q, use %s", line, field("date"), dateFormat)
+		}
+		credits = append(credits, bankCredit{line, date, amount, field("reference")})
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+var numberPattern = regexp.MustCompile(`\d+`)
+
+// referencedIds returns the numbers in a reference text.
+func referencedIds(reference string) map[int]bool {
+	ids := make(map[int]bool)
+	for _, text := range numberPattern.FindAllString(reference, -1) {
+		if id, err := strconv.Atoi(text); err == nil {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// scoreCredit rates the open invoices as payees of the credit. window is
+// how many days from Due still earn a date score.
+func scoreCredit(credit bankCredit, invoices []*Invoice, window int) []matchCandidate {
+	ids := referencedIds(credit.Reference)
+	var candidates []matchCandidate
+	for _, invoice := range invoices {
+		balance := invoice.Balance()
+		if balance < paidTolerance || credit.Date.Before(invoice.Raised) {
+			continue
+		}
+		candidate := matchCandidate{Invoice: invoice}
+		if ids[invoice.Id] {
+			candidate.Score += referenceScore
+			candidate.Reasons = append(candidate.Reasons, "reference")
+		}
+		if math.Abs(balance-credit.Amount) < paidTolerance {
+			candidate.Score += amountScore
+			candidate.Reasons = append(candidate.Reasons, "amount")
+		}
+		if candidate.Score == 0 {
+			continue
+		}
+		days := math.Abs(credit.Date.Sub(invoice.Due).Hours() / 24)
+		if window > 0 && days <= float64(window) {
+			candidate.Score += dateScore * (1 - days/float64(window))
+			candidate.Reasons = append(candidate.Reasons, fmt.Sprintf("%.0f days from due", days))
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	return candidates
+}
+
+// certain tells whether the best candidate can be taken without asking.
+func (m creditMatch) certain() bool {
+	if len(m.Candidates) == 0 || m.Candidates[0].Score < referenceScore+amountScore {
+		return false
+	}
+	return len(m.Candidates) == 1 || m.Candidates[1].Score < referenceScore+amountScore
+}
+
+// creditKey identifies a credit, or the payment it was recorded as, by
+// date, amount in cents and reference.
+func creditKey(date time.Time, amount float64, reference string) string {
+	return fmt.Sprintf("%s %.2f %s", date.Format(dateFormat), amount, reference)
+}
+
+// recordedCredits counts the payments of the invoices by creditKey.
+func recordedCredits(invoices []*Invoice) map[string]int {
+	counts := make(map[string]int)
+	for _, invoice := range invoices {
+		for _, payment := range invoice.Payments {
+			counts[creditKey(payment.Date, payment.Amount, payment.Reference)]++
+		}
+	}
+	return counts
+}
+
+// reconcile applies the certain matches in statement order and returns
+// the credits left for review and those without any candidate. Each
+// payment the invoices hold stands for one equal credit imported before,
+// so importing a statement again adds nothing while two equal credits
+// still make two payments.
+func reconcile(credits []bankCredit, invoices []*Invoice, window int) (matched int, review []creditMatch, unmatched []bankCredit) {
+	recorded := recordedCredits(invoices)
+	for _, credit := range credits {
+		key := creditKey(credit.Date, credit.Amount, credit.Reference)
+		if recorded[key] > 0 {
+			recorded[key]--
+			continue
+		}
+		match := creditMatch{credit, scoreCredit(credit, invoices, window)}
+		if match.certain() {
+			match.apply(match.Candidates[0].Invoice)
+			matched++
+		} else if len(match.Candidates) > 0 {
+			review = append(review, match)
+		} else {
+			unmatched = append(unmatched, credit)
+		}
+	}
+	return matched, review, unmatched
+}
+
+func (m creditMatch) apply(invoice *Invoice) {
+	invoice.addPayment(&PaymentRecord{m.Credit.Date, m.Credit.Amount, m.Credit.Reference})
+}
+
+func (m creditMatch) String() string {
+	return fmt.Sprintf("line %d: %s %.2f %q", m.Credit.Line, m.Credit.Date.Format(dateFormat),
+		m.Credit.Amount, m.Credit.Reference)
+}
+
+func (c matchCandidate) String() string {
+	return fmt.Sprintf("invoice %d, customer %d, balance %.2f, due %s (%s)", c.Invoice.Id,
+		c.Invoice.CustomerId, c.Invoice.Balance(), c.Invoice.Due.Format(dateFormat),
+		strings.Join(c.Reasons, ", "))
+}
+
+// reviewMatches lets the user pick the invoice of each ambiguous credit.
+// Candidates are scored again since earlier choices change balances.
+func reviewMatches(review []creditMatch, invoices []*Invoice, window int) (int, error) {
+	confirmed := 0
+	for _, match := range review {
+		match.Candidates = scoreCredit(match.Credit, invoices, window)
+		if len(match.Candidates) == 0 {
+			continue
+		}
+		choices := make([]string, 0, len(match.Candidates)+1)
+		for _, candidate := range match.Candidates {
+			choices = append(choices, candidate.String())
+		}
+		choices = append(choices, "Skip")
+		i, err := menu.Choose(match.String(), choices)
+		if err != nil {
+			return confirmed, err
+		}
+		if i < len(match.Candidates) {
+			match.apply(match.Candidates[i].Invoice)
+			confirmed++
+		}
+	}
+	return confirmed, nil
+}
+
+func readStatementFile(filename string) ([]bankCredit, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	credits, err := readBankStatement(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return credits, nil
+}
+
+// Reconcile asks for a statement and an invoice file, matches the credits
+// and saves the payments.
+func Reconcile() {
+	statement, err := menu.Ask("Bank statement CSV", "", nil)
+	if err != nil {
+		return
+	}
+	filename, err := menu.Ask("Invoice file", "", nil)
+	if err != nil {
+		return
+	}
+	credits, err := readStatementFile(statement)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	invoices, err := readInvoiceFile(filename)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	journal, err := menu.Ask("Journal file", "invoices.journal", validateRequired)
+	if err != nil {
+		return
+	}
+	before := cloneInvoices(invoices)
+	matched, review, unmatched := reconcile(credits, invoices, 30)
+	log.Infof("Matched %d credits, %d to review, %d without an invoice", matched, len(review), len(unmatched))
+	// An aborted review keeps what was confirmed so far.
+	confirmed, _ := reviewMatches(review, invoices, 30)
+	if matched+confirmed == 0 {
+		return
+	}
+	if err = saveJournaled(journal, filename, "reconcile "+statement, before, invoices); err != nil {
+		log.Errorln(err)
+		return
+	}
+	log.Infof("Recorded %d payments in %s", matched+confirmed, filename)
+}
+
+func reconcileCommand(args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	window := flags.Int("window", 30, "days around Due which make a credit more likely")
+	interactive := flags.Bool("i", false, "review the ambiguous credits")
+	dryRun := flags.Bool("n", false, "show the matches without saving them")
+	passphrase := flags.String("passphrase", "", "passphrase for .enc files")
+	journal := flags.String("journal", "invoices.journal", "audit journal recording the payments")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 || *window < 0 {
+		return errUsage
+	}
+	credits, err := readStatementFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	filename := flags.Arg(1)
+	invoices, err := readInvoiceFile(filename, decryptWith([]byte(*passphrase)))
+	if err != nil {
+		return err
+	}
+	before := cloneInvoices(invoices)
+	matched, review, unmatched := reconcile(credits, invoices, *window)
+	if *interactive {
+		confirmed, err := reviewMatches(review, invoices, *window)
+		matched += confirmed
+		if err != nil {
+			return err
+		}
+		review = nil
+	}
+	for _, match := range review {
+		fmt.Printf("%s\n", match)
+		for _, candidate := range match.Candidates {
+			fmt.Printf("    %s\n", candidate)
+		}
+	}
+	for _, credit := range unmatched {
+		fmt.Printf("%s  (no invoice)\n", creditMatch{Credit: credit})
+	}
+	log.Infof("Matched %d of %d credits, %d left to review", matched, len(credits), len(review))
+	if !*dryRun && matched > 0 {
+		err = saveJournaled(*journal, filename, "reconcile "+flags.Arg(0), before, invoices,
+			encryptWith([]byte(*passphrase)))
+		if err != nil {
+			return err
+		}
+	}
+	if len(review) > 0 {
+		return errors.New("some credits need a review, run again with -i")
+	}
+	return nil
+}
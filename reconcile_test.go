@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcileCommandFailsWhileCreditsNeedReview(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statement := filepath.Join(dir, "statement.csv")
+	invoiceFile := filepath.Join(dir, "invoices.json")
+	// The credit names invoice 1 but is not its balance of 10, so it is
+	// left for review and nothing is matched.
+	csv := "Date,Amount,Reference\n2020-01-20,\"7,50\",Invoice 1\n"
+	if err = ioutil.WriteFile(statement, []byte(csv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeInvoiceFile(invoiceFile, []*Invoice{testInvoice(1, "")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{statement, invoiceFile}, {"-n", statement, invoiceFile}} {
+		if err = reconcileCommand(args); err == nil || !strings.Contains(err.Error(), "review") {
+			t.Errorf("%v: got %v, want an error asking for a review", args, err)
+		}
+	}
+}
+
+func TestReconcileEqualCredits(t *testing.T) {
+	date := time.Date(2020, 1, 20, 0, 0, 0, 0, time.UTC)
+	credit := bankCredit{Line: 2, Date: date, Amount: 10, Reference: "Invoice 1"}
+	payment := func() *PaymentRecord { return &PaymentRecord{date, 10, "Invoice 1"} }
+	tests := []struct {
+		name      string
+		recorded  []*PaymentRecord
+		credits   int
+		processed int
+	}{
+		{"one", nil, 1, 1},
+		{"two equal", nil, 2, 2},
+		{"imported again", []*PaymentRecord{payment()}, 1, 0},
+		{"one more than imported", []*PaymentRecord{payment()}, 2, 1},
+		{"both imported", []*PaymentRecord{payment(), payment()}, 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			invoice := testInvoice(1, "", test.recorded...)
+			invoice.Items[0].Quantity = 3 // 30, so the payments leave a balance
+			var credits []bankCredit
+			for i := 0; i < test.credits; i++ {
+				credits = append(credits, credit)
+			}
+			matched, review, unmatched := reconcile(credits, []*Invoice{invoice}, 30)
+			if got := matched + len(review) + len(unmatched); got != test.processed {
+				t.Errorf("%d credits were taken up, want %d", got, test.processed)
+			}
+		})
+	}
+}
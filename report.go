@@ -0,0 +1,398 @@
+/**
+ * Revenue and customer analytics over invoice sets.
+ *
+ * Revenue is counted when an invoice is raised. Days to pay run from Raised
+ * to the payment which settled the invoice, so invoices marked paid without
+ * recorded payments are left out of them. Amounts of different currencies
+ * are never summed: such invoices have to be converted into one first.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+const barWidth = 50
+
+// reportRow is one line of a report.
+type reportRow struct {
+	Label string
+	Count int
+	Value float64
+}
+
+// report is a titled list of rows which can be shown as a table, CSV or
+// bar chart.
+type report struct {
+	Title     string
+	LabelName string
+	ValueName string
+	Rows      []reportRow
+
+	ranked bool // the rows are ordered by value, see top
+}
+
+// reportKinds are the reports by name, in menu order. Reports of amounts
+// need invoices of one currency.
+var reportKinds = []struct {
+	name        string
+	description string
+	amounts     bool
+	build       func(invoices []*Invoice) report
+}{
+	{"month", "Revenue by month", true, func(invoices []*Invoice) report {
+		return revenueBy(invoices, "Revenue by month", "Month", monthOf)
+	}},
+	{"quarter", "Revenue by quarter", true, func(invoices []*Invoice) report {
+		return revenueBy(invoices, "Revenue by quarter", "Quarter", quarterOf)
+	}},
+	{"customer", "Revenue by customer", true, func(invoices []*Invoice) report {
+		return sortByValue(revenueBy(invoices, "Revenue by customer", "Customer", customerOf))
+	}},
+	{"sku", "Revenue by item SKU", true, revenueBySKU},
+	{"days-to-pay", "Average days to pay by customer", false, daysToPayByCustomer},
+}
+
+func monthOf(invoice *Invoice) string {
+	return invoice.Raised.Format("2006-01")
+}
+
+func quarterOf(invoice *Invoice) string {
+	return fmt.Sprintf("%d-Q%d", invoice.Raised.Year(), (int(invoice.Raised.Month())+2)/3)
+}
+
+func customerOf(invoice *Invoice) string {
+	return strconv.Itoa(invoice.CustomerId)
+}
+
+// revenueBy sums invoice totals by the key, ordered by key.
+func revenueBy(invoices []*Invoice, title, labelName string, key func(*Invoice) string) report {
+	rows := make(map[string]*reportRow)
+	for _, invoice := range invoices {
+		label := key(invoice)
+		row, ok := rows[label]
+		if !ok {
+			row = &reportRow{Label: label}
+			rows[label] = row
+		}
+		row.Count++
+		row.Value += invoice.Total()
+	}
+	return newReport(title, labelName, "Revenue", rows)
+}
+
+// revenueBySKU sums price times quantity by Item.Id, largest first.
+func revenueBySKU(invoices []*Invoice) report {
+	rows := make(map[string]*reportRow)
+	for _, invoice := range invoices {
+		for _, item := range invoice.Items {
+			row, ok := rows[item.Id]
+			if !ok {
+				row = &reportRow{Label: item.Id}
+				rows[item.Id] = row
+			}
+			row.Count += item.Quantity
+			row.Value += item.Price * float64(item.Quantity)
+		}
+	}
+	return sortByValue(newReport("Revenue by item SKU", "SKU", "Revenue", rows))
+}
+
+// paidOn returns when the invoice was settled, if that is known.
+func paidOn(invoice *Invoice) (time.Time, bool) {
+	if !invoice.Paid || len(invoice.Payments) == 0 {
+		return time.Time{}, false
+	}
+	last := invoice.Payments[0].Date
+	for _, payment := range invoice.Payments[1:] {
+		if payment.Date.After(last) {
+			last = payment.Date
+		}
+	}
+	return last, true
+}
+
+// daysToPay returns the days from Raised until the invoice was settled.
+func daysToPay(invoice *Invoice) (float64, bool) {
+	paid, ok := paidOn(invoice)
+	if !ok {
+		return 0, false
+	}
+	return paid.Sub(invoice.Raised).Hours() / 24, true
+}
+
+// daysToPayByCustomer averages the days to pay per customer, slowest first.
+// The first row is the average over all customers.
+func daysToPayByCustomer(invoices []*Invoice) report {
+	rows := make(map[string]*reportRow)
+	all := reportRow{Label: "All"}
+	for _, invoice := range invoices {
+		days, ok := daysToPay(invoice)
+		if !ok {
+			continue
+		}
+		label := customerOf(invoice)
+		row, ok := rows[label]
+		if !ok {
+			row = &reportRow{Label: label}
+			rows[label] = row
+		}
+		row.Count++
+		row.Value += days
+		all.Count++
+		all.Value += days
+	}
+	for _, row := range rows {
+		row.Value /= float64(row.Count)
+	}
+	if all.Count > 0 {
+		all.Value /= float64(all.Count)
+	}
+	r := sortByValue(newReport("Average days to pay", "Customer", "Days", rows))
+	r.Rows = append([]reportRow{all}, r.Rows...)
+	return r
+}
+
+func newReport(title, labelName, valueName string, rows map[string]*reportRow) report {
+	r := report{Title: title, LabelName: labelName, ValueName: valueName}
+	for _, row := range rows {
+		r.Rows = append(r.Rows, *row)
+	}
+	sort.Slice(r.Rows, func(i, j int) bool { return r.Rows[i].Label < r.Rows[j].Label })
+	return r
+}
+
+func sortByValue(r report) report {
+	sort.SliceStable(r.Rows, func(i, j int) bool { return r.Rows[i].Value > r.Rows[j].Value })
+	r.ranked = true
+	return r
+}
+
+// top keeps the first n rows, all of them when n is not positive. Only
+// reports ordered by value have a top; the first rows of a report by month
+// or quarter are merely the earliest.
+func (r report) top(n int) (report, error) {
+	if n <= 0 {
+		return r, nil
+	}
+	if !r.ranked {
+		return report{}, fmt.Errorf("%s is not ordered by value and has no top %d", strings.ToLower(r.Title), n)
+	}
+	if n < len(r.Rows) {
+		r.Rows = r.Rows[:n]
+		r.Title = fmt.Sprintf("%s, top %d", r.Title, n)
+	}
+	return r, nil
+}
+
+func (r report) writeTable(writer io.Writer) error {
+	fmt.Fprintf(writer, "%s\n\n", r.Title)
+	table := tabwriter.NewWriter(writer, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(table, "%s\tCount\t%s\t\n", r.LabelName, r.ValueName)
+	for _, row := range r.Rows {
+		fmt.Fprintf(table, "%s\t%d\t%.2f\t\n", row.Label, row.Count, row.Value)
+	}
+	return table.Flush()
+}
+
+func (r report) writeCSV(writer io.Writer) error {
+	w := csv.NewWriter(writer)
+	w.Write([]string{r.LabelName, "Count", r.ValueName})
+	for _, row := range r.Rows {
+		w.Write([]string{row.Label, strconv.Itoa(row.Count), strconv.FormatFloat(row.Value, 'f', 2, 64)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeBars draws a horizontal bar per row. Bars use '#' since not every
+// terminal shows block characters, see the menu package.
+func (r report) writeBars(writer io.Writer) error {
+	fmt.Fprintf(writer, "%s\n\n", r.Title)
+	labelWidth, max := len(r.LabelName), 0.0
+	for _, row := range r.Rows {
+		if len(row.Label) > labelWidth {
+			labelWidth = len(row.Label)
+		}
+		if row.Value > max {
+			max = row.Value
+		}
+	}
+	for _, row := range r.Rows {
+		width := 0
+		if max > 0 && row.Value > 0 {
+			width = int(row.Value/max*barWidth + 0.5)
+		}
+		_, err := fmt.Fprintf(writer, "%-*s |%-*s %.2f\n", labelWidth, row.Label,
+			barWidth, strings.Repeat("#", width), row.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r report) write(writer io.Writer, format string) error {
+	switch format {
+	case "table":
+		return r.writeTable(writer)
+	case "csv":
+		return r.writeCSV(writer)
+	case "bars":
+		return r.writeBars(writer)
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+// buildReport builds the report of the name. Reports of amounts refuse
+// invoices in more than one currency.
+func buildReport(name string, invoices []*Invoice) (report, error) {
+	for _, kind := range reportKinds {
+		if kind.name != name {
+			continue
+		}
+		if currencies := currenciesOf(invoices); kind.amounts && len(currencies) > 1 {
+			return report{}, fmt.Errorf("the invoices are in %s, convert them into one currency",
+				strings.Join(currencies, ", "))
+		}
+		return kind.build(invoices), nil
+	}
+	return report{}, fmt.Errorf("unknown report %q", name)
+}
+
+// loadInvoiceSet reads all invoices of the files or patterns.
+func loadInvoiceSet(patterns []string) ([]*Invoice, error) {
+	filenames, err := expandGlobs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	if len(filenames) == 0 {
+		return nil, errors.New("no invoice files given")
+	}
+	result, err := invoiceLoader{StopOnError: true}.Load(context.Background(), filenames)
+	if err != nil {
+		return nil, err
+	}
+	return result.Invoices, nil
+}
+
+// inCurrency converts the invoices into currency with the rate table in
+// rates. Without a currency they are returned as they are.
+func inCurrency(invoices []*Invoice, rates, base, currency string) ([]*Invoice, error) {
+	if currency == "" {
+		return invoices, nil
+	}
+	if err := validateCurrency(currency); err != nil {
+		return nil, err
+	}
+	table, err := loadRateTable(rates, base)
+	if err != nil {
+		return nil, err
+	}
+	return normalizeInvoices(invoices, table, currency)
+}
+
+// Reports asks for invoice files, a report and a format and shows it.
+func Reports() {
+	patterns, err := menu.Ask("Invoice files (patterns allowed)", "*.json*", nil)
+	if err != nil {
+		return
+	}
+	invoices, err := loadInvoiceSet(strings.Fields(patterns))
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	currency, err := menu.Ask("Convert into currency (empty for none)", "", validateCurrency)
+	if err != nil {
+		return
+	}
+	if currency != "" {
+		rates, err := menu.Ask("Exchange-rate table", "rates.json", validateRequired)
+		if err != nil {
+			return
+		}
+		if invoices, err = inCurrency(invoices, rates, "EUR", currency); err != nil {
+			log.Errorln(err)
+			return
+		}
+	}
+	names := make([]string, len(reportKinds))
+	for i, kind := range reportKinds {
+		names[i] = kind.description
+	}
+	kind, err := menu.Choose("Report", names)
+	if err != nil {
+		return
+	}
+	r, err := buildReport(reportKinds[kind].name, invoices)
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	formats := []string{"bars", "table", "csv"}
+	format, err := menu.Choose("Format", formats)
+	if err != nil {
+		return
+	}
+	if r.ranked {
+		topText, err := menu.Ask("Top N rows (0 for all)", "10", validateInt)
+		if err != nil {
+			return
+		}
+		top, _ := strconv.Atoi(topText)
+		if r, err = r.top(top); err != nil {
+			log.Errorln(err)
+			return
+		}
+	}
+	if err = r.write(os.Stdout, formats[format]); err != nil {
+		log.Errorln(err)
+	}
+}
+
+func reportCommand(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	by := flags.String("by", "month", "month, quarter, customer, sku or days-to-pay")
+	format := flags.String("format", "table", "table, csv or bars")
+	top := flags.Int("top", 0, "only the largest N rows, not for month or quarter")
+	currency := flags.String("currency", "", "convert all amounts into this currency")
+	rates := flags.String("rates", "rates.json", "exchange-rate table for -currency, JSON or CSV")
+	base := flags.String("base", "EUR", "base currency of CSV rate tables")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	invoices, err := loadInvoiceSet(flags.Args())
+	if err != nil {
+		return err
+	}
+	if invoices, err = inCurrency(invoices, *rates, *base, *currency); err != nil {
+		return err
+	}
+	r, err := buildReport(*by, invoices)
+	if err != nil {
+		return err
+	}
+	if r, err = r.top(*top); err != nil {
+		return err
+	}
+	return r.write(os.Stdout, *format)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reportInvoice is an invoice of the customer raised on the date with a
+// total of amount.
+func reportInvoice(id, customer int, raised string, amount float64, payments ...*PaymentRecord) *Invoice {
+	invoice := testInvoice(id, "", payments...)
+	invoice.CustomerId = customer
+	invoice.Raised, _ = time.Parse(dateFormat, raised)
+	invoice.Items[0].Price = amount
+	invoice.Paid = len(payments) > 0
+	return invoice
+}
+
+func paidOnDay(date string, amount float64) *PaymentRecord {
+	paid, _ := time.Parse(dateFormat, date)
+	return &PaymentRecord{Date: paid, Amount: amount}
+}
+
+func rowsOf(r report) string {
+	var rows []string
+	for _, row := range r.Rows {
+		rows = append(rows, fmt.Sprintf("%s %d %.2f", row.Label, row.Count, row.Value))
+	}
+	return strings.Join(rows, "; ")
+}
+
+func TestRevenueBy(t *testing.T) {
+	invoices := []*Invoice{
+		reportInvoice(1, 7, "2020-04-01", 30),
+		reportInvoice(2, 8, "2020-02-10", 20),
+		reportInvoice(3, 7, "2020-01-05", 10),
+		reportInvoice(4, 9, "2020-01-20", 5),
+	}
+	invoices[3].Items = append(invoices[3].Items, &Item{Id: "B", Price: 2.5, Quantity: 2})
+	tests := []struct {
+		by   string
+		want string
+	}{
+		{"month", "2020-01 2 20.00; 2020-02 1 20.00; 2020-04 1 30.00"},
+		{"quarter", "2020-Q1 3 40.00; 2020-Q2 1 30.00"},
+		{"customer", "7 2 40.00; 8 1 20.00; 9 1 10.00"},
+		{"sku", "A 4 65.00; B 2 5.00"},
+	}
+	for _, test := range tests {
+		r, err := buildReport(test.by, invoices)
+		if err != nil {
+			t.Fatalf("%s: %v", test.by, err)
+		}
+		if got := rowsOf(r); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.by, got, test.want)
+		}
+	}
+}
+
+func TestDaysToPayByCustomer(t *testing.T) {
+	invoices := []*Invoice{
+		// settled by the later of two payments, 20 days after Raised
+		reportInvoice(1, 7, "2020-01-01", 10, paidOnDay("2020-01-11", 5), paidOnDay("2020-01-21", 5)),
+		reportInvoice(2, 7, "2020-01-01", 10, paidOnDay("2020-01-31", 10)),
+		reportInvoice(3, 8, "2020-01-01", 10, paidOnDay("2020-01-06", 10)),
+		reportInvoice(4, 8, "2020-01-01", 10), // open
+		reportInvoice(5, 9, "2020-01-01", 10), // paid without payments
+	}
+	invoices[4].Paid = true
+
+	r := daysToPayByCustomer(invoices)
+	if got, want := rowsOf(r), "All 3 18.33; 7 2 25.00; 8 1 5.00"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got := rowsOf(daysToPayByCustomer(nil)); got != "All 0 0.00" {
+		t.Errorf("without invoices got %s, want All 0 0.00", got)
+	}
+}
+
+func TestBuildReportRefusesMixedCurrencies(t *testing.T) {
+	invoices := []*Invoice{
+		reportInvoice(1, 7, "2020-01-01", 10, paidOnDay("2020-01-11", 10)),
+		reportInvoice(2, 8, "2020-01-01", 10, paidOnDay("2020-01-21", 10)),
+	}
+	invoices[0].Currency, invoices[1].Currency = "EUR", "GBP"
+	for _, kind := range reportKinds {
+		_, err := buildReport(kind.name, invoices)
+		switch {
+		case kind.amounts && (err == nil || !strings.Contains(err.Error(), "EUR, GBP")):
+			t.Errorf("%s: got %v, want the currencies refused", kind.name, err)
+		case !kind.amounts && err != nil:
+			t.Errorf("%s: %v", kind.name, err)
+		}
+	}
+
+	invoices[1].Currency = "EUR"
+	if _, err := buildReport("month", invoices); err != nil {
+		t.Errorf("one currency: %v", err)
+	}
+}
+
+func TestReportTop(t *testing.T) {
+	invoices := []*Invoice{
+		reportInvoice(1, 7, "2020-01-01", 10),
+		reportInvoice(2, 8, "2020-02-01", 30),
+		reportInvoice(3, 9, "2020-03-01", 20),
+	}
+	tests := []struct {
+		by    string
+		n     int
+		title string
+		want  string
+		err   string
+	}{
+		{"customer", 2, "Revenue by customer, top 2", "8 1 30.00; 9 1 20.00", ""},
+		{"customer", 0, "Revenue by customer", "8 1 30.00; 9 1 20.00; 7 1 10.00", ""},
+		{"customer", 3, "Revenue by customer", "8 1 30.00; 9 1 20.00; 7 1 10.00", ""},
+		{"month", 0, "Revenue by month", "2020-01 1 10.00; 2020-02 1 30.00; 2020-03 1 20.00", ""},
+		{"month", 2, "", "", "not ordered by value"},
+		{"quarter", 1, "", "", "not ordered by value"},
+	}
+	for _, test := range tests {
+		r, err := buildReport(test.by, invoices)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err = r.top(test.n)
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s top %d: got %v, want an error with %q", test.by, test.n, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s top %d: %v", test.by, test.n, err)
+		case r.Title != test.title || rowsOf(r) != test.want:
+			t.Errorf("%s top %d: got %q %s, want %q %s", test.by, test.n, r.Title, rowsOf(r), test.title, test.want)
+		}
+	}
+}
+
+func TestReportWriters(t *testing.T) {
+	r := report{
+		Title:     "Revenue by customer",
+		LabelName: "Customer",
+		ValueName: "Revenue",
+		Rows: []reportRow{
+			{Label: "7", Count: 2, Value: 40},
+			{Label: "12", Count: 1, Value: 10.5},
+			{Label: "8", Count: 1, Value: 0},
+		},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "Revenue by customer\n\n" +
+			"  Customer  Count  Revenue\n" +
+			"         7      2    40.00\n" +
+			"        12      1    10.50\n" +
+			"         8      1     0.00\n"},
+		{"csv", "Customer,Count,Revenue\n7,2,40.00\n12,1,10.50\n8,1,0.00\n"},
+		{"bars", "Revenue by customer\n\n" +
+			"7        |" + strings.Repeat("#", 50) + " 40.00\n" +
+			"12       |" + strings.Repeat("#", 13) + strings.Repeat(" ", 37) + " 10.50\n" +
+			"8        |" + strings.Repeat(" ", 50) + " 0.00\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := r.write(&buf, test.format); err != nil {
+			t.Fatalf("%s: %v", test.format, err)
+		}
+		if buf.String() != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.format, buf.String(), test.want)
+		}
+	}
+	if err := r.write(&bytes.Buffer{}, "json"); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+}
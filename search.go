@@ -0,0 +1,330 @@
+/**
+ * Full-text search over invoice and item notes.
+ *
+ * Notes are split into lower-cased words of letters and digits and put in
+ * an inverted index from word to the notes holding it. Every query word
+ * must match, the last one as a prefix so "trade ent" finds "Use trade
+ * entrance". Hits are ranked by how rare their matched words are (idf) and
+ * how often they appear, whole-word matches counting more than prefixes.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/icodebb/go-play-ground/menu"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	snippetWords = 12  // words shown around the first hit
+	prefixWeight = 0.5 // of a prefix match relative to a whole word
+)
+
+// token is a word of a note and where it is, as byte offsets.
+type token struct {
+	Word       string
+	Start, End int
+}
+
+// tokenize splits text into case-folded words of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		wordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case wordRune && start < 0:
+			start = i
+		case !wordRune && start >= 0:
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// noteDoc is an indexed note: of an invoice, or of one of its items when
+// ItemId is set.
+type noteDoc struct {
+	File      string
+	InvoiceId int
+	ItemId    string
+	Text      string
+	tokens    []token
+}
+
+// noteIndex is an inverted index from words to the notes holding them.
+type noteIndex struct {
+	docs     []*noteDoc
+	postings map[string]map[int]int // word -> doc -> occurrences
+	words    []string               // sorted, for prefix lookups
+}
+
+func newNoteIndex() *noteIndex {
+	return &noteIndex{postings: make(map[string]map[int]int)}
+}
+
+func (index *noteIndex) addNote(doc *noteDoc) {
+	if strings.TrimSpace(doc.Text) == "" {
+		return
+	}
+	id := len(index.docs)
+	doc.tokens = tokenize(doc.Text)
+	index.docs = append(index.docs, doc)
+	for _, t := range doc.tokens {
+		docs, ok := index.postings[t.Word]
+		if !ok {
+			docs = make(map[int]int)
+			index.postings[t.Word] = docs
+			index.words = nil
+		}
+		docs[id]++
+	}
+}
+
+// addInvoices indexes the notes of the invoices read from file.
+func (index *noteIndex) addInvoices(file string, invoices []*Invoice) {
+	for _, invoice := range invoices {
+		index.addNote(&noteDoc{File: file, InvoiceId: invoice.Id, Text: invoice.Note})
+		for _, item := range invoice.Items {
+			index.addNote(&noteDoc{File: file, InvoiceId: invoice.Id, ItemId: item.Id, Text: item.Note})
+		}
+	}
+}
+
+// expand returns the indexed words starting with prefix.
+func (index *noteIndex) expand(prefix string) []string {
+	if index.words == nil {
+		index.words = make([]string, 0, len(index.postings))
+		for word := range index.postings {
+			index.words = append(index.words, word)
+		}
+		sort.Strings(index.words)
+	}
+	var words []string
+	for i := sort.SearchStrings(index.words, prefix); i < len(index.words); i++ {
+		if !strings.HasPrefix(index.words[i], prefix) {
+			break
+		}
+		words = append(words, index.words[i])
+	}
+	return words
+}
+
+// searchHit is a note matching a query.
+type searchHit struct {
+	Doc     *noteDoc
+	Score   float64
+	matched map[string]bool // the words which matched
+}
+
+// search returns the notes matching every word of query, best first.
+func (index *noteIndex) search(query string) []searchHit {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	hits := make(map[int]*searchHit)
+	for i, term := range terms {
+		candidates := []string{term.Word}
+		if i == len(terms)-1 {
+			candidates = index.expand(term.Word)
+		}
+		scores := make(map[int]float64)
+		matched := make(map[int][]string)
+		for _, word := range candidates {
+			docs := index.postings[word]
+			idf := math.Log(1 + float64(len(index.docs))/float64(len(docs)))
+			weight := 1.0
+			if word != term.Word {
+				weight = prefixWeight
+			}
+			for doc, count := range docs {
+				scores[doc] += weight * idf * (1 + math.Log(float64(count)))
+				matched[doc] = append(matched[doc], word)
+			}
+		}
+		for doc, score := range scores {
+			hit, ok := hits[doc]
+			if !ok {
+				if i > 0 {
+					continue // missed an earlier word
+				}
+				hit = &searchHit{Doc: index.docs[doc], matched: make(map[string]bool)}
+				hits[doc] = hit
+			}
+			hit.Score += score
+			for _, word := range matched[doc] {
+				hit.matched[word] = true
+			}
+		}
+		for doc := range hits {
+			if _, ok := scores[doc]; !ok {
+				delete(hits, doc)
+			}
+		}
+	}
+	results := make([]searchHit, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, *hit)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Doc.InvoiceId != results[j].Doc.InvoiceId {
+			return results[i].Doc.InvoiceId < results[j].Doc.InvoiceId
+		}
+		return results[i].Doc.ItemId < results[j].Doc.ItemId
+	})
+	return results
+}
+
+// snippet returns up to snippetWords words of the note around its first
+// matched word, with every matched word wrapped in open and close.
+func (hit searchHit) snippet(open, close string) string {
+	tokens := hit.Doc.tokens
+	first := 0
+	for i, t := range tokens {
+		if hit.matched[t.Word] {
+			first = i
+			break
+		}
+	}
+	from := first - snippetWords/3
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords
+	if to > len(tokens) {
+		to = len(tokens)
+	}
+	text := hit.Doc.Text
+	start, end := 0, len(text)
+	if from > 0 {
+		start = tokens[from].Start
+	}
+	if to < len(tokens) {
+		end = tokens[to-1].End
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("...")
+	}
+	last := start
+	for _, t := range tokens[from:to] {
+		if !hit.matched[t.Word] {
+			continue
+		}
+		b.WriteString(text[last:t.Start])
+		b.WriteString(open)
+		b.WriteString(text[t.Start:t.End])
+		b.WriteString(close)
+		last = t.End
+	}
+	b.WriteString(text[last:end])
+	if end < len(text) {
+		b.WriteString("...")
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func (hit searchHit) location() string {
+	if hit.Doc.ItemId != "" {
+		return fmt.Sprintf("%s: invoice %d, item %s", hit.Doc.File, hit.Doc.InvoiceId, hit.Doc.ItemId)
+	}
+	return fmt.Sprintf("%s: invoice %d", hit.Doc.File, hit.Doc.InvoiceId)
+}
+
+// buildNoteIndex indexes the notes of the files or patterns. Files which
+// cannot be read are logged and skipped.
+func buildNoteIndex(patterns []string) (*noteIndex, error) {
+	filenames, err := expandGlobs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	index := newNoteIndex()
+	for _, filename := range filenames {
+		invoices, err := readInvoiceFile(filename)
+		if err != nil {
+			log.Warnf("%s: %v", filename, err)
+			continue
+		}
+		index.addInvoices(filename, invoices)
+	}
+	return index, nil
+}
+
+// ANSI escapes to highlight matches, used when writing to a terminal.
+const (
+	highlightOn  = "\x1b[1;33m"
+	highlightOff = "\x1b[0m"
+)
+
+func printHits(hits []searchHit, limit int, open, close string) {
+	if len(hits) == 0 {
+		fmt.Println("No matches.")
+		return
+	}
+	for i, hit := range hits {
+		if limit > 0 && i == limit {
+			fmt.Printf("... and %d more\n", len(hits)-limit)
+			break
+		}
+		fmt.Printf("%5.2f  %s\n       %s\n", hit.Score, hit.location(), hit.snippet(open, close))
+	}
+}
+
+// SearchNotes asks for invoice files and searches their notes until an
+// empty query is given.
+func SearchNotes() {
+	patterns, err := menu.Ask("Invoice files (patterns allowed)", "*.json*", nil)
+	if err != nil {
+		return
+	}
+	index, err := buildNoteIndex(strings.Fields(patterns))
+	if err != nil {
+		log.Errorln(err)
+		return
+	}
+	log.Infof("Indexed %d notes, %d words", len(index.docs), len(index.postings))
+	for {
+		query, err := menu.Ask("Search (empty to stop)", "", nil)
+		if err != nil || strings.TrimSpace(query) == "" {
+			return
+		}
+		printHits(index.search(query), 20, highlightOn, highlightOff)
+	}
+}
+
+func searchCommand(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	limit := flags.Int("limit", 20, "show at most N hits, 0 for all")
+	color := flags.Bool("color", false, "highlight with terminal colours instead of [brackets]")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 2 {
+		return errUsage
+	}
+	index, err := buildNoteIndex(flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	open, close := "[", "]"
+	if *color {
+		open, close = highlightOn, highlightOff
+	}
+	printHits(index.search(flags.Arg(0)), *limit, open, close)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", "[]"},
+		{" -- ", "[]"},
+		{"Hello, world!", "[{hello 0 5} {world 7 12}]"},
+		{"10mm x2", "[{10mm 0 4} {x2 5 7}]"},
+		{"Ölçü café", "[{ölçü 0 7} {café 8 13}]"},
+		{"don't", "[{don 0 3} {t 4 5}]"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprint(tokenize(test.text)); got != test.want {
+			t.Errorf("%q: got %s, want %s", test.text, got, test.want)
+		}
+	}
+}
+
+func TestNoteIndexSearch(t *testing.T) {
+	index := newNoteIndex()
+	withItem := testInvoice(2, "Trade fair stand, trade discount")
+	withItem.Items[0].Id, withItem.Items[0].Note = "B", "Entrance fee"
+	index.addInvoices("a.json", []*Invoice{testInvoice(1, "Use trade entrance at the back"), withItem})
+	index.addInvoices("b.json", []*Invoice{testInvoice(3, "Ölçü 10mm, café"), testInvoice(4, "   ")})
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{"missing", nil},
+		{"trade missing", nil},
+		{"trade ent", []string{"a.json: invoice 1"}},
+		{"trade", []string{"a.json: invoice 2", "a.json: invoice 1"}},
+		{"entrance", []string{"a.json: invoice 1", "a.json: invoice 2, item B"}},
+		{"ENT", []string{"a.json: invoice 1", "a.json: invoice 2, item B"}},
+		{"CAFÉ", []string{"b.json: invoice 3"}},
+		{"ölç", []string{"b.json: invoice 3"}},
+		{"10", []string{"b.json: invoice 3"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, hit := range index.search(test.query) {
+			got = append(got, hit.location())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestSearchHitSnippet(t *testing.T) {
+	long := "one two three four five six seven eight nine ten eleven twelve thirteen fourteen fifteen"
+	tests := []struct {
+		note, query string
+		want        string
+	}{
+		{"Use trade entrance", "trade ent", "Use [trade] [entrance]"},
+		{long, "ten", "...six seven eight nine [ten] eleven twelve thirteen fourteen fifteen"},
+		{long, "two", "one [two] three four five six seven eight nine ten eleven twelve..."},
+	}
+	for _, test := range tests {
+		index := newNoteIndex()
+		index.addInvoices("a.json", []*Invoice{testInvoice(1, test.note)})
+		hits := index.search(test.query)
+		if len(hits) != 1 {
+			t.Fatalf("%q: got %d hits", test.query, len(hits))
+		}
+		if got := hits[0].snippet("[", "]"); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/**
+ * The shop command: faceted search over listings, see listing.Catalog.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/icodebb/go-play-ground/listing"
+)
+
+// valuesFlag collects the values of a flag given more than once.
+type valuesFlag []string
+
+func (values *valuesFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+func (values *valuesFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+func printFacets(result listing.Result, chosen map[string][]string) {
+	for _, facet := range listing.Facets() {
+		counts := result.Facets[facet]
+		if len(counts) == 0 {
+			continue
+		}
+		var parts []string
+		for _, count := range counts {
+			mark := ""
+			if containsString(chosen[facet], count.Value) {
+				mark = "*"
+			}
+			parts = append(parts, fmt.Sprintf("%s%s (%d)", mark, count.Value, count.Count))
+		}
+		fmt.Printf("%-9s %s\n", facet+":", strings.Join(parts, ", "))
+	}
+}
+
+func shopCommand(args []string) error {
+	flags := flag.NewFlagSet("shop", flag.ContinueOnError)
+	text := flags.String("q", "", "words of the title")
+	filters := make(map[string]*valuesFlag)
+	for _, facet := range listing.Facets() {
+		filters[facet] = &valuesFlag{}
+		flags.Var(filters[facet], facet, "only listings with this "+facet+", may be given more than once")
+	}
+	order := flags.String("sort", listing.SortRelevance, "relevance, price, -price, title, newest or ending")
+	page := flags.Int("page", 1, "page to show")
+	perPage := flags.Int("per-page", 20, "listings per page")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 || *page < 1 || *perPage < 1 {
+		return errUsage
+	}
+	listings, err := readListingFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	query := listing.Query{Text: *text, Filters: make(map[string][]string), Sort: *order, Page: *page, PerPage: *perPage}
+	for facet, values := range filters {
+		if len(*values) > 0 {
+			query.Filters[facet] = *values
+		}
+	}
+	result, err := listing.NewCatalog(listings).Search(query)
+	if err != nil {
+		return err
+	}
+	printFacets(result, query.Filters)
+	fmt.Println()
+	for _, l := range result.Listings {
+		var low, high float64
+		price := "-"
+		for i, variation := range l.Variations {
+			if i == 0 || variation.FixedPrice < low {
+				low = variation.FixedPrice
+			}
+			if i == 0 || variation.FixedPrice > high {
+				high = variation.FixedPrice
+			}
+		}
+		if len(l.Variations) > 0 {
+			price = fmt.Sprintf("%.2f", low)
+		}
+		if high != low {
+			price += fmt.Sprintf("-%.2f", high)
+		}
+		fmt.Printf("%15s %s  %s\n", price, l.Locale.CurrencyId, l.Title)
+	}
+	fmt.Printf("\n%d listings, page %d of %d\n", result.Total, result.Page, result.Pages)
+	return nil
+}
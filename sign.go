@@ -0,0 +1,321 @@
+/**
+ * Integrity signatures for invoice files.
+ *
+ * A signature is kept in a sidecar next to the invoice file, e.g.
+ * invoices.json.gz.sig, so the invoice file itself stays readable by
+ * openInvoiceFile. The signature covers the bytes on disk, i.e. after
+ * compression.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	signatureSuffix = ".sig"
+	algHMACSHA256   = "hmac-sha256"
+	algEd25519      = "ed25519"
+)
+
+// ErrBadSignature is returned when a signature does not match the file.
+var ErrBadSignature = errors.New("invoice file signature is invalid")
+
+// InvoiceSigner signs the content of an invoice file.
+type InvoiceSigner interface {
+	Algorithm() string
+	Sign(reader io.Reader) ([]byte, error)
+}
+
+// InvoiceVerifier checks a signature made by the matching InvoiceSigner.
+type InvoiceVerifier interface {
+	Algorithm() string
+	Verify(reader io.Reader, signature []byte) error
+}
+
+// Signature is the content of a signature sidecar file.
+type Signature struct {
+	Algorithm string
+	Signature string // base64 encoded
+}
+
+// HMACSigner signs and verifies with HMAC-SHA256 and a shared key.
+type HMACSigner struct {
+	Key []byte
+}
+
+// Ed25519Signer signs with an Ed25519 private key.
+type Ed25519Signer struct {
+	PrivateKey ed25519.PrivateKey
+}
+
+// Ed25519Verifier verifies with an Ed25519 public key.
+type Ed25519Verifier struct {
+	PublicKey ed25519.PublicKey
+}
+
+func (HMACSigner) Algorithm() string { return algHMACSHA256 }
+
+func (s HMACSigner) Sign(reader io.Reader) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.Key)
+	if _, err := io.Copy(mac, reader); err != nil {
+		return nil, err
+	}
+	return mac.Sum(nil), nil
+}
+
+func (s HMACSigner) Verify(reader io.Reader, signature []byte) error {
+	expected, err := s.Sign(reader)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func (Ed25519Signer) Algorithm() string { return algEd25519 }
+
+// Sign signs the SHA-256 digest of the content, so large files are
+// streamed rather than held in memory.
+func (s Ed25519Signer) Sign(reader io.Reader) ([]byte, error) {
+	digest, err := sha256Of(reader)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(s.PrivateKey, digest), nil
+}
+
+func (Ed25519Verifier) Algorithm() string { return algEd25519 }
+
+func (v Ed25519Verifier) Verify(reader io.Reader, signature []byte) error {
+	digest, err := sha256Of(reader)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(v.PublicKey, digest, signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func sha256Of(reader io.Reader) ([]byte, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+func signatureFileOf(filename string) string {
+	return filename + signatureSuffix
+}
+
+// signInvoiceFile signs an existing invoice file and writes the sidecar.
+func signInvoiceFile(filename string, signer InvoiceSigner) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	sum, err := signer.Sign(file)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(Signature{
+		Algorithm: signer.Algorithm(),
+		Signature: base64.StdEncoding.EncodeToString(sum),
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(signatureFileOf(filename), data, 0644)
+}
+
+// writeSignedInvoiceFile writes the invoices like writeInvoiceFile and then
+// signs the result.
+func writeSignedInvoiceFile(filename string, invoices []*Invoice, signer InvoiceSigner,
+	options ...writeOption) error {
+	if err := writeInvoiceFile(filename, invoices, options...); err != nil {
+		return err
+	}
+	return signInvoiceFile(filename, signer)
+}
+
+// verifyInvoiceFile checks filename against its signature sidecar.
+func verifyInvoiceFile(filename string, verifier InvoiceVerifier) error {
+	_, err := readVerifiedFile(filename, verifier)
+	return err
+}
+
+// readVerifiedFile reads filename once and checks those bytes against its
+// signature sidecar. Archive members have no sidecar and are refused.
+func readVerifiedFile(filename string, verifier InvoiceVerifier) ([]byte, error) {
+	if _, _, ok := splitArchivePath(filename); ok {
+		return nil, fmt.Errorf("%s: archive members cannot be verified, verify the archive", filename)
+	}
+	data, err := ioutil.ReadFile(signatureFileOf(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s: no signature found", filename)
+		}
+		return nil, err
+	}
+	var signature Signature
+	if err = json.Unmarshal(data, &signature); err != nil {
+		return nil, fmt.Errorf("%s: malformed signature: %v", filename, err)
+	}
+	if signature.Algorithm != verifier.Algorithm() {
+		return nil, fmt.Errorf("%s: signed with %s, expected %s", filename,
+			signature.Algorithm, verifier.Algorithm())
+	}
+	sum, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%s: malformed signature: %v", filename, err)
+	}
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return nil, err
+	}
+	if err = verifier.Verify(bytes.NewReader(data), sum); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return data, nil
+}
+
+// loadEd25519Key reads a key file base64 encoded as written by the keygen
+// command, white space around it ignored. HMAC key files are used byte
+// for byte instead, as they may hold any bytes.
+func loadEd25519Key(filename string, size int) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("%s: expected a %d byte key, got %d", filename, size, len(key))
+	}
+	return key, nil
+}
+
+// newSigner returns the signer for whichever key file is given.
+func newSigner(hmacKeyFile, ed25519KeyFile string) (InvoiceSigner, error) {
+	switch {
+	case hmacKeyFile != "":
+		key, err := ioutil.ReadFile(hmacKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return HMACSigner{key}, nil
+	case ed25519KeyFile != "":
+		key, err := loadEd25519Key(ed25519KeyFile, ed25519.PrivateKeySize)
+		if err != nil {
+			return nil, err
+		}
+		return Ed25519Signer{key}, nil
+	}
+	return nil, errors.New("no signing key given")
+}
+
+// newVerifier returns the verifier for whichever key file is given.
+func newVerifier(hmacKeyFile, ed25519PubFile string) (InvoiceVerifier, error) {
+	switch {
+	case hmacKeyFile != "":
+		key, err := ioutil.ReadFile(hmacKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return HMACSigner{key}, nil
+	case ed25519PubFile != "":
+		key, err := loadEd25519Key(ed25519PubFile, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, err
+		}
+		return Ed25519Verifier{key}, nil
+	}
+	return nil, errors.New("no verification key given")
+}
+
+// generateEd25519Keys writes a new key pair to name.key and name.pub.
+func generateEd25519Keys(name string) error {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return err
+	}
+	encode := base64.StdEncoding.EncodeToString
+	if err = ioutil.WriteFile(name+".key", []byte(encode(private)+"\n"), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+".pub", []byte(encode(public)+"\n"), 0644)
+}
+
+func keygenCommand(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return generateEd25519Keys(args[0])
+}
+
+func signCommand(args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	hmacKey := flags.String("hmac-key", "", "file holding the HMAC-SHA256 key")
+	ed25519Key := flags.String("ed25519-key", "", "file holding the Ed25519 private key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	signer, err := newSigner(*hmacKey, *ed25519Key)
+	if err != nil {
+		return err
+	}
+	for _, filename := range flags.Args() {
+		if err = signInvoiceFile(filename, signer); err != nil {
+			return err
+		}
+		log.Infof("Signed %s", filename)
+	}
+	return nil
+}
+
+func verifyCommand(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	hmacKey := flags.String("hmac-key", "", "file holding the HMAC-SHA256 key")
+	ed25519Pub := flags.String("ed25519-pub", "", "file holding the Ed25519 public key")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	verifier, err := newVerifier(*hmacKey, *ed25519Pub)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, filename := range flags.Args() {
+		if err = verifyInvoiceFile(filename, verifier); err != nil {
+			log.Errorln(err)
+			failed++
+			continue
+		}
+		log.Infof("%s: OK", filename)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed verification", failed, flags.NArg())
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// swappingVerifier replaces the file once it has verified it, as an
+// attacker racing the reader would.
+type swappingVerifier struct {
+	InvoiceVerifier
+	swap func()
+}
+
+func (v swappingVerifier) Verify(reader io.Reader, signature []byte) error {
+	err := v.InvoiceVerifier.Verify(reader, signature)
+	v.swap()
+	return err
+}
+
+func TestReadSignedInvoiceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "invoices.json")
+	forged := filepath.Join(dir, "forged.json")
+	if err = writeInvoiceFile(filename, []*Invoice{testInvoice(1, "signed")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeInvoiceFile(forged, []*Invoice{testInvoice(1, "forged")}); err != nil {
+		t.Fatal(err)
+	}
+	signer := HMACSigner{Key: []byte("key")}
+	if err = signInvoiceFile(filename, signer); err != nil {
+		t.Fatal(err)
+	}
+
+	invoices, err := readInvoiceFile(filename, requireSignature(signer))
+	if err != nil || len(invoices) != 1 || invoices[0].Note != "signed" {
+		t.Fatalf("signed file: %v", err)
+	}
+
+	swapped := swappingVerifier{signer, func() { os.Rename(forged, filename) }}
+	invoices, err = readInvoiceFile(filename, requireSignature(swapped))
+	if err != nil || len(invoices) != 1 || invoices[0].Note != "signed" {
+		t.Errorf("the file replaced after verifying was read: %v", err)
+	}
+	if _, err = readInvoiceFile(filename, requireSignature(signer)); err == nil {
+		t.Errorf("the forged file verified")
+	}
+
+	_, err = readInvoiceFile(filepath.Join(dir, "archive.tar.gz#invoices.json"), requireSignature(signer))
+	if err == nil || !strings.Contains(err.Error(), "archive members cannot be verified") {
+		t.Errorf("archive member: got %v", err)
+	}
+}
+
+func TestKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// HMAC keys are used byte for byte, white space included.
+	raw := []byte("\x00key\n\t ")
+	hmacKey := filepath.Join(dir, "secret.key")
+	if err = ioutil.WriteFile(hmacKey, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newSigner(hmacKey, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := signer.(HMACSigner).Key; !bytes.Equal(key, raw) {
+		t.Errorf("got HMAC key %q, want %q", key, raw)
+	}
+
+	// Ed25519 keys are base64 lines as keygen writes them.
+	name := filepath.Join(dir, "pair")
+	if err = generateEd25519Keys(name); err != nil {
+		t.Fatal(err)
+	}
+	if signer, err = newSigner("", name+".key"); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := newVerifier("", name+".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := signer.Sign(strings.NewReader("invoices"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verifier.Verify(strings.NewReader("invoices"), sum); err != nil {
+		t.Errorf("the keygen pair does not verify: %v", err)
+	}
+	if err = ioutil.WriteFile(name+".pub", []byte("c2hvcnQ=\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newVerifier("", name+".pub"); err == nil || !strings.Contains(err.Error(), "byte key") {
+		t.Errorf("got %v for a short key, want a size error", err)
+	}
+}